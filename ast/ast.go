@@ -30,10 +30,16 @@ type Expression interface {
 	expressionNode()
 }
 
-// Identifier represents a variable.
+// Identifier represents a variable.  Once the program has been resolved,
+// Resolved is true and the variable is the slot Index of the environment Depth
+// levels out from the one the identifier is evaluated in.
 type Identifier struct {
 	Token token.Token
 	Value string
+
+	Resolved bool
+	Depth    int
+	Index    int
 }
 
 func (i *Identifier) expressionNode() {}
@@ -66,6 +72,25 @@ func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
+// StringLiteral represents a parsed string.  Value has the escape sequences of
+// the source replaced.
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode() {}
+
+// TokenLiteral returns the value of the string.
+func (sl *StringLiteral) TokenLiteral() string {
+	return sl.Token.Literal
+}
+
+// String returns the value of the string.
+func (sl *StringLiteral) String() string {
+	return sl.Token.Literal
+}
+
 // PrefixExpression respresents a parsed prefix operator and its operand.  The
 // operand is always to the right of the prefix operator (e.g !isFull, -5).
 type PrefixExpression struct {
@@ -370,3 +395,420 @@ func (ce *CallExpression) String() string {
 
 	return out.String()
 }
+
+// DotExpression represents reading the property Name of the value of Left
+// (e.g. v.length).  Calling it calls the method Name instead, if the value has
+// one.  The token is the dot.
+type DotExpression struct {
+	Token token.Token
+	Left  Expression
+	Name  string
+}
+
+func (de *DotExpression) expressionNode() {}
+
+// TokenLiteral returns a string representation of the token used for
+// DotExpression.
+func (de *DotExpression) TokenLiteral() string {
+	return de.Token.Literal
+}
+
+func (de *DotExpression) String() string {
+	return "(" + de.Left.String() + "." + de.Name + ")"
+}
+
+// IndexExpression represents indexing the value of Left with the value of Index
+// (e.g. v[1]).  The token is the left bracket "[".
+type IndexExpression struct {
+	Token token.Token
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode() {}
+
+// TokenLiteral returns a string representation of the token used for
+// IndexExpression.
+func (ie *IndexExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+
+func (ie *IndexExpression) String() string {
+	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
+}
+
+// ArrayLiteral represents a list of expressions in brackets (e.g. [1, x + 2]).
+// The token is the left bracket "[".
+type ArrayLiteral struct {
+	Token    token.Token
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode() {}
+
+// TokenLiteral returns a string representation of the token used for
+// ArrayLiteral.
+func (al *ArrayLiteral) TokenLiteral() string {
+	return al.Token.Literal
+}
+
+func (al *ArrayLiteral) String() string {
+	elements := []string{}
+	for _, e := range al.Elements {
+		elements = append(elements, e.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashLiteral represents a list of "key: value" pairs in braces (e.g. {"a": 1,
+// x: y}).  The pairs are kept in source order.  The token is the left brace
+// "{".
+type HashLiteral struct {
+	Token token.Token
+	Pairs []*HashPair
+}
+
+// HashPair is a single "key: value" pair of a HashLiteral.
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (hl *HashLiteral) expressionNode() {}
+
+// TokenLiteral returns a string representation of the token used for
+// HashLiteral.
+func (hl *HashLiteral) TokenLiteral() string {
+	return hl.Token.Literal
+}
+
+func (hl *HashLiteral) String() string {
+	pairs := []string{}
+	for _, p := range hl.Pairs {
+		pairs = append(pairs, p.Key.String()+": "+p.Value.String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// MatchExpression represents a match expression that compares the value of
+// Subject against the pattern of each arm in order (e.g. match (x) { 1 => 10,
+// _ => 0 }).
+type MatchExpression struct {
+	Token   token.Token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode() {}
+
+// TokenLiteral returns the string representation of the token used for the
+// match expression.
+func (me *MatchExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+
+	out.WriteString("match")
+	out.WriteString("(")
+	out.WriteString(me.Subject.String())
+	out.WriteString(") { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// MatchArm represents a single "pattern => expression" arm of a match
+// expression.  A pattern is a literal, the wildcard "_", an identifier that
+// binds the matched value, an ArrayPattern or a HashPattern.  Guard is optional
+// and is only evaluated when the pattern matches.
+type MatchArm struct {
+	Token   token.Token
+	Pattern Expression
+	Guard   Expression
+	Body    Expression
+}
+
+// TokenLiteral returns the string representation of the first token of the
+// arm's pattern.
+func (ma *MatchArm) TokenLiteral() string {
+	return ma.Token.Literal
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// ArrayPattern represents a match pattern for arrays (e.g. [head, ..tail]).
+// It matches an array with exactly as many elements as Elements, each matching
+// its pattern.  If Rest isn't nil, the array may have more elements, and Rest
+// binds a new array of them.  The token is the left bracket "[".
+type ArrayPattern struct {
+	Token    token.Token
+	Elements []Expression
+	Rest     *Identifier
+}
+
+func (ap *ArrayPattern) expressionNode() {}
+
+// TokenLiteral returns a string representation of the token used for
+// ArrayPattern.
+func (ap *ArrayPattern) TokenLiteral() string {
+	return ap.Token.Literal
+}
+
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, e := range ap.Elements {
+		elements = append(elements, e.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, ".."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern represents a match pattern for hashes (e.g. {"name": n}).  It
+// matches a hash that has every key of Pairs, whose values match their
+// patterns.  The hash may have other keys.  Keys are literals.  The token is the
+// left brace "{".
+type HashPattern struct {
+	Token token.Token
+	Pairs []*HashPair
+}
+
+func (hp *HashPattern) expressionNode() {}
+
+// TokenLiteral returns a string representation of the token used for
+// HashPattern.
+func (hp *HashPattern) TokenLiteral() string {
+	return hp.Token.Literal
+}
+
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for _, p := range hp.Pairs {
+		pairs = append(pairs, p.Key.String()+": "+p.Value.String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// SwitchExpression represents a switch expression.  The value of the expression
+// is the value of the last statement of the first case that has a value equal
+// to Subject, or of Default if no case matches.  Cases never fall through.
+type SwitchExpression struct {
+	Token   token.Token
+	Subject Expression
+	Cases   []*SwitchCase
+	Default *BlockStatement
+}
+
+func (se *SwitchExpression) expressionNode() {}
+
+// TokenLiteral returns the string representation of the token used for the
+// switch expression.
+func (se *SwitchExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+
+func (se *SwitchExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("switch")
+	out.WriteString("(")
+	out.WriteString(se.Subject.String())
+	out.WriteString(") { ")
+
+	for _, c := range se.Cases {
+		out.WriteString(c.String())
+		out.WriteString(" ")
+	}
+
+	if se.Default != nil {
+		out.WriteString("default: ")
+		out.WriteString(se.Default.String())
+		out.WriteString(" ")
+	}
+
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// SwitchCase represents a "case value, ...: statements" branch of a switch
+// expression.
+type SwitchCase struct {
+	Token  token.Token
+	Values []Expression
+	Body   *BlockStatement
+}
+
+// TokenLiteral returns the string representation of the case keyword.
+func (sc *SwitchCase) TokenLiteral() string {
+	return sc.Token.Literal
+}
+
+func (sc *SwitchCase) String() string {
+	var out bytes.Buffer
+
+	values := []string{}
+	for _, v := range sc.Values {
+		values = append(values, v.String())
+	}
+
+	out.WriteString(sc.TokenLiteral() + " ")
+	out.WriteString(strings.Join(values, ", "))
+	out.WriteString(": ")
+	out.WriteString(sc.Body.String())
+
+	return out.String()
+}
+
+// ThrowStatement represents a throw statement (e.g. throw <expression>).
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+// TokenLiteral returns the actual text character used to represent the throw
+// token.
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+
+// String returns string representation of the throw statement.
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// TryExpression represents a try expression.  It has a block to evaluate, and
+// an optional catch block and an optional finally block, but at least one of
+// the two is present.  CatchParameter is bound to the caught error inside
+// Catch.
+type TryExpression struct {
+	Token          token.Token
+	Block          *BlockStatement
+	CatchParameter *Identifier
+	Catch          *BlockStatement
+	Finally        *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+
+// TokenLiteral returns the string representation of token used for the try
+// expression.
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString("catch(")
+		out.WriteString(te.CatchParameter.String())
+		out.WriteString(") ")
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString("finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+// DeferStatement represents a defer statement (e.g. defer <expression>).  The
+// expression is evaluated when the enclosing function call returns.
+type DeferStatement struct {
+	Token      token.Token
+	Expression Expression
+}
+
+func (ds *DeferStatement) statementNode() {}
+
+// TokenLiteral returns the actual text character used to represent the defer
+// token.
+func (ds *DeferStatement) TokenLiteral() string {
+	return ds.Token.Literal
+}
+
+// String returns string representation of the defer statement.
+func (ds *DeferStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ds.TokenLiteral() + " ")
+
+	if ds.Expression != nil {
+		out.WriteString(ds.Expression.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// TernaryExpression represents a conditional expression (e.g. x > 0 ? x : -x).
+// It is a shorter form of an if expression that has an alternative.
+type TernaryExpression struct {
+	Token       token.Token
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (te *TernaryExpression) expressionNode() {}
+
+// TokenLiteral returns the literal for the question mark operator.
+func (te *TernaryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+
+func (te *TernaryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(te.Condition.String())
+	out.WriteString(" ? ")
+	out.WriteString(te.Consequence.String())
+	out.WriteString(" : ")
+	out.WriteString(te.Alternative.String())
+	out.WriteString(")")
+
+	return out.String()
+}
@@ -0,0 +1,268 @@
+/*
+Package closure evaluates Monkey programs by first converting the abstract
+syntax tree into a tree of Go closures.  Every node is dispatched on only once,
+when it is compiled, and every identifier is resolved to a variable slot, so
+running the closures is faster than walking the tree with evaluator.Eval.
+
+To run:
+
+	globals := NewGlobals()
+	program, err := Compile(astProgram, globals)
+	result := program.Run()
+
+Programs compiled with the same Globals share global variables, like
+successive programs evaluated in the same environment.
+
+The values, including errors, are the same as the ones of evaluator.Eval.
+Like environments, each function call, match arm and catch block gets a frame
+of variable slots that points to the frame it is nested in.  Since an
+environment can bind a name after a closure refers to it, an identifier
+resolves to every slot that can bind it, innermost first, and reads the first
+one that is set.
+*/
+package closure
+
+import (
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// code is a compiled node.  It evaluates the node in frame f.
+type code func(f *frame) object.Object
+
+// frame holds the variable slots of a scope while it runs.
+type frame struct {
+	slots []object.Object
+	outer *frame
+
+	// call is the frame of the function call this frame belongs to.  It is
+	// nil for the main program.  depth is the call depth of a call frame.
+	call     *frame
+	deferred []deferred
+	depth    int
+}
+
+// deferred is a deferred expression together with the frame it was deferred
+// in.
+type deferred struct {
+	run   code
+	frame *frame
+}
+
+func newFrame(s *scope, outer *frame) *frame {
+	f := &frame{slots: make([]object.Object, len(s.names)), outer: outer}
+	if outer != nil {
+		f.call = outer.call
+	}
+	return f
+}
+
+// Globals holds the global variables shared by programs.  MaxCallDepth is the
+// maximum number of nested function calls of the programs.
+type Globals struct {
+	MaxCallDepth int
+
+	scope  *scope
+	values []object.Object
+
+	// env is the environment builtins are called with.
+	env *object.Environment
+}
+
+// NewGlobals returns a reference to a new, empty Globals.
+func NewGlobals() *Globals {
+	return &Globals{
+		MaxCallDepth: object.DefaultMaxCallDepth,
+		scope:        newScope(nil),
+		env:          object.NewEnvironment(),
+	}
+}
+
+// SetSandbox sets the sandbox that decides which builtins programs may call.
+func (g *Globals) SetSandbox(s *object.Sandbox) {
+	g.env.SetSandbox(s)
+}
+
+// SetStreams sets the input and output of the builtins programs call.
+func (g *Globals) SetStreams(s *object.Streams) {
+	g.env.SetStreams(s)
+}
+
+// Get returns the value of the global variable name.
+func (g *Globals) Get(name string) (object.Object, bool) {
+	index, ok := g.scope.slots[name]
+	if !ok || index >= len(g.values) || g.values[index] == nil {
+		return nil, false
+	}
+	return g.values[index], true
+}
+
+// Set binds val to the global variable name.
+func (g *Globals) Set(name string, val object.Object) {
+	index := g.scope.define(name)
+	if index >= len(g.values) {
+		values := make([]object.Object, len(g.scope.names))
+		copy(values, g.values)
+		g.values = values
+	}
+	g.values[index] = val
+}
+
+// Program is a compiled program.
+type Program struct {
+	run     code
+	globals *Globals
+}
+
+// Compile converts program into closures.  Global variables are resolved in
+// globals.  It returns an error if program has a node that can't be compiled.
+func Compile(program *ast.Program, globals *Globals) (*Program, error) {
+	c := &compiler{globals: globals}
+
+	c.declareStatements(globals.scope, program.Statements)
+	statements := c.statements(globals.scope, program.Statements)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	run := func(f *frame) object.Object {
+		var result object.Object
+
+		for _, s := range statements {
+			result = s(f)
+
+			switch r := result.(type) {
+			case *object.ReturnValue:
+				return r.Value
+			case *object.Error:
+				return r
+			}
+		}
+
+		return result
+	}
+
+	return &Program{run: run, globals: globals}, nil
+}
+
+// Run runs the program and returns its value, like evaluator.Eval.
+func (p *Program) Run() object.Object {
+	g := p.globals
+	if n := len(g.scope.names); len(g.values) < n {
+		values := make([]object.Object, n)
+		copy(values, g.values)
+		g.values = values
+	}
+
+	return p.run(&frame{})
+}
+
+// Function is a function created by compiled code.  Like object.Function, it
+// closes over the frame it was created in.
+type Function struct {
+	Literal *ast.FunctionLiteral
+
+	scope *scope
+	body  code
+	frame *frame
+}
+
+// Type returns the Function type.
+func (fn *Function) Type() object.ObjectType {
+	return object.FUNCTION_OBJ
+}
+
+// Inspect returns the string representation of the Function type.
+func (fn *Function) Inspect() string {
+	f := &object.Function{Parameters: fn.Literal.Parameters, Body: fn.Literal.Body}
+	return f.Inspect()
+}
+
+// call runs fn in frame f, whose first slots hold the arguments, and then the
+// tail calls that it returns, each in a frame of its own.  depth is the call
+// depth of all of them.
+func (fn *Function) call(f *frame, depth int) object.Object {
+	var calls tailCalls
+	name := ""
+
+	for {
+		f.call = f
+		f.depth = depth
+
+		result := fn.body(f)
+		calls.push(name, f)
+
+		tc, ok := result.(*tailCall)
+		if !ok {
+			return calls.unwind(result)
+		}
+		fn, f, name = tc.fn, tc.frame, tc.name
+	}
+}
+
+// runDeferred runs the deferred expressions of the call frame f, last first.
+// The error raised by one replaces result.
+func runDeferred(f *frame, result object.Object) object.Object {
+	for i := len(f.deferred) - 1; i >= 0; i-- {
+		d := f.deferred[i]
+
+		evaluated := d.run(d.frame)
+		if isError(evaluated) {
+			result = evaluated
+		}
+	}
+
+	return result
+}
+
+// Singleton values, shared with the evaluator so that both return the same
+// objects.
+var (
+	TRUE  = evaluator.TRUE
+	FALSE = evaluator.FALSE
+	NULL  = evaluator.NULL
+)
+
+func isError(obj object.Object) bool {
+	_, ok := obj.(*object.Error)
+	return ok
+}
+
+// isReturnOrError reports whether obj stops the evaluation of a block.  Tail
+// calls do too.
+func isReturnOrError(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.ReturnValue, *object.Error, *tailCall:
+		return true
+	}
+	return false
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case NULL, FALSE:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
+// canonical replaces the booleans and nulls returned by host objects with the
+// singletons, like the evaluator does.
+func canonical(obj object.Object) object.Object {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return NULL
+	case *object.Boolean:
+		return nativeBoolToBooleanObject(obj.Value)
+	}
+	return obj
+}
@@ -0,0 +1,312 @@
+package closure
+
+import (
+	"monkey/ast"
+	bytecode "monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"testing"
+)
+
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+	return compile(t, parse(input), NewGlobals()).Run()
+}
+
+func compile(t testing.TB, program *ast.Program, globals *Globals) *Program {
+	t.Helper()
+	compiled, err := Compile(program, globals)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	return compiled
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+// TestAgainstEvaluator checks that compiled programs evaluate to the same value
+// as the tree-walking evaluator.
+func TestAgainstEvaluator(t *testing.T) {
+	tests := []string{
+		"5 * (2 + 10) - -3",
+		"!(1 > 2) == true",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (false) { 10 }",
+		"let a = 5; let b = a * 2; let a = b + 1; a",
+		"let f = fn(x) { return x * 2; 0 }; f(3) + f(4)",
+		"let add = fn(a) { fn(b) { a + b } }; add(2)(3)",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+		"let f = fn() { x }; let x = 5; f()",
+		// Closures see bindings made after they were created.
+		"let f = fn() { let g = fn() { y }; let y = 2; g() }; f()",
+		// Until a function binds a name, it refers to the outer binding.
+		"let x = 1; let f = fn() { let y = x; let x = 2; y + x }; f()",
+		"let x = 1; let f = fn() { if (true) { let x = 2; } x }; f() + x",
+		"match (5) { 1 => 10, n if n > 3 => n * 2, _ => 0 }",
+		"let x = 1; match (5) { x => x }; x",
+		"match (3) { 1 => 1 }",
+		"switch (3) { case 1, 2: 10 case 3, 4: 20 default: 30 }",
+		"switch (9) { case 1: 10 }",
+		"1 + true",
+		"-true",
+		"10 / (5 - 5)",
+		"foobar + 1",
+		"let f = fn(a, b) { a }; f(1)",
+		"let x = 5; x(1 / 0)",
+		"throw 5; 10",
+		"try { throw 5 } catch (e) { e }",
+		"let x = 1; try { throw 1 } catch (e) { let x = 2; e } finally { let x = x + 1 }; x",
+		"try { return 10; } finally { return 20; }; 30",
+		"try { try { throw 1 } catch (e) { throw e } } catch (e) { 40 }",
+		"let f = fn() { defer 1 / 0; 10 }; f()",
+		"let fail = fn(x) { if (true) { throw x } }; let f = fn() { defer fail(1); defer fail(2); 10 }; f()",
+		"let fail = fn(x) { if (true) { throw x } }; let f = fn(x) { let y = x * 2; defer fail(y); let y = 0; 10 }; f(4)",
+		"let f = fn(x) { x + 2; }; f",
+		"true ? 1 > 2 ? 10 : 20 : 30",
+		// Functions and blocks without a value evaluate to null.
+		"let f = fn() {}; try { throw f() } catch (e) { e }",
+		"let f = fn() { let x = 1 }; f() ? 1 : 2",
+		"let f = fn() { defer 1 }; f()",
+		"if (true) { let x = 1 }",
+		"let f = fn() {}; match (f()) { 1 => 1, _ => 2 }",
+		"let f = fn() {}; match (f()) { 1 => 1 }",
+		"let f = fn() {}; switch (f()) { case 1: 1 }",
+		"let f = fn() {}; switch (f()) { case f(): 1 }",
+		`"Hello" + ", " + "World!"`,
+		`"a" == "a"`,
+		`"a" - "b"`,
+		`match ("b") { "a" => 1, "b" => 2, _ => 3 }`,
+		`switch ("b") { case "a": 1 case "b": 2 }`,
+		"[1, 2 * 2, fn(x) { x }(3)]",
+		"[1, 2, 3][1] + [1, 2, 3][5]",
+		"[1][true]",
+		"[1, x]",
+		`let k = "b"; {"a": 1, k: 2, 3: [true]}`,
+		`{"a": 1}["a"] + {true: 2}[true]`,
+		`{"a": 1}["b"]`,
+		`{[1]: 1}`,
+		`{"a": 1}[fn() { 1 }]`,
+		"1[0]",
+		`let f = fn(x) { if (true) { let y = [x, x]; } y }; f(1)`,
+		"match ([1, 2, 3]) { [a, b] => 1, [h, ..t] => [h, t] }",
+		"match ([1, [2, 3]]) { [_, [_, x]] => x }",
+		"match ([]) { [h, ..t] => 1, [..all] => all }",
+		`match ({"a": 1, 2: [3]}) { {"a": 2} => 0, {2: [x], "a": y} => x + y }`,
+		`match ({"a": 1}) { {"b": x} => x, _ => 0 }`,
+		"let x = 5; match ([1]) { [x] => x }; x",
+		"let f = fn(list) { match (list) { [] => 0, [h, ..t] => h + f(t) } }; f([1, 2, 3, 4])",
+		"match (1) { [..all] => 1 }",
+		"try { [1][true] } catch (e) { [e.kind, e.message, e.value, e.stack] }",
+		"let x = 5; x.foo + 1",
+		"try { 1 } catch (e) { e }.kind",
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(parse(input), object.NewEnvironment())
+		actual := run(t, input)
+
+		if expected.Inspect() != actual.Inspect() {
+			t.Errorf("wrong result for %q. want=%s, got=%s", input,
+				expected.Inspect(), actual.Inspect())
+		}
+	}
+}
+
+func TestHostObjects(t *testing.T) {
+	globals := NewGlobals()
+	globals.Set("it", object.NewIteration(&object.Array{Elements: []object.Object{
+		&object.Integer{Value: 1}, &object.Integer{Value: 2}}}))
+
+	input := `
+let sum = fn(total) { it.done() ? total : sum(total + it.next()) };
+[sum(0), it.done(), it.next]`
+	result := compile(t, parse(input), globals).Run()
+	if result.Inspect() != "[3, true, builtin function next]" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+
+	errObj, ok := compile(t, parse("it.next()"), globals).Run().(*object.Error)
+	if !ok || errObj.Message != "next: iteration is done" || errObj.Stack[0] != "(it.next)" {
+		t.Errorf("wrong error. got=%+v", errObj)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		program  *ast.Program
+		expected string
+	}{
+		{
+			&ast.Program{Statements: []ast.Statement{nil}},
+			"cannot compile <nil>",
+		},
+		{
+			&ast.Program{Statements: []ast.Statement{
+				&ast.ExpressionStatement{Expression: &ast.ArrayPattern{}},
+			}},
+			"cannot compile *ast.ArrayPattern",
+		},
+		{
+			&ast.Program{Statements: []ast.Statement{
+				&ast.ExpressionStatement{Expression: &ast.FunctionLiteral{
+					Parameters: []*ast.Identifier{{Value: "a"}, {Value: "a"}},
+					Body:       &ast.BlockStatement{},
+				}},
+			}},
+			"duplicate parameter a",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.program, NewGlobals())
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `
+let inner = fn() { 1 / 0 };
+let outer = fn() { inner() };
+outer();`
+
+	errObj, ok := run(t, input).(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+
+	expected := []string{"inner", "outer"}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. want=%q, got=%q", expected, errObj.Stack)
+	}
+
+	for i, frame := range expected {
+		if errObj.Stack[i] != frame {
+			t.Errorf("wrong stack frame %d. want=%q, got=%q", i, frame,
+				errObj.Stack[i])
+		}
+	}
+}
+
+func TestSharedGlobals(t *testing.T) {
+	globals := NewGlobals()
+
+	inputs := []string{
+		"let f = fn() { x * 2 };",
+		"let x = 21;",
+		"f()",
+	}
+
+	var result object.Object
+	for _, input := range inputs {
+		result = compile(t, parse(input), globals).Run()
+	}
+
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 42 {
+		t.Fatalf("wrong result. want=42, got=%+v", result)
+	}
+
+	if x, ok := globals.Get("x"); !ok || x.(*object.Integer).Value != 21 {
+		t.Errorf("wrong global x. got=%+v", x)
+	}
+
+	if _, ok := globals.Get("y"); ok {
+		t.Errorf("expected y to be unbound")
+	}
+}
+
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{"Fib25", `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(25)`},
+	// Monkey has no loop statement, so loops are written as tail calls.
+	{"Loop", `
+let loop = fn(i, acc) {
+	if (i == 0) { return acc; }
+	let x = i * 3 - i / 2;
+	loop(i - 1, acc + (x > 100 ? x : -x))
+};
+let repeat = fn(n) { if (n == 0) { 0 } else { loop(200, 0); repeat(n - 1) } };
+repeat(100)`},
+}
+
+func BenchmarkEvaluator(b *testing.B) {
+	for _, bm := range benchmarks {
+		program := parse(bm.input)
+
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				evaluator.Eval(program, object.NewEnvironment())
+			}
+		})
+	}
+}
+
+func BenchmarkClosure(b *testing.B) {
+	for _, bm := range benchmarks {
+		program := parse(bm.input)
+
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				compile(b, program, NewGlobals()).Run()
+			}
+		})
+	}
+}
+
+func BenchmarkVM(b *testing.B) {
+	for _, bm := range benchmarks {
+		comp := bytecode.New()
+		if err := comp.Compile(parse(bm.input)); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		compiled := comp.Bytecode()
+
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				machine := vm.New(compiled)
+				if err := machine.Run(); err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecursionLimit(t *testing.T) {
+	globals := NewGlobals()
+	globals.MaxCallDepth = 50
+
+	input := `
+let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };
+let g = fn(n) { try { f(n) } catch (e) { -1 } };
+g(48) + g(49)`
+
+	result := compile(t, parse(input), globals).Run()
+
+	integer, ok := result.(*object.Integer)
+	if !ok || integer.Value != 47 {
+		t.Fatalf("wrong result. want=47, got=%+v", result)
+	}
+
+	errObj, ok := run(t, "let f = fn(n) { 1 + f(n) }; f(0)").(*object.Error)
+	if !ok || errObj.Kind != object.RECURSION_ERROR {
+		t.Fatalf("expected a recursion error. got=%+v", errObj)
+	}
+
+	if len(errObj.Stack) != object.MaxErrorStack {
+		t.Errorf("wrong stack length. got=%d", len(errObj.Stack))
+	}
+}
@@ -0,0 +1,1018 @@
+package closure
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+)
+
+type compiler struct {
+	globals *Globals
+
+	// err is the first error found while compiling.
+	err error
+}
+
+// unsupported records that node can't be compiled and returns code that
+// never runs, since Compile then fails.
+func (c *compiler) unsupported(node ast.Node) code {
+	if c.err == nil {
+		c.err = fmt.Errorf("cannot compile %T", node)
+	}
+	return func(f *frame) object.Object { return nil }
+}
+
+// declareStatements defines the names bound by let statements in s before any
+// of them is compiled, since an identifier may refer to a binding that comes
+// later in the same scope.
+func (c *compiler) declareStatements(s *scope, statements []ast.Statement) {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *ast.LetStatement:
+			s.define(statement.Name.Value)
+			c.declareExpression(s, statement.Value)
+		case *ast.ExpressionStatement:
+			c.declareExpression(s, statement.Expression)
+		case *ast.ReturnStatement:
+			c.declareExpression(s, statement.ReturnValue)
+		case *ast.ThrowStatement:
+			c.declareExpression(s, statement.Value)
+		case *ast.DeferStatement:
+			c.declareExpression(s, statement.Expression)
+		case *ast.BlockStatement:
+			c.declareBlock(s, statement)
+		}
+	}
+}
+
+func (c *compiler) declareBlock(s *scope, block *ast.BlockStatement) {
+	if block != nil {
+		c.declareStatements(s, block.Statements)
+	}
+}
+
+// declareExpression declares the names bound in the blocks of e that share
+// the scope of e.  Function literals, match arms and catch blocks have scopes
+// of their own.
+func (c *compiler) declareExpression(s *scope, e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		c.declareExpression(s, e.Right)
+	case *ast.InfixExpression:
+		c.declareExpression(s, e.Left)
+		c.declareExpression(s, e.Right)
+	case *ast.IfExpression:
+		c.declareExpression(s, e.Condition)
+		c.declareBlock(s, e.Consequence)
+		c.declareBlock(s, e.Alternative)
+	case *ast.TernaryExpression:
+		c.declareExpression(s, e.Condition)
+		c.declareExpression(s, e.Consequence)
+		c.declareExpression(s, e.Alternative)
+	case *ast.CallExpression:
+		c.declareExpression(s, e.Function)
+		for _, arg := range e.Arguments {
+			c.declareExpression(s, arg)
+		}
+	case *ast.DotExpression:
+		c.declareExpression(s, e.Left)
+	case *ast.IndexExpression:
+		c.declareExpression(s, e.Left)
+		c.declareExpression(s, e.Index)
+	case *ast.ArrayLiteral:
+		for _, element := range e.Elements {
+			c.declareExpression(s, element)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range e.Pairs {
+			c.declareExpression(s, pair.Key)
+			c.declareExpression(s, pair.Value)
+		}
+	case *ast.MatchExpression:
+		c.declareExpression(s, e.Subject)
+	case *ast.SwitchExpression:
+		c.declareExpression(s, e.Subject)
+		for _, sc := range e.Cases {
+			for _, v := range sc.Values {
+				c.declareExpression(s, v)
+			}
+			c.declareBlock(s, sc.Body)
+		}
+		c.declareBlock(s, e.Default)
+	case *ast.TryExpression:
+		c.declareBlock(s, e.Block)
+		c.declareBlock(s, e.Finally)
+	}
+}
+
+func (c *compiler) statements(s *scope, statements []ast.Statement) []code {
+	compiled := make([]code, len(statements))
+	for i, statement := range statements {
+		compiled[i] = c.statement(s, statement)
+	}
+	return compiled
+}
+
+func (c *compiler) statement(s *scope, statement ast.Statement) code {
+	switch statement := statement.(type) {
+	case *ast.ExpressionStatement:
+		return c.expression(s, statement.Expression)
+
+	case *ast.BlockStatement:
+		return c.block(s, statement)
+
+	case *ast.LetStatement:
+		value := c.expression(s, statement.Value)
+		set := c.setter(s, statement.Name.Value)
+		return func(f *frame) object.Object {
+			val := value(f)
+			if isError(val) {
+				return val
+			}
+			set(f, val)
+			return nil
+		}
+
+	case *ast.ReturnStatement:
+		value := c.expression(s, statement.ReturnValue)
+		return func(f *frame) object.Object {
+			val := value(f)
+			if isError(val) {
+				return val
+			}
+			return &object.ReturnValue{Value: val}
+		}
+
+	case *ast.ThrowStatement:
+		value := c.expression(s, statement.Value)
+		return func(f *frame) object.Object {
+			val := value(f)
+			if isError(val) {
+				return val
+			}
+			return thrownError(val)
+		}
+
+	case *ast.DeferStatement:
+		expression := c.expression(s, statement.Expression)
+		return func(f *frame) object.Object {
+			if f.call != nil {
+				d := deferred{run: expression, frame: f}
+				f.call.deferred = append(f.call.deferred, d)
+			}
+			return nil
+		}
+	}
+
+	return c.unsupported(statement)
+}
+
+// block returns code that runs the statements of block until one returns or
+// raises an error.  A missing block evaluates to NULL, like the missing
+// alternative of an if expression, and so does a block without a value.
+func (c *compiler) block(s *scope, block *ast.BlockStatement) code {
+	if block == nil {
+		return func(f *frame) object.Object { return NULL }
+	}
+
+	return sequence(block, c.statements(s, block.Statements))
+}
+
+// sequence returns code that runs statements, the compiled statements of
+// block, like the code returned by compiler.block.
+func sequence(block *ast.BlockStatement, statements []code) code {
+	switch len(statements) {
+	case 0:
+		return func(f *frame) object.Object { return NULL }
+	case 1:
+		if _, ok := block.Statements[0].(*ast.ExpressionStatement); ok {
+			return statements[0]
+		}
+	}
+
+	return func(f *frame) object.Object {
+		var result object.Object
+
+		for _, statement := range statements {
+			result = statement(f)
+			if isReturnOrError(result) {
+				return result
+			}
+		}
+
+		if result == nil {
+			return NULL
+		}
+		return result
+	}
+}
+
+// node compiles a statement or an expression.
+func (c *compiler) node(s *scope, node ast.Node) code {
+	if statement, ok := node.(ast.Statement); ok {
+		return c.statement(s, statement)
+	}
+	return c.expression(s, node.(ast.Expression))
+}
+
+func (c *compiler) expression(s *scope, e ast.Expression) code {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		// Integers are immutable, so the same object can be returned every
+		// time.
+		integer := &object.Integer{Value: e.Value}
+		return func(f *frame) object.Object { return integer }
+
+	case *ast.Boolean:
+		boolean := nativeBoolToBooleanObject(e.Value)
+		return func(f *frame) object.Object { return boolean }
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: e.Value}
+		return func(f *frame) object.Object { return str }
+
+	case *ast.ArrayLiteral:
+		return c.array(s, e)
+
+	case *ast.HashLiteral:
+		return c.hash(s, e)
+
+	case *ast.DotExpression:
+		left := c.expression(s, e.Left)
+		name := e.Name
+		return func(f *frame) object.Object {
+			l := left(f)
+			if isError(l) {
+				return l
+			}
+			return dotOperation(l, name)
+		}
+
+	case *ast.IndexExpression:
+		left := c.expression(s, e.Left)
+		index := c.expression(s, e.Index)
+		return func(f *frame) object.Object {
+			l := left(f)
+			if isError(l) {
+				return l
+			}
+			i := index(f)
+			if isError(i) {
+				return i
+			}
+			return indexOperation(l, i)
+		}
+
+	case *ast.Identifier:
+		return c.identifier(s, e.Value)
+
+	case *ast.PrefixExpression:
+		return c.prefix(s, e)
+
+	case *ast.InfixExpression:
+		return c.infix(s, e)
+
+	case *ast.IfExpression:
+		return c.ifExpression(s, e, c.node)
+
+	case *ast.TernaryExpression:
+		return c.ternary(s, e, c.node)
+
+	case *ast.FunctionLiteral:
+		return c.function(s, e)
+
+	case *ast.CallExpression:
+		return c.call(s, e, false)
+
+	case *ast.MatchExpression:
+		return c.match(s, e, c.node)
+
+	case *ast.SwitchExpression:
+		return c.switchExpression(s, e, c.node)
+
+	case *ast.TryExpression:
+		return c.try(s, e)
+	}
+
+	return c.unsupported(e)
+}
+
+// ifExpression returns code that evaluates the condition and then one of the
+// branches, compiled by branch.
+func (c *compiler) ifExpression(s *scope, e *ast.IfExpression, branch compileFunc) code {
+	condition := c.expression(s, e.Condition)
+	consequence := branch(s, e.Consequence)
+	alternative := branch(s, e.Alternative)
+	return func(f *frame) object.Object {
+		cond := condition(f)
+		if isError(cond) {
+			return cond
+		}
+		if isTruthy(cond) {
+			return consequence(f)
+		}
+		return alternative(f)
+	}
+}
+
+// ternary returns code that evaluates the condition and then one of the
+// branches, compiled by branch.
+func (c *compiler) ternary(s *scope, e *ast.TernaryExpression, branch compileFunc) code {
+	condition := c.expression(s, e.Condition)
+	consequence := branch(s, e.Consequence)
+	alternative := branch(s, e.Alternative)
+	return func(f *frame) object.Object {
+		cond := condition(f)
+		if isError(cond) {
+			return cond
+		}
+		if isTruthy(cond) {
+			return consequence(f)
+		}
+		return alternative(f)
+	}
+}
+
+// identifier returns code that reads the first slot bound to name, like
+// looking it up in an environment.
+func (c *compiler) identifier(s *scope, name string) code {
+	refs, global := s.resolve(name)
+	g := c.globals
+
+	builtin := object.GetBuiltinByName(name)
+	notFound := func() object.Object {
+		if builtin != nil {
+			return builtin
+		}
+		return newError(object.NAME_ERROR, "identifier not found: %s", name)
+	}
+
+	readGlobal := func() object.Object {
+		if val := g.values[global]; val != nil {
+			return val
+		}
+		return notFound()
+	}
+
+	switch {
+	case len(refs) == 0:
+		return func(f *frame) object.Object { return readGlobal() }
+
+	case len(refs) == 1 && refs[0].depth == 0:
+		index := refs[0].index
+		return func(f *frame) object.Object {
+			if val := f.slots[index]; val != nil {
+				return val
+			}
+			return readGlobal()
+		}
+	}
+
+	return func(f *frame) object.Object {
+		depth := 0
+		for _, ref := range refs {
+			for ; depth < ref.depth; depth++ {
+				f = f.outer
+			}
+			if val := f.slots[ref.index]; val != nil {
+				return val
+			}
+		}
+		return readGlobal()
+	}
+}
+
+// setter returns a function that binds a value to name in the current scope.
+func (c *compiler) setter(s *scope, name string) func(f *frame, val object.Object) {
+	index := s.define(name)
+
+	if s.outer == nil {
+		g := c.globals
+		return func(f *frame, val object.Object) { g.values[index] = val }
+	}
+
+	return func(f *frame, val object.Object) { f.slots[index] = val }
+}
+
+func (c *compiler) prefix(s *scope, e *ast.PrefixExpression) code {
+	right := c.expression(s, e.Right)
+
+	switch e.Operator {
+	case "!":
+		return func(f *frame) object.Object {
+			r := right(f)
+			if isError(r) {
+				return r
+			}
+			return nativeBoolToBooleanObject(!isTruthy(r))
+		}
+
+	case "-":
+		return func(f *frame) object.Object {
+			r := right(f)
+			if isError(r) {
+				return r
+			}
+			integer, ok := r.(*object.Integer)
+			if !ok {
+				return newError(object.TYPE_ERROR, "unknown operator: -%s", r.Type())
+			}
+			return &object.Integer{Value: -integer.Value}
+		}
+	}
+
+	operator := e.Operator
+	return func(f *frame) object.Object {
+		r := right(f)
+		if isError(r) {
+			return r
+		}
+		return newError(object.TYPE_ERROR, "unknown operator: %s%s", operator,
+			r.Type())
+	}
+}
+
+func (c *compiler) infix(s *scope, e *ast.InfixExpression) code {
+	left := c.expression(s, e.Left)
+	right := c.expression(s, e.Right)
+	operator := e.Operator
+	integers := integerOperation(operator)
+
+	return func(f *frame) object.Object {
+		l := left(f)
+		if isError(l) {
+			return l
+		}
+		r := right(f)
+		if isError(r) {
+			return r
+		}
+
+		if li, ok := l.(*object.Integer); ok {
+			if ri, ok := r.(*object.Integer); ok {
+				return integers(li.Value, ri.Value)
+			}
+		}
+
+		return infixOperation(operator, l, r)
+	}
+}
+
+// integerOperation returns the implementation of operator for integers.
+func integerOperation(operator string) func(left, right int64) object.Object {
+	switch operator {
+	case "+":
+		return func(l, r int64) object.Object { return &object.Integer{Value: l + r} }
+	case "-":
+		return func(l, r int64) object.Object { return &object.Integer{Value: l - r} }
+	case "*":
+		return func(l, r int64) object.Object { return &object.Integer{Value: l * r} }
+	case "/":
+		return func(l, r int64) object.Object {
+			if r == 0 {
+				return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+			}
+			return &object.Integer{Value: l / r}
+		}
+	case "<":
+		return func(l, r int64) object.Object { return nativeBoolToBooleanObject(l < r) }
+	case ">":
+		return func(l, r int64) object.Object { return nativeBoolToBooleanObject(l > r) }
+	case "==":
+		return func(l, r int64) object.Object { return nativeBoolToBooleanObject(l == r) }
+	case "!=":
+		return func(l, r int64) object.Object { return nativeBoolToBooleanObject(l != r) }
+	}
+
+	return func(l, r int64) object.Object {
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+	}
+}
+
+// infixOperation applies operator to operands that aren't both integers.
+func infixOperation(operator string, left, right object.Object) object.Object {
+	if op, ok := left.(object.Operator); ok {
+		if result, ok := op.Operate(operator, right); ok {
+			return canonical(result)
+		}
+	}
+
+	if ls, ok := left.(*object.String); ok {
+		if rs, ok := right.(*object.String); ok {
+			switch operator {
+			case "+":
+				return &object.String{Value: ls.Value + rs.Value}
+			case "==":
+				return nativeBoolToBooleanObject(ls.Value == rs.Value)
+			case "!=":
+				return nativeBoolToBooleanObject(ls.Value != rs.Value)
+			}
+		}
+	}
+
+	switch {
+	case left.Type() != right.Type():
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s",
+			left.Type(), operator, right.Type())
+	case operator == "==":
+		return nativeBoolToBooleanObject(left == right)
+	case operator == "!=":
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// objectsEqual reports whether a and b have the same type and are equal
+// according to the "==" operator.
+func objectsEqual(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Integer:
+		bi, ok := b.(*object.Integer)
+		return ok && a.Value == bi.Value
+	case *object.String:
+		bs, ok := b.(*object.String)
+		return ok && a.Value == bs.Value
+	}
+
+	return a.Type() == b.Type() && a == b
+}
+
+// array returns code that evaluates the elements from left to right.
+func (c *compiler) array(s *scope, e *ast.ArrayLiteral) code {
+	elements := make([]code, len(e.Elements))
+	for i, element := range e.Elements {
+		elements[i] = c.expression(s, element)
+	}
+
+	return func(f *frame) object.Object {
+		values := make([]object.Object, len(elements))
+		for i, element := range elements {
+			val := element(f)
+			if isError(val) {
+				return val
+			}
+			values[i] = val
+		}
+		return &object.Array{Elements: values}
+	}
+}
+
+// hash returns code that evaluates the pairs in order, each key before its
+// value.
+func (c *compiler) hash(s *scope, e *ast.HashLiteral) code {
+	keys := make([]code, len(e.Pairs))
+	values := make([]code, len(e.Pairs))
+	for i, pair := range e.Pairs {
+		keys[i] = c.expression(s, pair.Key)
+		values[i] = c.expression(s, pair.Value)
+	}
+
+	return func(f *frame) object.Object {
+		pairs := map[object.HashKey]object.HashPair{}
+		for i := range keys {
+			key := keys[i](f)
+			if isError(key) {
+				return key
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return newError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+			}
+
+			val := values[i](f)
+			if isError(val) {
+				return val
+			}
+			pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: val}
+		}
+		return &object.Hash{Pairs: pairs}
+	}
+}
+
+// indexOperation returns the element of an array or the value of a hash at
+// index, or NULL if there is none.
+func indexOperation(left, index object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError(object.TYPE_ERROR, "array index must be INTEGER, got %s",
+				index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return NULL
+		}
+		return left.Elements[i.Value]
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
+		}
+		if pair, ok := left.Pairs[key.HashKey()]; ok {
+			return pair.Value
+		}
+		return NULL
+
+	case object.Indexer:
+		return canonical(left.Index(index))
+	}
+
+	return newError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
+}
+
+// dotOperation reads the property name of obj, or returns a builtin that calls
+// its method name, like the evaluator.
+func dotOperation(obj object.Object, name string) object.Object {
+	if getter, ok := obj.(object.PropertyGetter); ok {
+		if val, ok := getter.GetProperty(name); ok {
+			return canonical(val)
+		}
+	}
+
+	if caller, ok := obj.(object.MethodCaller); ok && caller.HasMethod(name) {
+		fn := func(env *object.Environment, args ...object.Object) object.Object {
+			return caller.CallMethod(name, args)
+		}
+		return &object.Builtin{Name: name, Fn: fn}
+	}
+
+	return newError(object.NAME_ERROR, "property not found: %s.%s", obj.Type(), name)
+}
+
+func (c *compiler) function(s *scope, e *ast.FunctionLiteral) code {
+	fnScope := newScope(s)
+	for i, p := range e.Parameters {
+		// Every argument needs a slot of its own.  The parser rejects
+		// duplicate parameters, but the program may not come from it.
+		if fnScope.define(p.Value) != i && c.err == nil {
+			c.err = fmt.Errorf("duplicate parameter %s", p.Value)
+		}
+	}
+	c.declareBlock(fnScope, e.Body)
+
+	body := c.tail(fnScope, e.Body, tailPosition)
+
+	return func(f *frame) object.Object {
+		return &Function{Literal: e, scope: fnScope, body: body, frame: f}
+	}
+}
+
+// call returns code that evaluates the function and the arguments from left to
+// right before calling it.  An error raised by the call gets the called
+// expression added to its stack.  If tail is true, the code returns the call
+// of a Function as a *tailCall instead.
+func (c *compiler) call(s *scope, e *ast.CallExpression, tail bool) code {
+	function := c.expression(s, e.Function)
+	args := make([]code, len(e.Arguments))
+	for i, arg := range e.Arguments {
+		args[i] = c.expression(s, arg)
+	}
+	name := e.Function.String()
+	g := c.globals
+
+	return func(f *frame) object.Object {
+		callee := function(f)
+		if isError(callee) {
+			return callee
+		}
+
+		if builtin, ok := callee.(*object.Builtin); ok {
+			values := make([]object.Object, len(args))
+			for i, arg := range args {
+				values[i] = arg(f)
+				if isError(values[i]) {
+					return values[i]
+				}
+			}
+			if err := g.env.Sandbox().Check(builtin); err != nil {
+				return addToStack(err, name)
+			}
+			result := canonical(builtin.Fn(g.env, values...))
+			if err, ok := result.(*object.Error); ok {
+				return addToStack(err, name)
+			}
+			return result
+		}
+
+		fn, ok := callee.(*Function)
+		if !ok || len(fn.Literal.Parameters) != len(args) {
+			// Arguments are still evaluated first, since errors they raise
+			// take precedence.
+			for _, arg := range args {
+				if val := arg(f); isError(val) {
+					return val
+				}
+			}
+			return addToStack(callError(callee, len(args)), name)
+		}
+
+		callFrame := newFrame(fn.scope, fn.frame)
+		for i, arg := range args {
+			val := arg(f)
+			if isError(val) {
+				return val
+			}
+			callFrame.slots[i] = val
+		}
+		if tail {
+			return &tailCall{fn: fn, frame: callFrame, name: name}
+		}
+
+		depth := 1
+		if f.call != nil {
+			depth = f.call.depth + 1
+		}
+		if depth > g.MaxCallDepth {
+			return addToStack(newError(object.RECURSION_ERROR,
+				"maximum recursion depth exceeded (%d)", g.MaxCallDepth), name)
+		}
+
+		result := fn.call(callFrame, depth)
+		if err, ok := result.(*object.Error); ok {
+			return addToStack(err, name)
+		}
+		return result
+	}
+}
+
+func callError(callee object.Object, numArgs int) *object.Error {
+	fn, ok := callee.(*Function)
+	if !ok {
+		return newError(object.TYPE_ERROR, "not a function: %s", callee.Type())
+	}
+
+	return newError(object.TYPE_ERROR, "wrong number of arguments: want=%d, got=%d",
+		len(fn.Literal.Parameters), numArgs)
+}
+
+func addToStack(err *object.Error, name string) *object.Error {
+	err.AddToStack(name)
+	return err
+}
+
+// match returns code that tries the arms in order.  Each arm that is tried
+// gets a frame of its own for the identifier bound by its pattern.  The bodies
+// of the arms are compiled by branch.
+func (c *compiler) match(s *scope, e *ast.MatchExpression, branch compileFunc) code {
+	type arm struct {
+		scope   *scope
+		pattern pattern
+		guard   code
+		body    code
+	}
+
+	subject := c.expression(s, e.Subject)
+
+	arms := make([]arm, len(e.Arms))
+	for i, a := range e.Arms {
+		armScope := newScope(s)
+		arms[i].scope = armScope
+
+		arms[i].pattern = c.pattern(armScope, a.Pattern)
+		c.declareExpression(armScope, a.Guard)
+		c.declareExpression(armScope, a.Body)
+
+		if a.Guard != nil {
+			arms[i].guard = c.expression(armScope, a.Guard)
+		}
+		arms[i].body = branch(armScope, a.Body)
+	}
+
+	return func(f *frame) object.Object {
+		subj := subject(f)
+		if isError(subj) {
+			return subj
+		}
+
+		for _, a := range arms {
+			armFrame := newFrame(a.scope, f)
+
+			matched, err := a.pattern(armFrame, subj)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+
+			if a.guard != nil {
+				guard := a.guard(armFrame)
+				if isError(guard) {
+					return guard
+				}
+				if !isTruthy(guard) {
+					continue
+				}
+			}
+
+			return a.body(armFrame)
+		}
+
+		return newError(object.MATCH_ERROR, "non-exhaustive match: no arm matches %s",
+			subj.Inspect())
+	}
+}
+
+// pattern is a compiled match pattern.  It reports whether value matches and
+// binds the identifiers of the pattern in f.  A pattern can also raise an
+// error, which it returns as the second value.
+type pattern func(f *frame, value object.Object) (bool, object.Object)
+
+// pattern compiles a match pattern.  The identifiers it binds are defined in s,
+// the scope of its arm.
+func (c *compiler) pattern(s *scope, p ast.Expression) pattern {
+	switch p := p.(type) {
+	case *ast.Identifier:
+		if p.Value == "_" {
+			return func(f *frame, value object.Object) (bool, object.Object) {
+				return true, nil
+			}
+		}
+		index := s.define(p.Value)
+		return func(f *frame, value object.Object) (bool, object.Object) {
+			f.slots[index] = value
+			return true, nil
+		}
+
+	case *ast.ArrayPattern:
+		return c.arrayPattern(s, p)
+
+	case *ast.HashPattern:
+		return c.hashPattern(s, p)
+	}
+
+	literal := c.expression(s, p)
+	return func(f *frame, value object.Object) (bool, object.Object) {
+		val := literal(f)
+		if isError(val) {
+			return false, val
+		}
+		return objectsEqual(val, value), nil
+	}
+}
+
+func (c *compiler) arrayPattern(s *scope, p *ast.ArrayPattern) pattern {
+	elements := make([]pattern, len(p.Elements))
+	for i, element := range p.Elements {
+		elements[i] = c.pattern(s, element)
+	}
+	var rest pattern
+	if p.Rest != nil {
+		rest = c.pattern(s, p.Rest)
+	}
+	n := len(elements)
+
+	return func(f *frame, value object.Object) (bool, object.Object) {
+		array, ok := value.(*object.Array)
+		if !ok || len(array.Elements) < n || rest == nil && len(array.Elements) != n {
+			return false, nil
+		}
+
+		for i, element := range elements {
+			if matched, err := element(f, array.Elements[i]); !matched {
+				return false, err
+			}
+		}
+
+		if rest != nil {
+			remaining := make([]object.Object, len(array.Elements)-n)
+			copy(remaining, array.Elements[n:])
+			return rest(f, &object.Array{Elements: remaining})
+		}
+		return true, nil
+	}
+}
+
+func (c *compiler) hashPattern(s *scope, p *ast.HashPattern) pattern {
+	keys := make([]code, len(p.Pairs))
+	values := make([]pattern, len(p.Pairs))
+	for i, pair := range p.Pairs {
+		keys[i] = c.expression(s, pair.Key)
+		values[i] = c.pattern(s, pair.Value)
+	}
+
+	return func(f *frame, value object.Object) (bool, object.Object) {
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false, nil
+		}
+
+		for i, key := range keys {
+			k := key(f)
+			if isError(k) {
+				return false, k
+			}
+			pair, ok := hash.Pairs[k.(object.Hashable).HashKey()]
+			if !ok {
+				return false, nil
+			}
+			if matched, err := values[i](f, pair.Value); !matched {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// switchExpression returns code that evaluates the case values in order until
+// one equals the subject.  The bodies of the cases are compiled by branch.
+func (c *compiler) switchExpression(s *scope, e *ast.SwitchExpression, branch compileFunc) code {
+	type switchCase struct {
+		values []code
+		body   code
+	}
+
+	subject := c.expression(s, e.Subject)
+
+	cases := make([]switchCase, len(e.Cases))
+	for i, sc := range e.Cases {
+		for _, v := range sc.Values {
+			cases[i].values = append(cases[i].values, c.expression(s, v))
+		}
+		cases[i].body = branch(s, sc.Body)
+	}
+
+	// Without a default branch, the switch evaluates to NULL, which is what
+	// the code of a missing block returns.
+	defaultBody := branch(s, e.Default)
+
+	return func(f *frame) object.Object {
+		subj := subject(f)
+		if isError(subj) {
+			return subj
+		}
+
+		for _, sc := range cases {
+			for _, value := range sc.values {
+				val := value(f)
+				if isError(val) {
+					return val
+				}
+				if objectsEqual(subj, val) {
+					return sc.body(f)
+				}
+			}
+		}
+
+		return defaultBody(f)
+	}
+}
+
+// try returns code that runs the try block, the catch block if the try block
+// raised an error, and the finally block.
+func (c *compiler) try(s *scope, e *ast.TryExpression) code {
+	block := c.block(s, e.Block)
+
+	var catchScope *scope
+	var catch code
+	var catchIndex int
+	if e.Catch != nil {
+		catchScope = newScope(s)
+		catchIndex = catchScope.define(e.CatchParameter.Value)
+		c.declareBlock(catchScope, e.Catch)
+		catch = c.block(catchScope, e.Catch)
+	}
+
+	var finally code
+	if e.Finally != nil {
+		finally = c.block(s, e.Finally)
+	}
+
+	return func(f *frame) object.Object {
+		result := block(f)
+
+		if err, ok := result.(*object.Error); ok && catch != nil {
+			catchFrame := newFrame(catchScope, f)
+			catchFrame.slots[catchIndex] = &object.Exception{Error: err}
+
+			result = catch(catchFrame)
+		}
+
+		if finally != nil {
+			if fin := finally(f); isReturnOrError(fin) {
+				return fin
+			}
+		}
+
+		return result
+	}
+}
+
+// thrownError returns the error raised by throwing val.
+func thrownError(val object.Object) *object.Error {
+	if exception, ok := val.(*object.Exception); ok {
+		return exception.Error
+	}
+
+	err := newError(object.THROWN_ERROR, "%s", val.Inspect())
+	err.Value = val
+	return err
+}
+
+func newError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,48 @@
+package closure
+
+// scope maps the names bound by a function call, match arm, catch block or the
+// main program to slots.  The scope of the main program is the scope of the
+// global variables.
+type scope struct {
+	outer *scope
+	slots map[string]int
+	names []string
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, slots: make(map[string]int)}
+}
+
+// define binds name to a slot, reusing its slot if it is already bound.
+func (s *scope) define(name string) int {
+	if index, ok := s.slots[name]; ok {
+		return index
+	}
+
+	index := len(s.names)
+	s.slots[name] = index
+	s.names = append(s.names, name)
+	return index
+}
+
+// slotRef locates a slot in the frame depth levels out from the current one.
+type slotRef struct {
+	depth int
+	index int
+}
+
+// resolve returns the slots that can bind name, innermost first, and the
+// global slot that is tried last.
+func (s *scope) resolve(name string) ([]slotRef, int) {
+	var refs []slotRef
+
+	depth := 0
+	for ; s.outer != nil; s = s.outer {
+		if index, ok := s.slots[name]; ok {
+			refs = append(refs, slotRef{depth: depth, index: index})
+		}
+		depth++
+	}
+
+	return refs, s.define(name)
+}
@@ -0,0 +1,164 @@
+package closure
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// compileFunc compiles a node in scope s.  It is either compiler.node or the
+// version of compiler.tail for a position.
+type compileFunc func(s *scope, node ast.Node) code
+
+// position tells which calls in a node are tail calls, whose value is the
+// value of the function call they are in.
+type position int
+
+const (
+	// In return position, only the calls returned by return statements are
+	// tail calls.  The value of the node itself is discarded.
+	returnPosition position = iota
+	// In tail position, the value of the node is also the value of the
+	// function call, so calls that give the node its value are tail calls.
+	tailPosition
+)
+
+// tailCallObj is the type of tail calls, which never leave compiled code.
+const tailCallObj = "TAIL_CALL"
+
+// tailCall is a call in tail position whose arguments are in the slots of
+// frame.  It is returned to Function.call, which runs it instead of the
+// compiled call.  name is the called expression, added to the stack of errors.
+type tailCall struct {
+	fn    *Function
+	frame *frame
+	name  string
+}
+
+// Type returns the tail call type.
+func (tc *tailCall) Type() object.ObjectType {
+	return tailCallObj
+}
+
+// Inspect returns the string representation of the tail call type.
+func (tc *tailCall) Inspect() string {
+	return "tail call " + tc.name
+}
+
+// tail compiles node, which is in position pos of a function body, like
+// statement and expression.  Instead of running a tail call, the code returns
+// it as a *tailCall.  Nodes that have no tail calls in pos are compiled by
+// node.  Tail calls stop the evaluation of blocks like return statements do.
+func (c *compiler) tail(s *scope, node ast.Node, pos position) code {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		if node == nil {
+			break
+		}
+
+		statements := make([]code, len(node.Statements))
+		for i, statement := range node.Statements {
+			statementPos := returnPosition
+			if i == len(node.Statements)-1 {
+				statementPos = pos
+			}
+			statements[i] = c.tail(s, statement, statementPos)
+		}
+		return sequence(node, statements)
+
+	case *ast.ExpressionStatement:
+		return c.tail(s, node.Expression, pos)
+
+	case *ast.ReturnStatement:
+		value := c.tail(s, node.ReturnValue, tailPosition)
+		return func(f *frame) object.Object {
+			val := value(f)
+			if _, ok := val.(*tailCall); ok || isError(val) {
+				return val
+			}
+			return &object.ReturnValue{Value: val}
+		}
+
+	case *ast.CallExpression:
+		if pos == tailPosition {
+			return c.call(s, node, true)
+		}
+
+	case *ast.IfExpression:
+		return c.ifExpression(s, node, c.tailBranch(pos))
+
+	case *ast.MatchExpression:
+		return c.match(s, node, c.tailBranch(pos))
+
+	case *ast.SwitchExpression:
+		return c.switchExpression(s, node, c.tailBranch(pos))
+
+	case *ast.TernaryExpression:
+		if pos == tailPosition {
+			return c.ternary(s, node, c.tailBranch(pos))
+		}
+	}
+
+	return c.node(s, node)
+}
+
+// tailBranch returns the compileFunc of the branches of a conditional in
+// position pos.  They are in the same position as the conditional.
+func (c *compiler) tailBranch(pos position) compileFunc {
+	return func(s *scope, node ast.Node) code {
+		return c.tail(s, node, pos)
+	}
+}
+
+// tailCalls records the function calls run by Function.call.  Consecutive
+// calls of the same expression without deferred expressions are recorded
+// once, so that a loop written as a tail call uses constant memory.
+type tailCalls []tailCallFrame
+
+type tailCallFrame struct {
+	name  string
+	frame *frame
+	count int
+}
+
+// push records a call of the expression name in frame f.  The first call has
+// no name, since its caller adds it to the stack of errors.
+func (calls *tailCalls) push(name string, f *frame) {
+	if len(f.deferred) == 0 {
+		f = nil
+	}
+
+	n := len(*calls)
+	if n > 1 && f == nil {
+		last := &(*calls)[n-1]
+		if last.frame == nil && last.name == name {
+			last.count++
+			return
+		}
+	}
+
+	*calls = append(*calls, tailCallFrame{name: name, frame: f, count: 1})
+}
+
+// unwind returns from the recorded calls, innermost first, starting with the
+// value of the last one.  Each call runs its deferred expressions and adds its
+// name to the stack of an error, like nested calls would have.
+func (calls tailCalls) unwind(result object.Object) object.Object {
+	for i := len(calls) - 1; i >= 0; i-- {
+		call := calls[i]
+
+		if call.frame != nil {
+			result = runDeferred(call.frame, result)
+		}
+		if returnValue, ok := result.(*object.ReturnValue); ok {
+			result = returnValue.Value
+		}
+
+		if err, ok := result.(*object.Error); ok && i > 0 {
+			for j := 0; j < call.count; j++ {
+				err.AddToStack(call.name)
+			}
+		}
+	}
+
+	return result
+}
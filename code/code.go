@@ -0,0 +1,329 @@
+// Package code defines the instructions of the Monkey virtual machine.  An
+// instruction is a one byte opcode followed by its operands, which are encoded
+// in big endian.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a flat sequence of encoded instructions.
+type Instructions []byte
+
+// String disassembles the instructions, one per line.  Each line holds the
+// offset of the instruction, the name of its opcode and its operands.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s", def.Name)
+}
+
+// Opcode identifies the operation of an instruction.
+type Opcode byte
+
+// List of opcodes.  Add a definition to definitions for every new opcode.
+const (
+	// OpConstant pushes the constant at the index given by its operand.
+	OpConstant Opcode = iota
+
+	// Arithmetic operators pop two operands and push the result.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+
+	// OpTrue, OpFalse and OpNull push the singleton values.
+	OpTrue
+	OpFalse
+	OpNull
+
+	// Comparison operators pop two operands and push a boolean.
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+
+	// Prefix operators pop one operand and push the result.
+	OpMinus
+	OpBang
+
+	// OpPop pops the top of the stack.
+	OpPop
+
+	// OpJump and OpJumpNotTruthy jump to the absolute offset given by their
+	// operand.  OpJumpNotTruthy pops the condition first and only jumps if it
+	// isn't truthy.
+	OpJump
+	OpJumpNotTruthy
+
+	// Variables are stored in slots that are resolved at compile time.
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetFree
+
+	// OpClosure pushes a closure over the compiled function constant given by
+	// the first operand.  The second operand is the number of free variables
+	// to pop and capture.
+	OpClosure
+	// OpCurrentClosure pushes the closure that is being executed.  It lets a
+	// function refer to itself by the name it is bound to.
+	OpCurrentClosure
+
+	// OpCall calls the function below its arguments.  Its operand is the
+	// number of arguments.
+	OpCall
+	// OpReturnValue returns the top of the stack from the current function.
+	OpReturnValue
+	// OpReturn returns null from the current function.
+	OpReturn
+
+	// OpCaseEqual pops two operands and pushes true if they have the same type
+	// and are equal.  Unlike OpEqual, it never raises an error.
+	OpCaseEqual
+	// OpNoMatch pops the subject of a match expression that no arm matched and
+	// raises an error.
+	OpNoMatch
+
+	// OpThrow pops a value and raises it as an error.
+	OpThrow
+	// OpTry installs an error handler at the absolute offset given by its
+	// operand.  When an error is raised, the handler is removed, the stack is
+	// restored to its height at OpTry, the error is pushed as an exception and
+	// execution continues at the handler.
+	OpTry
+	// OpEndTry removes the most recently installed error handler.
+	OpEndTry
+
+	// OpDefer pops a closure and runs it when the current function returns.
+	OpDefer
+
+	// OpArray pops the number of elements given by its operand and pushes an
+	// array of them.  OpHash pops the number of keys and values given by its
+	// operand, each key below its value, and pushes a hash of them.
+	OpArray
+	OpHash
+	// OpIndex pops an index and the value below it and pushes the element at
+	// the index.
+	OpIndex
+
+	// OpMatchArray pops a value and pushes true if it is an array with the
+	// number of elements given by the first operand, or more if the second
+	// operand is 1.  OpMatchHash pops a value and pushes true if it is a hash.
+	// OpHasKey pops a key and the hash below it and pushes true if the hash
+	// has the key.  OpRest pops an array and pushes a new array of its
+	// elements from the index given by its operand on.
+	OpMatchArray
+	OpMatchHash
+	OpHasKey
+	OpRest
+
+	// OpGetBuiltin pushes the builtin given by its operand, an index into
+	// object.Builtins.
+	OpGetBuiltin
+	// OpGetProperty pops a value and pushes its property named by the string
+	// constant given by its operand, or its method of that name.
+	OpGetProperty
+
+	// OpCaptureLocal and OpCaptureFree push the local or free variable given
+	// by their operand for OpClosure to capture.  The variable itself is
+	// captured rather than its value, so that the closure sees it being bound
+	// again.
+	OpCaptureLocal
+	OpCaptureFree
+
+	// OpTailCall calls the function below its arguments, like OpCall, when
+	// the value of the call is returned by the current function.  The call
+	// may replace the frame of the current function instead of adding one.
+	OpTailCall
+)
+
+// Definition describes an opcode.  OperandWidths holds the number of bytes
+// each operand takes up.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant:       {"OpConstant", []int{2}},
+	OpAdd:            {"OpAdd", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpTrue:           {"OpTrue", []int{}},
+	OpFalse:          {"OpFalse", []int{}},
+	OpNull:           {"OpNull", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpGreaterThan:    {"OpGreaterThan", []int{}},
+	OpLessThan:       {"OpLessThan", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpPop:            {"OpPop", []int{}},
+	OpJump:           {"OpJump", []int{2}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpCaseEqual:      {"OpCaseEqual", []int{}},
+	OpNoMatch:        {"OpNoMatch", []int{}},
+	OpThrow:          {"OpThrow", []int{}},
+	OpTry:            {"OpTry", []int{2}},
+	OpEndTry:         {"OpEndTry", []int{}},
+	OpDefer:          {"OpDefer", []int{}},
+	OpArray:          {"OpArray", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpMatchArray:     {"OpMatchArray", []int{2, 1}},
+	OpMatchHash:      {"OpMatchHash", []int{}},
+	OpHasKey:         {"OpHasKey", []int{}},
+	OpRest:           {"OpRest", []int{2}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpGetProperty:    {"OpGetProperty", []int{2}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},
+	OpTailCall:       {"OpTailCall", []int{1}},
+}
+
+// Lookup returns the definition of op.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// CheckOperands returns an error if op is undefined or one of operands doesn't
+// fit in the bytes the definition of op gives it.  Make truncates such
+// operands, so their instructions must not be made.
+func CheckOperands(op Opcode, operands ...int) error {
+	def, ok := definitions[op]
+	if !ok {
+		return fmt.Errorf("opcode %d undefined", op)
+	}
+
+	for i, o := range operands {
+		limit := 1<<(8*uint(def.OperandWidths[i])) - 1
+		if o < 0 || o > limit {
+			return fmt.Errorf("operand %d of %s out of range: %d (maximum %d)",
+				i, def.Name, o, limit)
+		}
+	}
+
+	return nil
+}
+
+// Make encodes an instruction.  It returns an empty instruction if op is
+// undefined.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadUint16 decodes a two byte operand.
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+// ReadUint8 decodes a one byte operand.
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// ReadOperands decodes the operands of an instruction defined by def.  ins
+// starts right after the opcode.  It returns the operands and the number of
+// bytes read.  Operands that are cut off by the end of ins are left out.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, 0, len(def.OperandWidths))
+	offset := 0
+
+	for _, width := range def.OperandWidths {
+		if offset+width > len(ins) {
+			return operands, len(ins)
+		}
+
+		switch width {
+		case 2:
+			operands = append(operands, int(ReadUint16(ins[offset:])))
+		case 1:
+			operands = append(operands, int(ReadUint8(ins[offset:])))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
@@ -0,0 +1,931 @@
+/*
+Package compiler lowers the abstract syntax tree of a Monkey program into
+bytecode for the virtual machine.
+
+To compile:
+
+	c := New()
+	err := c.Compile(program)
+	bytecode := c.Bytecode()
+
+The compiled program aims to behave like the tree-walking evaluator.  The main
+differences come from resolving identifiers at compile time:
+
+  - An identifier that isn't bound when it is compiled refers to a global
+    variable, which may still be defined later by a let statement.
+  - Blocks whose value the evaluator leaves undefined, such as an empty block
+    or one that ends with a let statement, evaluate to null.
+*/
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+)
+
+// Compiler compiles a program into bytecode.
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// err is the first operand that didn't fit in its instruction.
+	err error
+}
+
+// EmittedInstruction records the opcode and position of an emitted
+// instruction.
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function that is being
+// compiled.  The main program has its own scope.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	// handlers holds the error handlers installed at the current position by
+	// try expressions, innermost last.  A return statement removes all of
+	// them and runs their finally blocks before returning.
+	handlers []handler
+}
+
+type handler struct {
+	finally *ast.BlockStatement
+}
+
+// Bytecode is the output of the compiler.  GlobalNames holds the names of the
+// global variables by slot index.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	GlobalNames  []string
+}
+
+// New returns a reference to a new Compiler.
+func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions: code.Instructions{},
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: NewSymbolTable(),
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a reference to a new Compiler that keeps defining
+// globals and constants where a previous compiler left off.  This is how
+// successive inputs can share global variables.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// Bytecode returns the compiled program.
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		GlobalNames:  c.symbolTable.global().Names(),
+	}
+}
+
+// Compile compiles node and its children.  It fails if the program needs
+// more constants, local variables, free variables, arguments or instructions
+// than the operands of the bytecode can refer to.
+func (c *Compiler) Compile(node ast.Node) error {
+	if err := c.compile(node); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	switch node := node.(type) {
+
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		var err error
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+			err = c.compileFunction(fn, node.Name.Value)
+		} else {
+			err = c.Compile(node.Value)
+		}
+		if err != nil {
+			return err
+		}
+
+		symbol := c.symbolTable.Define(node.Name.Value)
+		c.setSymbol(symbol)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		return c.compileReturn()
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.DeferStatement:
+		return c.compileDeferStatement(node)
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			if err := c.Compile(e); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.DotExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		name := c.addConstant(&object.String{Value: node.Name})
+		c.emit(code.OpGetProperty, name)
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node, c.Compile)
+
+	case *ast.TernaryExpression:
+		return c.compileTernaryExpression(node, c.Compile)
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node, c.Compile)
+
+	case *ast.SwitchExpression:
+		return c.compileSwitchExpression(node, c.Compile)
+
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			symbol = c.symbolTable.DefineGlobal(node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, "")
+
+	case *ast.CallExpression:
+		return c.compileCall(node, code.OpCall)
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+}
+
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	op, ok := infixOpcodes[node.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+
+	c.emit(op)
+	return nil
+}
+
+// compileCall compiles the function and the arguments of node and emits op,
+// which is OpCall or OpTailCall.
+func (c *Compiler) compileCall(node *ast.CallExpression, op code.Opcode) error {
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+	}
+
+	c.emit(op, len(node.Arguments))
+	return nil
+}
+
+// compileIfExpression compiles the branches of node with compile.
+func (c *Compiler) compileIfExpression(node *ast.IfExpression, compile compileFunc) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	// Emit jumps with bogus offsets that are changed once the target is known.
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBranchValue(node.Consequence, compile); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBranchValue(node.Alternative, compile); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileTernaryExpression compiles the branches of node with compile.
+func (c *Compiler) compileTernaryExpression(node *ast.TernaryExpression, compile compileFunc) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := compile(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if err := compile(node.Alternative); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileMatchExpression keeps the subject in a temporary slot and tests the
+// arms in order.  Each arm has its own block scope for the identifiers bound by
+// its pattern.  If no arm matches, OpNoMatch raises an error.  The bodies of
+// the arms are compiled with compile.
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression, compile compileFunc) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	subject := c.symbolTable.DefineTemp()
+	c.setSymbol(subject)
+
+	endJumps := []int{}
+
+	for _, arm := range node.Arms {
+		c.enterBlock()
+
+		nextArmJumps := []int{}
+		if err := c.compilePattern(arm.Pattern, subject, &nextArmJumps); err != nil {
+			return err
+		}
+
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return err
+			}
+			nextArmJumps = append(nextArmJumps, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+
+		if err := compile(arm.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+		for _, pos := range nextArmJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+
+		c.leaveBlock()
+	}
+
+	c.loadSymbol(subject)
+	c.emit(code.OpNoMatch)
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// compilePattern compiles the test of the value in the slot of value against
+// pattern.  The positions of the jumps taken when it doesn't match are added to
+// fails.  Elements of arrays and hashes are kept in temporary slots while their
+// own patterns are tested.
+func (c *Compiler) compilePattern(pattern ast.Expression, value Symbol, fails *[]int) error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			c.loadSymbol(value)
+			c.setSymbol(c.symbolTable.Define(pattern.Value))
+		}
+		return nil
+
+	case *ast.ArrayPattern:
+		rest := 0
+		if pattern.Rest != nil {
+			rest = 1
+		}
+		c.loadSymbol(value)
+		c.emit(code.OpMatchArray, len(pattern.Elements), rest)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for i, element := range pattern.Elements {
+			c.loadSymbol(value)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+			c.emit(code.OpIndex)
+			if err := c.compileElementPattern(element, fails); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			c.loadSymbol(value)
+			c.emit(code.OpRest, len(pattern.Elements))
+			return c.compileElementPattern(pattern.Rest, fails)
+		}
+		return nil
+
+	case *ast.HashPattern:
+		c.loadSymbol(value)
+		c.emit(code.OpMatchHash)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for _, pair := range pattern.Pairs {
+			c.loadSymbol(value)
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			c.emit(code.OpHasKey)
+			*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+			c.loadSymbol(value)
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			c.emit(code.OpIndex)
+			if err := c.compileElementPattern(pair.Value, fails); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	c.loadSymbol(value)
+	if err := c.Compile(pattern); err != nil {
+		return err
+	}
+	c.emit(code.OpCaseEqual)
+	*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+	return nil
+}
+
+// compileElementPattern pops the element on top of the stack into a temporary
+// slot and compiles the test of it against pattern.
+func (c *Compiler) compileElementPattern(pattern ast.Expression, fails *[]int) error {
+	element := c.symbolTable.DefineTemp()
+	c.setSymbol(element)
+	return c.compilePattern(pattern, element, fails)
+}
+
+// compileSwitchExpression keeps the subject in a temporary slot and compares it
+// with the values of each case in order.  The default branch is compiled last
+// no matter where it appears.
+// compileSwitchExpression compiles the bodies of the cases of node with
+// compile.
+func (c *Compiler) compileSwitchExpression(node *ast.SwitchExpression, compile compileFunc) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	subject := c.symbolTable.DefineTemp()
+	c.setSymbol(subject)
+
+	endJumps := []int{}
+
+	for _, sc := range node.Cases {
+		bodyJumps := []int{}
+
+		for _, v := range sc.Values {
+			c.loadSymbol(subject)
+			if err := c.Compile(v); err != nil {
+				return err
+			}
+			c.emit(code.OpCaseEqual)
+
+			nextValuePos := c.emit(code.OpJumpNotTruthy, 9999)
+			bodyJumps = append(bodyJumps, c.emit(code.OpJump, 9999))
+			c.changeOperand(nextValuePos, len(c.currentInstructions()))
+		}
+
+		nextCasePos := c.emit(code.OpJump, 9999)
+
+		for _, pos := range bodyJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+
+		if err := c.compileBranchValue(sc.Body, compile); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+		c.changeOperand(nextCasePos, len(c.currentInstructions()))
+	}
+
+	if node.Default == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBranchValue(node.Default, compile); err != nil {
+		return err
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// compileTryExpression installs a handler for the catch block around the try
+// block, and another one for the finally block around both.  The finally
+// block is compiled twice: once for when no error is left and once for when
+// the error has to be raised again afterwards.  Return statements inside the
+// try expression compile a third copy, see compileReturn.
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	finallyTryPos := -1
+	if node.Finally != nil {
+		finallyTryPos = c.emit(code.OpTry, 9999)
+		c.pushHandler(node.Finally)
+	}
+
+	if node.Catch != nil {
+		catchTryPos := c.emit(code.OpTry, 9999)
+		c.pushHandler(nil)
+
+		if err := c.compileBlockValue(node.Block); err != nil {
+			return err
+		}
+
+		c.popHandler()
+		c.emit(code.OpEndTry)
+		afterCatchPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(catchTryPos, len(c.currentInstructions()))
+
+		c.enterBlock()
+		c.setSymbol(c.symbolTable.Define(node.CatchParameter.Value))
+		err := c.compileBlockValue(node.Catch)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+
+		c.changeOperand(afterCatchPos, len(c.currentInstructions()))
+	} else if err := c.compileBlockValue(node.Block); err != nil {
+		return err
+	}
+
+	if node.Finally == nil {
+		return nil
+	}
+
+	c.popHandler()
+	c.emit(code.OpEndTry)
+
+	if err := c.compileBlockValue(node.Finally); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	endPos := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(finallyTryPos, len(c.currentInstructions()))
+
+	exception := c.symbolTable.DefineTemp()
+	c.setSymbol(exception)
+
+	if err := c.compileBlockValue(node.Finally); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+
+	c.loadSymbol(exception)
+	c.emit(code.OpThrow)
+
+	c.changeOperand(endPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileReturn returns the value on top of the stack.  Before it does, it
+// removes the error handlers installed in the current function and runs their
+// finally blocks, innermost first.
+func (c *Compiler) compileReturn() error {
+	handlers := c.scopes[c.scopeIndex].handlers
+
+	for i := len(handlers) - 1; i >= 0; i-- {
+		c.emit(code.OpEndTry)
+
+		if handlers[i].finally == nil {
+			continue
+		}
+
+		// A return statement in the finally block must only run the finally
+		// blocks of the outer handlers.  The scopes may grow while the block
+		// is compiled, so the current scope is looked up again afterwards.
+		c.scopes[c.scopeIndex].handlers = handlers[:i]
+		err := c.compileBlockValue(handlers[i].finally)
+		c.scopes[c.scopeIndex].handlers = handlers
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	}
+
+	c.emit(code.OpReturnValue)
+	return nil
+}
+
+// compileDeferStatement compiles the deferred expression into a closure that
+// takes no arguments, and registers it with the current function call.
+func (c *Compiler) compileDeferStatement(node *ast.DeferStatement) error {
+	c.enterScope()
+
+	if err := c.Compile(node.Expression); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	if err := c.emitClosure("", 0); err != nil {
+		return err
+	}
+
+	c.emit(code.OpDefer)
+	return nil
+}
+
+// compileFunction compiles fn and emits the closure.  If fn is bound to a name
+// by a let statement, name lets the body refer to the function itself.
+func (c *Compiler) compileFunction(fn *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+
+	for i, p := range fn.Parameters {
+		// Every argument needs a slot of its own.  The parser rejects
+		// duplicate parameters, but the program may not come from it.
+		if c.symbolTable.Define(p.Value).Index != i {
+			return fmt.Errorf("duplicate parameter %s", p.Value)
+		}
+	}
+
+	if err := c.compileTail(fn.Body, tailPosition); err != nil {
+		return err
+	}
+
+	n := len(fn.Body.Statements)
+	if n > 0 && c.lastInstructionIs(code.OpPop) {
+		if _, ok := fn.Body.Statements[n-1].(*ast.ExpressionStatement); ok {
+			c.replaceLastPopWithReturn()
+		}
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	return c.emitClosure(name, len(fn.Parameters))
+}
+
+// emitClosure leaves the scope of the function that was just compiled, adds
+// the function to the constants and emits the closure.  The free variables of
+// the function are loaded in the enclosing scope so that the closure can
+// capture them.
+func (c *Compiler) emitClosure(name string, numParameters int) error {
+	freeSymbols := c.symbolTable.FreeSymbols
+	localNames := append([]string{}, c.symbolTable.Names()...)
+	instructions := c.leaveScope()
+
+	freeNames := []string{}
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+		freeNames = append(freeNames, s.Name)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     len(localNames),
+		NumParameters: numParameters,
+		Name:          name,
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+	}
+
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+	return nil
+}
+
+// compileBlockValue compiles block so that it leaves its value on the stack.
+// The value is the one of its last statement if that is an expression
+// statement, and null otherwise.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	return c.compileBranchValue(block, c.Compile)
+}
+
+// compileBranchValue is compileBlockValue for a block compiled with compile.
+func (c *Compiler) compileBranchValue(block *ast.BlockStatement, compile compileFunc) error {
+	if err := compile(block); err != nil {
+		return err
+	}
+
+	n := len(block.Statements)
+	if n > 0 && c.lastInstructionIs(code.OpPop) {
+		if _, ok := block.Statements[n-1].(*ast.ExpressionStatement); ok {
+			c.removeLastPop()
+			return nil
+		}
+	}
+
+	c.emit(code.OpNull)
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	}
+}
+
+// captureSymbol pushes the variable of s for a closure to capture.  Only
+// local, free and function symbols are captured.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// setSymbol pops the top of the stack into the slot of s.  Only global and
+// local symbols can be set.
+func (c *Compiler) setSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// emit adds an instruction to the current scope and returns its position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands...)
+
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// changeOperand replaces the operand of the instruction at opPos.  It is used
+// to fill in the targets of jumps once they are known.
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, operand)
+
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+// checkOperands records the error of operands that don't fit in the
+// instruction of op, so that Compile fails instead of making the instruction
+// with truncated operands.
+func (c *Compiler) checkOperands(op code.Opcode, operands ...int) {
+	if err := code.CheckOperands(op, operands...); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *Compiler) pushHandler(finally *ast.BlockStatement) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.handlers = append(scope.handlers, handler{finally: finally})
+}
+
+func (c *Compiler) popHandler() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.handlers = scope.handlers[:len(scope.handlers)-1]
+}
+
+// enterScope starts compiling a new function.
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions: code.Instructions{},
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// leaveScope finishes compiling a function and returns its instructions.
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+// enterBlock starts a new block scope for identifiers.
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+// leaveBlock ends the block scope started by enterBlock.
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
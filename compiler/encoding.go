@@ -0,0 +1,475 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"monkey/code"
+	"monkey/object"
+)
+
+// A bytecode file, conventionally named with the .mkc extension, starts with a
+// header followed by the payload:
+//
+//	magic    4 bytes   "MKC\x00"
+//	version  uint16    FormatVersion
+//	checksum uint32    CRC-32 (IEEE) of the payload
+//	length   uint32    length of the payload in bytes
+//
+// Fixed size fields are big endian.  The payload holds the instructions of the
+// main program, the names of the global variables, the constant pool and the
+// names of the builtins that the instructions refer to.  Sizes and counts in
+// the payload are unsigned varints and integers are signed varints.  Byte
+// sequences and strings are prefixed by their length, and lists by their
+// number of elements.  Each constant starts with a tag byte giving its type:
+//
+//	integer            tagInteger, value
+//	string             tagString, bytes
+//	compiled function  tagCompiledFunction, instructions, number of locals,
+//	                   number of parameters, name, local names, free names
+//
+// The operand of OpGetBuiltin is an index into the names of the builtins, so
+// that a file stays valid when builtins are added to object.Builtins.
+//
+// Version 1 files used indexes into object.Builtins instead and aren't
+// supported anymore.
+const (
+	FormatMagic   = "MKC\x00"
+	FormatVersion = 2
+
+	headerSize = len(FormatMagic) + 2 + 4 + 4
+)
+
+// Constant tags.  Never reuse the value of a removed tag.
+const (
+	tagInteger          byte = 1
+	tagCompiledFunction byte = 2
+	tagString           byte = 3
+)
+
+// Errors returned by Decode for files that aren't valid bytecode.
+var (
+	ErrBadMagic           = errors.New("not a Monkey bytecode file")
+	ErrUnsupportedVersion = errors.New("unsupported bytecode version")
+	ErrChecksum           = errors.New("bytecode checksum mismatch")
+	ErrCorrupt            = errors.New("corrupt bytecode")
+)
+
+// Encode writes bytecode to w in the bytecode file format.  It fails if a
+// constant can't be encoded.
+func Encode(w io.Writer, bytecode *Bytecode) error {
+	e := &encoder{builtins: map[int]int{}}
+
+	if err := e.instructions(bytecode.Instructions); err != nil {
+		return err
+	}
+	e.strings(bytecode.GlobalNames)
+
+	e.uvarint(uint64(len(bytecode.Constants)))
+	for i, constant := range bytecode.Constants {
+		if err := e.constant(constant); err != nil {
+			return fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	e.strings(e.builtinNames)
+
+	payload := e.buf.Bytes()
+
+	header := make([]byte, headerSize)
+	copy(header, FormatMagic)
+	offset := len(FormatMagic)
+	binary.BigEndian.PutUint16(header[offset:], FormatVersion)
+	binary.BigEndian.PutUint32(header[offset+2:], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(header[offset+6:], uint32(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Decode reads bytecode in the bytecode file format from r.  The header is
+// validated and the checksum verified before anything is decoded.  Every
+// instruction is then checked, so that the virtual machine never meets an
+// undefined opcode or an operand out of range.
+func Decode(r io.Reader) (*Bytecode, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < headerSize || string(data[:len(FormatMagic)]) != FormatMagic {
+		return nil, ErrBadMagic
+	}
+
+	offset := len(FormatMagic)
+	version := binary.BigEndian.Uint16(data[offset:])
+	checksum := binary.BigEndian.Uint32(data[offset+2:])
+	length := binary.BigEndian.Uint32(data[offset+6:])
+
+	if version != FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	payload := data[headerSize:]
+	if uint32(len(payload)) != length {
+		return nil, fmt.Errorf("%w: payload is %d bytes, want %d", ErrCorrupt,
+			len(payload), length)
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, ErrChecksum
+	}
+
+	d := &decoder{data: payload}
+
+	bytecode := &Bytecode{
+		Instructions: d.bytes(),
+		GlobalNames:  d.strings(),
+	}
+
+	count := d.count()
+	bytecode.Constants = make([]object.Object, 0, count)
+	for i := 0; i < count && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+
+	d.builtins()
+
+	d.instructions("main program", bytecode.Instructions, nil, bytecode.Constants)
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			what := fmt.Sprintf("constant %d", i)
+			d.instructions(what, fn.Instructions, fn, bytecode.Constants)
+		}
+	}
+
+	if d.err == nil && len(d.data) != 0 {
+		d.fail("%d trailing bytes", len(d.data))
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return bytecode, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+
+	// builtinNames lists the builtins that the instructions refer to, in the
+	// order they were found.  builtins maps their indexes in object.Builtins
+	// to their indexes in builtinNames.
+	builtinNames []string
+	builtins     map[int]int
+}
+
+func (e *encoder) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], x)
+	e.buf.Write(b[:n])
+}
+
+func (e *encoder) varint(x int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], x)
+	e.buf.Write(b[:n])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) strings(s []string) {
+	e.uvarint(uint64(len(s)))
+	for _, str := range s {
+		e.bytes([]byte(str))
+	}
+}
+
+// instructions writes ins with the operands of OpGetBuiltin replaced by
+// indexes into the names of the builtins.
+func (e *encoder) instructions(ins code.Instructions) error {
+	out := make(code.Instructions, len(ins))
+	copy(out, ins)
+
+	for i := 0; i < len(out); {
+		def, err := code.Lookup(out[i])
+		if err != nil {
+			return err
+		}
+		if i+1+operandsWidth(def) > len(out) {
+			return fmt.Errorf("truncated %s", def.Name)
+		}
+		operands, read := code.ReadOperands(def, out[i+1:])
+
+		if code.Opcode(out[i]) == code.OpGetBuiltin {
+			index, ok := e.builtins[operands[0]]
+			if !ok {
+				if operands[0] >= len(object.Builtins) {
+					return fmt.Errorf("builtin %d undefined", operands[0])
+				}
+				index = len(e.builtinNames)
+				e.builtins[operands[0]] = index
+				e.builtinNames = append(e.builtinNames, object.Builtins[operands[0]].Name)
+			}
+			copy(out[i:], code.Make(code.OpGetBuiltin, index))
+		}
+
+		i += 1 + read
+	}
+
+	e.bytes(out)
+	return nil
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.varint(obj.Value)
+
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.bytes([]byte(obj.Value))
+
+	case *object.CompiledFunction:
+		e.buf.WriteByte(tagCompiledFunction)
+		if err := e.instructions(obj.Instructions); err != nil {
+			return err
+		}
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParameters))
+		e.bytes([]byte(obj.Name))
+		e.strings(obj.LocalNames)
+		e.strings(obj.FreeNames)
+
+	default:
+		return fmt.Errorf("cannot encode %s", obj.Type())
+	}
+
+	return nil
+}
+
+// decoder reads the payload.  After the first error, every method returns a
+// zero value and err keeps the error.  builtinIndexes maps the indexes of the
+// names of the builtins in the file to their indexes in object.Builtins.
+type decoder struct {
+	data           []byte
+	err            error
+	builtinIndexes []int
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+	}
+	d.data = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("bad unsigned varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+// count reads a size or count, which can't exceed the number of bytes left.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail("length %d exceeds remaining %d bytes", n, len(d.data))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) strings() []string {
+	n := d.count()
+	s := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		s = append(s, string(d.bytes()))
+	}
+	return s
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+
+	case tagCompiledFunction:
+		return &object.CompiledFunction{
+			Instructions:  code.Instructions(d.bytes()),
+			NumLocals:     int(d.uvarint()),
+			NumParameters: int(d.uvarint()),
+			Name:          string(d.bytes()),
+			LocalNames:    d.strings(),
+			FreeNames:     d.strings(),
+		}
+
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
+
+// builtins reads the names of the builtins and looks them up.
+func (d *decoder) builtins() {
+	for _, name := range d.strings() {
+		index := -1
+		for i, b := range object.Builtins {
+			if b.Name == name {
+				index = i
+			}
+		}
+		if index == -1 {
+			d.fail("unknown builtin %s", name)
+			return
+		}
+		d.builtinIndexes = append(d.builtinIndexes, index)
+	}
+}
+
+// instructions checks ins, the instructions of fn or of the main program if
+// fn is nil.  Every opcode must be defined and every operand must refer to
+// something that exists: a constant of the right type, a local or free
+// variable of fn, a builtin or the start of an instruction.  The operands of
+// OpGetBuiltin are replaced by indexes into object.Builtins.
+func (d *decoder) instructions(what string, ins code.Instructions,
+	fn *object.CompiledFunction, constants []object.Object) {
+	numLocals, numFree := 0, 0
+	if fn != nil {
+		if fn.NumParameters > fn.NumLocals || fn.NumLocals > 256 {
+			d.fail("%s: %d parameters and %d local variables", what,
+				fn.NumParameters, fn.NumLocals)
+			return
+		}
+		numLocals, numFree = fn.NumLocals, len(fn.FreeNames)
+	}
+
+	starts := map[int]bool{}
+	var jumps []int
+
+	for i := 0; i < len(ins) && d.err == nil; {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			d.fail("%s: instruction %d: %s", what, i, err)
+			return
+		}
+
+		width := operandsWidth(def)
+		if i+1+width > len(ins) {
+			d.fail("%s: instruction %d: truncated %s", what, i, def.Name)
+			return
+		}
+		operands, _ := code.ReadOperands(def, ins[i+1:])
+
+		// check fails unless the operand n is below limit.
+		check := func(n, limit int) {
+			if operands[n] >= limit {
+				d.fail("%s: instruction %d: operand %d of %s out of range: %d",
+					what, i, n, def.Name, operands[n])
+			}
+		}
+
+		switch code.Opcode(ins[i]) {
+		case code.OpConstant:
+			check(0, len(constants))
+
+		case code.OpClosure:
+			check(0, len(constants))
+			if d.err == nil {
+				closed, ok := constants[operands[0]].(*object.CompiledFunction)
+				if !ok || len(closed.FreeNames) != operands[1] {
+					d.fail("%s: instruction %d: OpClosure of constant %d", what, i,
+						operands[0])
+				}
+			}
+
+		case code.OpGetProperty:
+			check(0, len(constants))
+			if d.err == nil {
+				if _, ok := constants[operands[0]].(*object.String); !ok {
+					d.fail("%s: instruction %d: OpGetProperty of constant %d", what,
+						i, operands[0])
+				}
+			}
+
+		case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+			check(0, numLocals)
+
+		case code.OpGetFree, code.OpCaptureFree:
+			check(0, numFree)
+
+		case code.OpGetBuiltin:
+			check(0, len(d.builtinIndexes))
+			if d.err == nil {
+				copy(ins[i:], code.Make(code.OpGetBuiltin, d.builtinIndexes[operands[0]]))
+			}
+
+		case code.OpJump, code.OpJumpNotTruthy, code.OpTry:
+			jumps = append(jumps, operands[0])
+		}
+
+		starts[i] = true
+		i += 1 + width
+	}
+
+	for _, target := range jumps {
+		if target != len(ins) && !starts[target] && d.err == nil {
+			d.fail("%s: jump to %d isn't the start of an instruction", what, target)
+		}
+	}
+}
+
+// operandsWidth returns the number of bytes of the operands of def.
+func operandsWidth(def *code.Definition) int {
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
+}
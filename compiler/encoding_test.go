@@ -0,0 +1,336 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"monkey/code"
+	"monkey/object"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	input := `
+let limit = -100000;
+let newAdder = fn(a) { fn(b) { let c = a + b; c } };
+let x = try { newAdder(1)(limit) } catch (e) { e } finally { 0 };
+match (x) { 1 => true, n => n };
+let greeting = "hello\n" + "";`
+
+	comp := New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	expected := comp.Bytecode()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, expected); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	actual, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if err := testInstructions(
+		[]code.Instructions{expected.Instructions}, actual.Instructions); err != nil {
+		t.Errorf("wrong instructions: %s", err)
+	}
+
+	testStrings(t, "global names", expected.GlobalNames, actual.GlobalNames)
+
+	if len(actual.Constants) != len(expected.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d",
+			len(expected.Constants), len(actual.Constants))
+	}
+
+	for i, constant := range expected.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			if err := testIntegerObject(constant.Value, actual.Constants[i]); err != nil {
+				t.Errorf("constant %d: %s", i, err)
+			}
+
+		case *object.String:
+			str, ok := actual.Constants[i].(*object.String)
+			if !ok || str.Value != constant.Value {
+				t.Errorf("constant %d: wrong string. want=%q, got=%v", i,
+					constant.Value, actual.Constants[i])
+			}
+
+		case *object.CompiledFunction:
+			fn, ok := actual.Constants[i].(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d is not a function. got=%T", i,
+					actual.Constants[i])
+				continue
+			}
+
+			if err := testInstructions(
+				[]code.Instructions{constant.Instructions}, fn.Instructions); err != nil {
+				t.Errorf("constant %d: %s", i, err)
+			}
+
+			if fn.NumLocals != constant.NumLocals ||
+				fn.NumParameters != constant.NumParameters ||
+				fn.Name != constant.Name {
+				t.Errorf("constant %d: wrong function. want=%+v, got=%+v", i,
+					constant, fn)
+			}
+
+			testStrings(t, "local names", constant.LocalNames, fn.LocalNames)
+			testStrings(t, "free names", constant.FreeNames, fn.FreeNames)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	comp := New()
+	if err := comp.Compile(parse("let f = fn(x) { x * 2 }; f(21)")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, comp.Bytecode()); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	modified := func(f func(b []byte) []byte) []byte {
+		b := append([]byte{}, valid...)
+		return f(b)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", []byte{}, ErrBadMagic},
+		{"magic", modified(func(b []byte) []byte {
+			b[0] = 'X'
+			return b
+		}), ErrBadMagic},
+		{"version", modified(func(b []byte) []byte {
+			b[5] = FormatVersion + 1
+			return b
+		}), ErrUnsupportedVersion},
+		{"checksum", modified(func(b []byte) []byte {
+			b[len(b)-1]++
+			return b
+		}), ErrChecksum},
+		{"truncated", valid[:len(valid)-1], ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestEncodeDecodeBuiltins(t *testing.T) {
+	input := `let f = fn(a) { push(a, len(a)) }; len(f([]))`
+
+	comp := New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	expected := comp.Bytecode()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, expected); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	// The file refers to builtins by their names, in the order they were
+	// found: len first, then push.
+	payload := buf.Bytes()[headerSize:]
+	names := []byte("\x02\x03len\x04push")
+	if !bytes.HasSuffix(payload, names) {
+		t.Errorf("wrong builtin names. want suffix %q, got=%q", names, payload)
+	}
+
+	actual, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if err := testInstructions(
+		[]code.Instructions{expected.Instructions}, actual.Instructions); err != nil {
+		t.Errorf("wrong instructions: %s", err)
+	}
+
+	for i, constant := range expected.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			actualFn := actual.Constants[i].(*object.CompiledFunction)
+			if err := testInstructions(
+				[]code.Instructions{fn.Instructions}, actualFn.Instructions); err != nil {
+				t.Errorf("constant %d: %s", i, err)
+			}
+		}
+	}
+}
+
+func TestDecodeUnknownBuiltin(t *testing.T) {
+	data := encodePatched(t, &Bytecode{
+		Instructions: code.Make(code.OpGetBuiltin, 0),
+	}, func(payload []byte) {
+		// A newer version of the interpreter with a builtin this one doesn't
+		// have would write a name this one doesn't know.
+		payload[len(payload)-1] = 'x'
+	})
+
+	_, err := Decode(bytes.NewReader(data))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("wrong error. want=%q, got=%q", ErrCorrupt, err)
+	}
+}
+
+func TestDecodeInvalidInstructions(t *testing.T) {
+	function := func(numLocals, numParameters int, freeNames []string,
+		ins ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{
+			Instructions:  concatInstructions(ins),
+			NumLocals:     numLocals,
+			NumParameters: numParameters,
+			FreeNames:     freeNames,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		bytecode *Bytecode
+	}{
+		{"constant", &Bytecode{
+			Instructions: code.Make(code.OpConstant, 1),
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+		}},
+		{"closure of an integer", &Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 0),
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+		}},
+		{"closure free variables", &Bytecode{
+			Instructions: code.Make(code.OpClosure, 0, 1),
+			Constants:    []object.Object{function(0, 0, nil)},
+		}},
+		{"property", &Bytecode{
+			Instructions: code.Make(code.OpGetProperty, 0),
+			Constants:    []object.Object{&object.Integer{Value: 1}},
+		}},
+		{"local in the main program", &Bytecode{
+			Instructions: code.Make(code.OpGetLocal, 0),
+		}},
+		{"local", &Bytecode{
+			Constants: []object.Object{
+				function(1, 1, nil, code.Make(code.OpSetLocal, 1)),
+			},
+		}},
+		{"free variable", &Bytecode{
+			Constants: []object.Object{
+				function(0, 0, []string{"a"}, code.Make(code.OpGetFree, 1)),
+			},
+		}},
+		{"parameters", &Bytecode{
+			Constants: []object.Object{function(1, 2, nil)},
+		}},
+		{"jump", &Bytecode{
+			Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpJump, 4),
+				code.Make(code.OpConstant, 0),
+			}),
+			Constants: []object.Object{&object.Integer{Value: 1}},
+		}},
+		{"jump past the end", &Bytecode{
+			Instructions: code.Make(code.OpJump, 4),
+		}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.bytecode); err != nil {
+			t.Errorf("%s: encode error: %s", tt.name, err)
+			continue
+		}
+
+		_, err := Decode(&buf)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, ErrCorrupt, err)
+		}
+	}
+
+	// The encoder rejects instructions that aren't defined, so these are
+	// written over valid ones.  The instructions of the main program start
+	// after their length.
+	patches := []struct {
+		name  string
+		patch func(payload []byte)
+	}{
+		{"undefined opcode", func(payload []byte) {
+			payload[1] = 255
+		}},
+		{"truncated operand", func(payload []byte) {
+			payload[2] = byte(code.OpConstant)
+		}},
+	}
+
+	for _, tt := range patches {
+		data := encodePatched(t, &Bytecode{
+			Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpPop),
+				code.Make(code.OpPop),
+			}),
+		}, tt.patch)
+
+		_, err := Decode(bytes.NewReader(data))
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, ErrCorrupt, err)
+		}
+	}
+}
+
+// encodePatched encodes bytecode, changes the payload with patch and updates
+// the checksum.
+func encodePatched(t *testing.T, bytecode *Bytecode,
+	patch func(payload []byte)) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, bytecode); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	data := buf.Bytes()
+	patch(data[headerSize:])
+	binary.BigEndian.PutUint32(data[len(FormatMagic)+2:],
+		crc32.ChecksumIEEE(data[headerSize:]))
+	return data
+}
+
+func TestEncodeUnsupportedConstant(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, bytecode); err == nil {
+		t.Errorf("expected an error for a boolean constant")
+	}
+}
+
+func testStrings(t *testing.T, what string, expected, actual []string) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Errorf("wrong %s. want=%q, got=%q", what, expected, actual)
+		return
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("wrong %s. want=%q, got=%q", what, expected, actual)
+			return
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package compiler
+
+import "monkey/object"
+
+// SymbolScope tells where the value of a symbol is stored at run time.
+type SymbolScope string
+
+// List of symbol scopes.
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+	BuiltinScope  SymbolScope = "BUILTIN"
+)
+
+// Symbol is an identifier resolved to a slot.  Index is the slot of the symbol
+// in its scope.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable maps identifiers to symbols.  There are three kinds of symbol
+// tables:
+//
+//   - The global symbol table has no outer table and defines global symbols.
+//   - A function symbol table belongs to a function literal and defines local
+//     symbols.  Symbols of outer function tables are captured as free symbols.
+//   - A block symbol table limits the visibility of the identifiers bound in a
+//     match arm or catch block.  It defines its symbols in the slots of the
+//     nearest global or function table.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	// FreeSymbols holds the symbols captured from outer function tables, in
+	// the order they are captured.  It is only used by function tables.
+	FreeSymbols []Symbol
+
+	store map[string]Symbol
+	block bool
+
+	// names holds the names of the slots of a global or function table by
+	// index.
+	names []string
+}
+
+// NewSymbolTable returns a new global symbol table.
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
+}
+
+// NewEnclosedSymbolTable returns a new function symbol table.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable returns a new block symbol table.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
+// owner returns the global or function table whose slots s uses.
+func (s *SymbolTable) owner() *SymbolTable {
+	if s.block {
+		return s.Outer.owner()
+	}
+	return s
+}
+
+// global returns the global symbol table.
+func (s *SymbolTable) global() *SymbolTable {
+	if s.Outer == nil {
+		return s
+	}
+	return s.Outer.global()
+}
+
+// newSlot reserves the next slot of the owner of s.
+func (s *SymbolTable) newSlot(name string) Symbol {
+	owner := s.owner()
+
+	symbol := Symbol{Name: name, Index: len(owner.names)}
+	if owner.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	owner.names = append(owner.names, name)
+	return symbol
+}
+
+// Define binds name to a slot.  Defining a name that is already bound to a
+// global or local slot in this table reuses that slot, just like let
+// statements overwrite an existing binding in an environment.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			return symbol
+		}
+	}
+
+	symbol := s.newSlot(name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineTemp reserves a slot that can't be referred to by name.  The compiler
+// uses it to keep intermediate values around.
+func (s *SymbolTable) DefineTemp() Symbol {
+	return s.newSlot("")
+}
+
+// DefineGlobal binds name to a new slot in the global symbol table.  It is used
+// for identifiers that aren't bound yet, so that they can still be defined by a
+// later let statement.
+func (s *SymbolTable) DefineGlobal(name string) Symbol {
+	return s.global().Define(name)
+}
+
+// DefineFunctionName binds name to the function that owns the table.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// defineFree captures original, a symbol of an outer function table.
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve returns the symbol bound to name, searching the outer tables if
+// needed.  Local symbols found beyond the enclosing function table are
+// captured as free symbols.  Names the global table doesn't bind resolve to
+// the builtin of that name, if there is one.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok {
+		return symbol, ok
+	}
+	if s.Outer == nil {
+		return resolveBuiltin(name)
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if !ok || s.block || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
+
+// resolveBuiltin returns the symbol of the builtin called name.  Its index is
+// the index of the builtin in object.Builtins.
+func resolveBuiltin(name string) (Symbol, bool) {
+	for i, b := range object.Builtins {
+		if b.Name == name {
+			return Symbol{Name: name, Scope: BuiltinScope, Index: i}, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// NumDefinitions returns the number of slots used by a global or function
+// table, including the ones used by its block tables.
+func (s *SymbolTable) NumDefinitions() int {
+	return len(s.owner().names)
+}
+
+// Names returns the names of the slots used by a global or function table by
+// index.
+func (s *SymbolTable) Names() []string {
+	return s.owner().names
+}
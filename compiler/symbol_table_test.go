@@ -0,0 +1,234 @@
+package compiler
+
+import "testing"
+
+func TestDefine(t *testing.T) {
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+		"d": {Name: "d", Scope: LocalScope, Index: 1},
+	}
+
+	global := NewSymbolTable()
+
+	if a := global.Define("a"); a != expected["a"] {
+		t.Errorf("expected a=%+v, got=%+v", expected["a"], a)
+	}
+
+	if b := global.Define("b"); b != expected["b"] {
+		t.Errorf("expected b=%+v, got=%+v", expected["b"], b)
+	}
+
+	// Defining a again reuses its slot.
+	if a := global.Define("a"); a != expected["a"] {
+		t.Errorf("expected a=%+v, got=%+v", expected["a"], a)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+
+	if c := local.Define("c"); c != expected["c"] {
+		t.Errorf("expected c=%+v, got=%+v", expected["c"], c)
+	}
+
+	if d := local.Define("d"); d != expected["d"] {
+		t.Errorf("expected d=%+v, got=%+v", expected["d"], d)
+	}
+
+	if global.NumDefinitions() != 2 || local.NumDefinitions() != 2 {
+		t.Errorf("wrong number of definitions. global=%d, local=%d",
+			global.NumDefinitions(), local.NumDefinitions())
+	}
+}
+
+func TestResolveNestedLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("c")
+
+	tests := []struct {
+		table           *SymbolTable
+		expectedSymbols []Symbol
+		expectedFree    []Symbol
+	}{
+		{
+			firstLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: LocalScope, Index: 0},
+			},
+			[]Symbol{},
+		},
+		{
+			secondLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: FreeScope, Index: 0},
+				{Name: "c", Scope: LocalScope, Index: 0},
+			},
+			[]Symbol{
+				{Name: "b", Scope: LocalScope, Index: 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, sym := range tt.expectedSymbols {
+			result, ok := tt.table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v",
+					sym.Name, sym, result)
+			}
+		}
+
+		if len(tt.table.FreeSymbols) != len(tt.expectedFree) {
+			t.Fatalf("wrong number of free symbols. got=%d, want=%d",
+				len(tt.table.FreeSymbols), len(tt.expectedFree))
+		}
+
+		for i, sym := range tt.expectedFree {
+			if tt.table.FreeSymbols[i] != sym {
+				t.Errorf("wrong free symbol. got=%+v, want=%+v",
+					tt.table.FreeSymbols[i], sym)
+			}
+		}
+	}
+}
+
+func TestResolveUnresolvable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+
+	if _, ok := local.Resolve("b"); ok {
+		t.Errorf("name b resolved, but was expected not to")
+	}
+
+	// Unbound names can be defined as globals from any table.
+	b := local.DefineGlobal("b")
+	expected := Symbol{Name: "b", Scope: GlobalScope, Index: 1}
+	if b != expected {
+		t.Errorf("expected b=%+v, got=%+v", expected, b)
+	}
+
+	if result, ok := global.Resolve("b"); !ok || result != expected {
+		t.Errorf("expected b to resolve to %+v, got=%+v", expected, result)
+	}
+}
+
+func TestResolveBuiltins(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+	nested := NewEnclosedSymbolTable(local)
+
+	expected := Symbol{Name: "len", Scope: BuiltinScope, Index: 7}
+	for _, table := range []*SymbolTable{global, local, nested} {
+		result, ok := table.Resolve("len")
+		if !ok || result != expected {
+			t.Errorf("expected len to resolve to %+v, got=%+v", expected, result)
+		}
+	}
+
+	// Builtins aren't captured as free symbols.
+	if len(nested.FreeSymbols) != 0 {
+		t.Errorf("wrong number of free symbols. got=%d", len(nested.FreeSymbols))
+	}
+
+	global.Define("len")
+	expected = Symbol{Name: "len", Scope: GlobalScope, Index: 0}
+	if result, ok := nested.Resolve("len"); !ok || result != expected {
+		t.Errorf("expected len to resolve to %+v, got=%+v", expected, result)
+	}
+}
+
+func TestBlockSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+
+	block := NewBlockSymbolTable(local)
+	temp := block.DefineTemp()
+	b := block.Define("b")
+
+	expectedTemp := Symbol{Name: "", Scope: LocalScope, Index: 1}
+	if temp != expectedTemp {
+		t.Errorf("expected temp=%+v, got=%+v", expectedTemp, temp)
+	}
+
+	// A block shadows the outer b with a new slot of the function.
+	expectedB := Symbol{Name: "b", Scope: LocalScope, Index: 2}
+	if b != expectedB {
+		t.Errorf("expected b=%+v, got=%+v", expectedB, b)
+	}
+
+	if result, _ := local.Resolve("b"); result.Index != 0 {
+		t.Errorf("block definition leaked into function table. got=%+v", result)
+	}
+
+	if local.NumDefinitions() != 3 {
+		t.Errorf("wrong number of definitions. got=%d", local.NumDefinitions())
+	}
+
+	expectedNames := []string{"b", "", "b"}
+	for i, name := range expectedNames {
+		if local.Names()[i] != name {
+			t.Errorf("wrong name for slot %d. want=%q, got=%q", i, name,
+				local.Names()[i])
+		}
+	}
+
+	// Blocks don't capture free symbols, but the function tables around them
+	// do.
+	nested := NewEnclosedSymbolTable(block)
+	innerBlock := NewBlockSymbolTable(nested)
+
+	result, ok := innerBlock.Resolve("b")
+	expected := Symbol{Name: "b", Scope: FreeScope, Index: 0}
+	if !ok || result != expected {
+		t.Errorf("expected b to resolve to %+v, got=%+v", expected, result)
+	}
+
+	if len(innerBlock.FreeSymbols) != 0 || len(nested.FreeSymbols) != 1 {
+		t.Errorf("wrong free symbols. block=%+v, function=%+v",
+			innerBlock.FreeSymbols, nested.FreeSymbols)
+	}
+
+	if result, _ := innerBlock.Resolve("a"); result.Scope != GlobalScope {
+		t.Errorf("expected a to resolve to a global. got=%+v", result)
+	}
+}
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v",
+			expected.Name, expected, result)
+	}
+
+	// Defining the same name shadows the function name.
+	shadowed := global.Define("a")
+	if shadowed.Scope != GlobalScope {
+		t.Errorf("expected a to be shadowed by a global. got=%+v", shadowed)
+	}
+}
@@ -0,0 +1,86 @@
+package compiler
+
+import (
+	"monkey/ast"
+	"monkey/code"
+)
+
+// compileFunc compiles a node.  It is either Compiler.Compile or the version
+// of Compiler.compileTail for a position.
+type compileFunc func(node ast.Node) error
+
+// position tells which calls in a node are tail calls, whose value is the
+// value of the function call they are in.
+type position int
+
+const (
+	// In return position, only the calls returned by return statements are
+	// tail calls.  The value of the node itself is discarded.
+	returnPosition position = iota
+	// In tail position, the value of the node is also the value of the
+	// function call, so calls that give the node its value are tail calls.
+	tailPosition
+)
+
+// compileTail compiles node, which is in position pos of a function body,
+// like Compile.  Tail calls are compiled to OpTailCall.  Nodes that have no
+// tail calls in pos are compiled by Compile, so the tail calls are the ones
+// of the evaluator.
+func (c *Compiler) compileTail(node ast.Node, pos position) error {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for i, s := range node.Statements {
+			statementPos := returnPosition
+			if i == len(node.Statements)-1 {
+				statementPos = pos
+			}
+
+			if err := c.compileTail(s, statementPos); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *ast.ExpressionStatement:
+		if err := c.compileTail(node.Expression, pos); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+		return nil
+
+	case *ast.ReturnStatement:
+		if err := c.compileTail(node.ReturnValue, tailPosition); err != nil {
+			return err
+		}
+		return c.compileReturn()
+
+	case *ast.CallExpression:
+		if pos == tailPosition {
+			return c.compileCall(node, code.OpTailCall)
+		}
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node, c.tailCompile(pos))
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node, c.tailCompile(pos))
+
+	case *ast.SwitchExpression:
+		return c.compileSwitchExpression(node, c.tailCompile(pos))
+
+	case *ast.TernaryExpression:
+		if pos == tailPosition {
+			return c.compileTernaryExpression(node, c.tailCompile(pos))
+		}
+	}
+
+	return c.Compile(node)
+}
+
+// tailCompile returns the compileFunc of the branches of a conditional in
+// position pos.  They are in the same position as the conditional.
+func (c *Compiler) tailCompile(pos position) compileFunc {
+	return func(node ast.Node) error {
+		return c.compileTail(node, pos)
+	}
+}
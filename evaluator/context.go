@@ -0,0 +1,28 @@
+package evaluator
+
+import (
+	"context"
+	"monkey/ast"
+	"monkey/object"
+)
+
+// EvalContext evaluates node in env like Eval, but stops once ctx is done or
+// limits.MaxSteps function calls have been made, whichever comes first.  The
+// evaluation then returns an error of kind object.CANCELLED_ERROR,
+// object.TIMEOUT_ERROR or object.BUDGET_ERROR, which try expressions can't
+// catch.
+//
+// The strings, arrays and hashes that the evaluation creates count against
+// limits.MaxBytes by their approximate size, whether or not they are still in
+// use.  Once an expression would exceed it, it raises an error of kind
+// object.MEMORY_ERROR instead, which can be caught.
+//
+// Functions created during the evaluation keep no budget: calling them later
+// from an evaluation with Eval is unlimited again.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) object.Object {
+	previous := env.Budget()
+	env.SetBudget(object.NewBudget(ctx, limits))
+	defer env.SetBudget(previous)
+
+	return Eval(node, env)
+}
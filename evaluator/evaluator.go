@@ -2,6 +2,7 @@
 package evaluator
 
 import (
+	"fmt"
 	"monkey/ast"
 	"monkey/object"
 )
@@ -9,21 +10,23 @@ import (
 var (
 	// Create "singleton" boolean values.  The book explains it as a small
 	// performance improvement since we only declare the boolean objects once.
+	// They are shared by every evaluation, including concurrent ones, so they
+	// must never be modified.
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 	NULL  = &object.Null{}
 )
 
 // Eval evaluates a node and returns the node's value or traverses to the next
-// expression to be evaluated.
-func Eval(node ast.Node) object.Object {
+// expression to be evaluated.  Identifiers are looked up in and bound to env.
+func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	case *ast.Program:
-		return evalProgram(node)
+		return evalProgram(node, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression)
+		return Eval(node.Expression, env)
 
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
@@ -31,42 +34,203 @@ func Eval(node ast.Node) object.Object {
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return allocate(env, &object.Array{Elements: elements})
+
+	case *ast.HashLiteral:
+		return allocate(env, evalHashLiteral(node, env))
+
 	case *ast.PrefixExpression:
-		right := Eval(node.Right)
+		right := Eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left)
-		right := Eval(node.Right)
-		return evalInfixExpression(node.Operator, left, right)
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		right := Eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+		return allocate(env, evalInfixExpression(node.Operator, left, right))
 
 	case *ast.IfExpression:
-		return evalIfExpression(node)
+		return evalIfExpression(node, env, Eval)
+
+	case *ast.TernaryExpression:
+		return evalTernaryExpression(node, env, Eval)
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env, Eval)
+
+	case *ast.SwitchExpression:
+		return evalSwitchExpression(node, env, Eval)
 
 	// This evaluates the block statements in each branch of the if expression.
 	case *ast.BlockStatement:
-		return evalBlockStatement(node)
+		return evalBlockStatement(node, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue)
+		val := Eval(node.ReturnValue, env)
+		if isError(val) {
+			return val
+		}
 		return &object.ReturnValue{Value: val}
+
+	case *ast.ThrowStatement:
+		return evalThrowStatement(node, env)
+
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
+	case *ast.LetStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		bind(env, node.Name, val)
+
+	case *ast.Identifier:
+		return evalIdentifier(node, env)
+
+	case *ast.FunctionLiteral:
+		params := node.Parameters
+		body := node.Body
+		return &object.Function{Parameters: params, Env: env, Body: body}
+
+	case *ast.CallExpression:
+		return evalCallExpression(node, env)
+
+	case *ast.DotExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		return evalDotExpression(left, node.Name)
+
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+		return evalIndexExpression(left, index)
+
+	case *ast.DeferStatement:
+		env.Defer(object.Deferred{Expression: node.Expression, Env: env})
 	}
 
 	return nil
 }
 
-func evalIfExpression(ie *ast.IfExpression) object.Object {
-	condition := Eval(ie.Condition)
+// newError creates an error object of the given kind whose message is
+// formatted like fmt.Sprintf.
+func newError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// isError reports whether obj is an error object.  Errors are checked right
+// after evaluating a node so that they stop the evaluation of the program.
+func isError(obj object.Object) bool {
+	if obj != nil {
+		return obj.Type() == object.ERROR_OBJ
+	}
+	return false
+}
+
+// isUncatchable reports whether obj is an error that stops the evaluation from
+// outside.
+func isUncatchable(obj object.Object) bool {
+	err, ok := obj.(*object.Error)
+	return ok && !err.Catchable()
+}
+
+// evalIdentifier looks up the value of an identifier, using its slot if it has
+// been resolved.
+func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	var val object.Object
+	var ok bool
+
+	if node.Resolved {
+		val, ok = env.GetAt(node.Depth, node.Index)
+	} else {
+		val, ok = env.Get(node.Value)
+	}
+
+	if !ok {
+		if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
+			return builtin
+		}
+		return newError(object.NAME_ERROR, "identifier not found: %s", node.Value)
+	}
+
+	return val
+}
+
+// bind binds val to ident in env.
+func bind(env *object.Environment, ident *ast.Identifier, val object.Object) {
+	if ident.Resolved {
+		env.SetAt(ident.Index, val)
+	} else {
+		env.Set(ident.Value, val)
+	}
+}
+
+// allocate counts obj against the memory limit of env, if it is a string, an
+// array or a hash.  It returns obj, or the error raised if it doesn't fit.
+func allocate(env *object.Environment, obj object.Object) object.Object {
+	if err := env.Budget().AllocateObject(obj); err != nil {
+		return err
+	}
+	return obj
+}
+
+// evalIfExpression evaluates the branch selected by the condition with
+// evalBranch.
+func evalIfExpression(ie *ast.IfExpression, env *object.Environment, evalBranch evalFunc) object.Object {
+	condition := Eval(ie.Condition, env)
+	if isError(condition) {
+		return condition
+	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence)
+		return evalBranch(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative)
+		return evalBranch(ie.Alternative, env)
 	} else {
 		return NULL
 	}
 }
 
+// evalTernaryExpression evaluates only the branch selected by the condition,
+// with evalBranch.
+func evalTernaryExpression(te *ast.TernaryExpression, env *object.Environment, evalBranch evalFunc) object.Object {
+	condition := Eval(te.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+
+	if isTruthy(condition) {
+		return evalBranch(te.Consequence, env)
+	}
+
+	return evalBranch(te.Alternative, env)
+}
+
 // isTruthy defines what objects are "truthy".  Basically, it's any object that
 // is _not_ NULL or false.
 func isTruthy(obj object.Object) bool {
@@ -93,14 +257,17 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 
 // evalProgram evaluates statements until the end of the program or a return
 // object is encountered.
-func evalProgram(program *ast.Program) object.Object {
+func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range program.Statements {
-		result = Eval(statement)
+		result = Eval(statement, env)
 
-		if returnValue, ok := result.(*object.ReturnValue); ok {
-			return returnValue.Value
+		switch result := result.(type) {
+		case *object.ReturnValue:
+			return result.Value
+		case *object.Error:
+			return result
 		}
 	}
 
@@ -108,24 +275,492 @@ func evalProgram(program *ast.Program) object.Object {
 }
 
 // evalBlockStatement evaluates statements inside curly brackets.  It stops
-// evaluating statements if no more statements are available or a return object
-// is encountered.
-func evalBlockStatement(block *ast.BlockStatement) object.Object {
+// evaluating statements if no more statements are available or a return or
+// error object is encountered.  A block without a value, because it is empty
+// or its last statement isn't an expression, evaluates to NULL.
+func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range block.Statements {
-		result = Eval(statement)
+		result = Eval(statement, env)
 
 		//  Instead of unwrapping return objects, it returns the return object.
 		//  This return object gets unwrapped by evalProgram.
-		if result != nil && result.Type() == object.RETURN_VALUE_OBJ {
-			return result
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// evalCallExpression evaluates the function and then its arguments from left to
+// right before applying the function.  An error raised by the call gets the
+// called expression added to its stack.
+func evalCallExpression(ce *ast.CallExpression, env *object.Environment) object.Object {
+	function := Eval(ce.Function, env)
+	if isError(function) {
+		return function
+	}
+
+	args := evalExpressions(ce.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+
+	result := applyFunction(function, args, env)
+	if err, ok := result.(*object.Error); ok {
+		err.AddToStack(ce.Function.String())
+	}
+
+	return result
+}
+
+// evalExpressions evaluates exps from left to right.  If one of them raises an
+// error, it returns a slice holding only that error.
+func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, e := range exps {
+		evaluated := Eval(e, env)
+		if isError(evaluated) {
+			return []object.Object{evaluated}
 		}
+		result = append(result, evaluated)
 	}
 
 	return result
 }
 
+// applyFunction evaluates the body of fn in a new environment that binds its
+// parameters to args.  Once the body is done, whether it returned, raised an
+// error or simply ran to the end, the deferred expressions are evaluated.
+//
+// Calls in tail position are run by the same applyFunction, one after the
+// other, instead of nesting calls to Eval.  This way, recursion in tail
+// position doesn't grow the Go stack.  What the nested calls would do on the
+// way back, running deferred expressions and adding to the stack of an error,
+// is done once the last call is done.  Tail calls don't add to the call depth.
+//
+// caller is the environment of the call.  If the call would exceed its maximum
+// call depth, a recursion error is raised instead.  Every call, tail calls
+// included, takes a step of the budget of caller, if it has one.
+func applyFunction(fn object.Object, args []object.Object, caller *object.Environment) object.Object {
+	var calls tailCalls
+	var result object.Object
+	name := ""
+
+	depth := caller.CallDepth() + 1
+	if depth > caller.MaxCallDepth() {
+		return newError(object.RECURSION_ERROR,
+			"maximum recursion depth exceeded (%d)", caller.MaxCallDepth())
+	}
+
+	for {
+		if err := caller.Budget().Step(); err != nil {
+			result = err
+			calls.push(name, nil)
+			break
+		}
+
+		if builtin, ok := fn.(*object.Builtin); ok {
+			result = applyBuiltin(builtin, args, caller)
+			calls.push(name, nil)
+			break
+		}
+
+		function, ok := fn.(*object.Function)
+		if !ok {
+			result = newError(object.TYPE_ERROR, "not a function: %s", fn.Type())
+			calls.push(name, nil)
+			break
+		}
+
+		if len(args) != len(function.Parameters) {
+			result = newError(object.TYPE_ERROR,
+				"wrong number of arguments: want=%d, got=%d",
+				len(function.Parameters), len(args))
+			calls.push(name, nil)
+			break
+		}
+
+		extendedEnv := extendFunctionEnv(function, args, caller)
+		result = evalTail(function.Body, extendedEnv, tailPosition)
+		calls.push(name, extendedEnv)
+
+		tc, ok := result.(*tailCall)
+		if !ok {
+			break
+		}
+		fn, args, name = tc.function, tc.args, tc.name
+	}
+
+	return calls.unwind(result)
+}
+
+// Apply calls fn with args and returns its value, or the error it raised.  fn is
+// a function or builtin.  The call is made from env, which gives it its call
+// depth and budget, like a call expression evaluated in env.
+func Apply(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	return applyFunction(fn, args, env)
+}
+
+// applyBuiltin calls b with args if the sandbox of caller permits it.
+func applyBuiltin(b *object.Builtin, args []object.Object, caller *object.Environment) object.Object {
+	if err := caller.Sandbox().Check(b); err != nil {
+		return err
+	}
+
+	return canonical(b.Fn(caller, args...))
+}
+
+// canonical replaces the booleans and nulls returned by builtins with the
+// singletons, which the evaluator compares by identity.  Builtins may also
+// return nil for null.
+func canonical(obj object.Object) object.Object {
+	switch obj := obj.(type) {
+	case nil:
+		return NULL
+	case *object.Boolean:
+		return nativeBoolToBooleanObject(obj.Value)
+	case *object.Null:
+		return NULL
+	}
+	return obj
+}
+
+// extendFunctionEnv returns the environment of a single call to fn made from
+// the environment caller.
+func extendFunctionEnv(fn *object.Function, args []object.Object, caller *object.Environment) *object.Environment {
+	env := object.NewFunctionEnvironment(fn.Env, caller)
+
+	for paramIdx, param := range fn.Parameters {
+		bind(env, param, args[paramIdx])
+	}
+
+	return env
+}
+
+// runDeferred evaluates the expressions deferred in the function call
+// environment env in last-in-first-out order and returns the result of the
+// call.  The values of deferred expressions are discarded, but an error raised
+// by one replaces result, unless result is an error that can't be caught.  The
+// remaining deferred expressions still run.
+func runDeferred(env *object.Environment, result object.Object) object.Object {
+	deferred := env.Deferred()
+
+	for i := len(deferred) - 1; i >= 0; i-- {
+		d := deferred[i]
+
+		evaluated := Eval(d.Expression, d.Env)
+		if isError(evaluated) && !isUncatchable(result) {
+			result = evaluated
+		}
+	}
+
+	return result
+}
+
+// unwrapReturnValue stops a return statement inside a function from also
+// returning from the caller.
+func unwrapReturnValue(obj object.Object) object.Object {
+	if returnValue, ok := obj.(*object.ReturnValue); ok {
+		return returnValue.Value
+	}
+
+	return obj
+}
+
+// evalDotExpression reads the property name of obj.  If obj has no such
+// property but has a method called name, it returns a builtin that calls the
+// method.  The builtin has no capability: the host that gave obj to the program
+// allowed its methods.
+func evalDotExpression(obj object.Object, name string) object.Object {
+	if getter, ok := obj.(object.PropertyGetter); ok {
+		if val, ok := getter.GetProperty(name); ok {
+			return canonical(val)
+		}
+	}
+
+	if caller, ok := obj.(object.MethodCaller); ok && caller.HasMethod(name) {
+		fn := func(env *object.Environment, args ...object.Object) object.Object {
+			return caller.CallMethod(name, args)
+		}
+		return &object.Builtin{Name: name, Fn: fn}
+	}
+
+	return newError(object.NAME_ERROR, "property not found: %s.%s", obj.Type(), name)
+}
+
+// evalIndexExpression returns the element of an array or the value of a hash
+// at index, or NULL if there is none.  Other objects can be indexed if they
+// implement object.Indexer.
+func evalIndexExpression(left, index object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError(object.TYPE_ERROR, "array index must be INTEGER, got %s",
+				index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return NULL
+		}
+		return left.Elements[i.Value]
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
+		}
+		if pair, ok := left.Pairs[key.HashKey()]; ok {
+			return pair.Value
+		}
+		return NULL
+
+	case object.Indexer:
+		return canonical(left.Index(index))
+	}
+
+	return newError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
+}
+
+// evalHashLiteral evaluates the pairs of hl in order.  A later pair replaces
+// an earlier one with the same key.
+func evalHashLiteral(hl *ast.HashLiteral, env *object.Environment) object.Object {
+	pairs := map[object.HashKey]object.HashPair{}
+
+	for _, p := range hl.Pairs {
+		key := Eval(p.Key, env)
+		if isError(key) {
+			return key
+		}
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(p.Value, env)
+		if isError(value) {
+			return value
+		}
+		pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}
+}
+
+// evalThrowStatement raises an error.  Throwing a caught exception raises its
+// original error again.  Any other value is wrapped in a new error.
+func evalThrowStatement(ts *ast.ThrowStatement, env *object.Environment) object.Object {
+	val := Eval(ts.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if exception, ok := val.(*object.Exception); ok {
+		return exception.Error
+	}
+
+	err := newError(object.THROWN_ERROR, "%s", val.Inspect())
+	err.Value = val
+	return err
+}
+
+// evalTryExpression evaluates the try block.  If it raises an error and there is
+// a catch block, the error is bound to the catch parameter as an exception and
+// the catch block is evaluated instead.  The finally block is always evaluated
+// last, even if the try or catch block returned or raised an error.  The value
+// of the finally block is discarded unless it returns or raises an error itself.
+// Errors that aren't catchable pass through both the catch and finally blocks.
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+
+	if err, ok := result.(*object.Error); ok && err.Catchable() && te.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		bind(catchEnv, te.CatchParameter, &object.Exception{Error: err})
+
+		result = Eval(te.Catch, catchEnv)
+	}
+
+	if te.Finally != nil {
+		finally := Eval(te.Finally, env)
+
+		if finally != nil && !isUncatchable(result) {
+			ft := finally.Type()
+			if ft == object.RETURN_VALUE_OBJ || ft == object.ERROR_OBJ {
+				return finally
+			}
+		}
+	}
+
+	return result
+}
+
+// evalMatchExpression evaluates the subject once and then tries each arm in
+// order.  The first arm whose pattern matches and whose guard, if any, is truthy
+// is evaluated with evalBody.  Identifiers bound by the pattern are only
+// visible inside the guard and body of that arm.  It is an error when no arm
+// matches.
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment, evalBody evalFunc) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		armEnv := object.NewEnclosedEnvironment(env)
+
+		matched, err := matchPattern(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return evalBody(arm.Body, armEnv)
+	}
+
+	return newError(object.MATCH_ERROR, "non-exhaustive match: no arm matches %s", subject.Inspect())
+}
+
+// matchPattern reports whether value matches pattern.  The wildcard "_" matches
+// anything, other identifiers match anything and bind the value in env, and
+// literals match values of the same type that are equal.  Array and hash
+// patterns match arrays and hashes whose elements match their patterns.
+func matchPattern(pattern ast.Expression, value object.Object, env *object.Environment) (bool, *object.Error) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			bind(env, pattern, value)
+		}
+		return true, nil
+
+	case *ast.ArrayPattern:
+		return matchArrayPattern(pattern, value, env)
+
+	case *ast.HashPattern:
+		return matchHashPattern(pattern, value, env)
+	}
+
+	literal := Eval(pattern, env)
+	if err, ok := literal.(*object.Error); ok {
+		return false, err
+	}
+
+	return objectsEqual(literal, value), nil
+}
+
+func matchArrayPattern(pattern *ast.ArrayPattern, value object.Object, env *object.Environment) (bool, *object.Error) {
+	array, ok := value.(*object.Array)
+	if !ok {
+		return false, nil
+	}
+
+	n := len(pattern.Elements)
+	if len(array.Elements) < n || pattern.Rest == nil && len(array.Elements) != n {
+		return false, nil
+	}
+
+	for i, element := range pattern.Elements {
+		if matched, err := matchPattern(element, array.Elements[i], env); !matched {
+			return false, err
+		}
+	}
+
+	if pattern.Rest != nil {
+		rest := &object.Array{Elements: make([]object.Object, len(array.Elements)-n)}
+		copy(rest.Elements, array.Elements[n:])
+		if err := env.Budget().AllocateObject(rest); err != nil {
+			return false, err
+		}
+		return matchPattern(pattern.Rest, rest, env)
+	}
+
+	return true, nil
+}
+
+func matchHashPattern(pattern *ast.HashPattern, value object.Object, env *object.Environment) (bool, *object.Error) {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return false, nil
+	}
+
+	for _, p := range pattern.Pairs {
+		key := Eval(p.Key, env)
+		if err, ok := key.(*object.Error); ok {
+			return false, err
+		}
+
+		pair, ok := hash.Pairs[key.(object.Hashable).HashKey()]
+		if !ok {
+			return false, nil
+		}
+		if matched, err := matchPattern(p.Value, pair.Value, env); !matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// evalSwitchExpression evaluates the body of the first case with a value equal
+// to the subject with evalBody.  Case values are evaluated in order and only
+// until a match is found.  Like an if expression without an alternative, it
+// evaluates to NULL when nothing matches and there is no default branch.
+func evalSwitchExpression(se *ast.SwitchExpression, env *object.Environment, evalBody evalFunc) object.Object {
+	subject := Eval(se.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, c := range se.Cases {
+		for _, v := range c.Values {
+			value := Eval(v, env)
+			if isError(value) {
+				return value
+			}
+
+			if objectsEqual(subject, value) {
+				return evalBody(c.Body, env)
+			}
+		}
+	}
+
+	if se.Default != nil {
+		return evalBody(se.Default, env)
+	}
+
+	return NULL
+}
+
+// objectsEqual reports whether a and b have the same type and are equal
+// according to the "==" operator.
+func objectsEqual(a, b object.Object) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	return evalInfixExpression("==", a, b) == TRUE
+}
+
 // evalPrefixExpression evaluates bang (!) and minus (-) operators.
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
@@ -134,7 +769,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return NULL
+		return newError(object.TYPE_ERROR, "unknown operator: %s%s",
+			operator, right.Type())
 	}
 }
 
@@ -156,15 +792,22 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 // operator.
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	if right.Type() != object.INTEGER_OBJ {
-		return NULL
+		return newError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 	}
 
 	value := right.(*object.Integer).Value
 	return &object.Integer{Value: -value}
 }
 
-// evalInfixExpression evaluates all infix expressions
+// evalInfixExpression evaluates all infix expressions.  A left operand that
+// defines the operator takes precedence.
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
+	if op, ok := left.(object.Operator); ok {
+		if result, ok := op.Operate(operator, right); ok {
+			return canonical(result)
+		}
+	}
+
 	// The order of cases matters.  For "==" and "!=", we are comparing
 	// singleton values of TRUE and FALSE.  This is not done for other objects.
 	// Therefore, we rule out all other operands before comparing boolean
@@ -172,12 +815,18 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
+	case left.Type() != right.Type():
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s",
+			left.Type(), operator, right.Type())
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	default:
-		return NULL
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
 	}
 }
 
@@ -190,6 +839,9 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "+":
 		return &object.Integer{Value: leftVal + rightVal}
@@ -204,6 +856,26 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return NULL
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// evalStringInfixExpression evaluates infix operators on string operands.
+// Strings are compared by value.
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
 	}
 }
@@ -6,18 +6,24 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	env := object.NewEnvironment()
 
-	return Eval(program)
+	return Eval(program, env)
 }
 
 func TestEvalIntegerExpressions(t *testing.T) {
@@ -192,3 +198,1288 @@ if (10 > 1) {
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
+
+func TestLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a;", 5},
+		{"let a = 5 * 5; a;", 25},
+		{"let a = 5; let b = a; b;", 5},
+		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestErrorHandling(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
+		{"5 == true", "type mismatch: INTEGER == BOOLEAN"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"5; true + false; 5", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"10 / 0", "division by zero"},
+		{"10 / (5 - 5) + 1", "division by zero"},
+		{"foobar", "identifier not found: foobar"},
+		{"5 + foobar; 10", "identifier not found: foobar"},
+		{"if (foobar) { 10 }", "identifier not found: foobar"},
+		{"if (true) { if (true) { return foobar; } return 1; }", "identifier not found: foobar"},
+		{"match (3) { 1 => 10, 2 => 20 }", "non-exhaustive match: no arm matches 3"},
+		{"match (3) { n if n > 5 => n }", "non-exhaustive match: no arm matches 3"},
+		{"match (5) { _ if false => 1 }", "non-exhaustive match: no arm matches 5"},
+		{"match (3) { 3 => foobar }", "identifier not found: foobar"},
+		{"5(1)", "not a function: INTEGER"},
+		{"fn(x) { x }(1, 2)", "wrong number of arguments: want=1, got=2"},
+		{"fn(x) { x }()", "wrong number of arguments: want=1, got=0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"match (1) { 1 => 10, _ => 20 }", 10},
+		{"match (2) { 1 => 10, _ => 20 }", 20},
+		{"match (-2) { 2 => 10, -2 => 20 }", 20},
+		{"match (1 < 2) { false => 10, true => 20 }", 20},
+		{"match (1) { true => 10, _ => 20 }", 20},
+		{"match (7) { n => n * 2 }", 14},
+		{"match (7) { n if n > 10 => 1, n if n > 5 => 2, _ => 3 }", 2},
+		{"let n = 1; match (7) { n if n > 5 => n }; n", 1},
+		{"let x = 3; match (x) { 3 => if (x > 1) { x } }", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestSwitchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"switch (1) { case 1: 10; case 2: 20 }", 10},
+		{"switch (2) { case 1: 10; case 2: 20 }", 20},
+		{"switch (3) { case 1, 2: 10; case 3, 4: 20 }", 20},
+		{"switch (5) { case 1: 10; default: 30 }", 30},
+		{"switch (5) { default: 30; case 5: 10 }", 10},
+		{"switch (5) { case 1: 10 }", nil},
+		{"switch (1 < 2) { case 1: 10; case true: 20 }", 20},
+		{"let x = 4; switch (x * 2) { case x + 4: let y = x; y * 3; case 9: 0 }", 12},
+		{"switch (1) { case 1: 10; case foobar: 20 }", 10},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestThrowStatements(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedKind    string
+	}{
+		{"throw 5; 10", "5", object.THROWN_ERROR},
+		{"throw 2 * 3", "6", object.THROWN_ERROR},
+		{"if (true) { throw false; } 10", "false", object.THROWN_ERROR},
+		{"throw 1 / 0", "division by zero", object.ZERO_DIVISION_ERROR},
+		{"try { 1 / 0 } catch (e) { throw e }", "division by zero",
+			object.ZERO_DIVISION_ERROR},
+		{"try { throw 1 } catch (e) { throw 2 }", "2", object.THROWN_ERROR},
+		{"try { throw 1 } finally { 2 }", "1", object.THROWN_ERROR},
+		{"try { 1 } finally { throw 2 }", "2", object.THROWN_ERROR},
+		{"try { x } catch (e) { 1 + true }", "type mismatch: INTEGER + BOOLEAN",
+			object.TYPE_ERROR},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("wrong error kind. expected=%q, got=%q",
+				tt.expectedKind, errObj.Kind)
+		}
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"try { 10 } catch (e) { 20 }", 10},
+		{"try { throw 1; 10 } catch (e) { 20 }", 20},
+		{"try { 1 / 0 } catch (e) { 20 }", 20},
+		{"try { 1 + true } catch (e) { 20 }", 20},
+		{"try { foobar } catch (e) { 20 }", 20},
+		{"try { 10 } finally { 20 }", 10},
+		{"try { throw 1 } catch (e) { 10 } finally { 20 }", 10},
+		{"let x = 1; try { throw 1 } catch (e) { 10 } finally { let x = 2 }; x", 2},
+		{"let x = 1; try { 1 / 0 } catch (e) { let x = 2 }; x", 1},
+		{"try { return 10; } finally { 20 }; 30", 10},
+		{"try { return 10; } finally { return 20; }; 30", 20},
+		{"try { throw 1 } finally { return 20; }", 20},
+		{"try { try { throw 1 } finally { 2 } } catch (e) { 30 }", 30},
+		{"try { try { throw 1 } catch (e) { throw e } } catch (e) { 40 }", 40},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestCaughtExceptions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedKind    string
+		expectedValue   interface{}
+	}{
+		{"try { throw 5 } catch (e) { e }", "5", object.THROWN_ERROR, 5},
+		{"try { 1 / 0 } catch (e) { e }", "division by zero",
+			object.ZERO_DIVISION_ERROR, nil},
+		{"try { -true } catch (e) { e }", "unknown operator: -BOOLEAN",
+			object.TYPE_ERROR, nil},
+		{"try { x } catch (e) { e }", "identifier not found: x",
+			object.NAME_ERROR, nil},
+		{"try { match (1) { 2 => 3 } } catch (e) { let y = e; y }",
+			"non-exhaustive match: no arm matches 1", object.MATCH_ERROR, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		exception, ok := evaluated.(*object.Exception)
+		if !ok {
+			t.Errorf("no exception object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if exception.Error.Message != tt.expectedMessage {
+			t.Errorf("wrong exception message. expected=%q, got=%q",
+				tt.expectedMessage, exception.Error.Message)
+		}
+
+		if exception.Error.Kind != tt.expectedKind {
+			t.Errorf("wrong exception kind. expected=%q, got=%q",
+				tt.expectedKind, exception.Error.Kind)
+		}
+
+		integer, ok := tt.expectedValue.(int)
+		if ok {
+			testIntegerObject(t, exception.Error.Value, int64(integer))
+		} else if exception.Error.Value != nil {
+			t.Errorf("exception value was not nil. got=%+v", exception.Error.Value)
+		}
+	}
+}
+
+func TestFunctionObject(t *testing.T) {
+	input := "fn(x) { x + 2; };"
+
+	evaluated := testEval(input)
+	fn, ok := evaluated.(*object.Function)
+	if !ok {
+		t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if len(fn.Parameters) != 1 {
+		t.Fatalf("function has wrong parameters. Parameters=%+v",
+			fn.Parameters)
+	}
+
+	if fn.Parameters[0].String() != "x" {
+		t.Fatalf("parameter is not 'x'. got=%q", fn.Parameters[0])
+	}
+
+	expectedBody := "(x + 2)"
+
+	if fn.Body.String() != expectedBody {
+		t.Fatalf("body is not %q. got=%q", expectedBody, fn.Body.String())
+	}
+}
+
+func TestFunctionApplication(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let identity = fn(x) { return x; }; identity(5);", 5},
+		{"let double = fn(x) { x * 2; }; double(5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"fn(x) { x; }(5)", 5},
+		{"let f = fn() { return 1; 2 }; f() + 10", 11},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5)", 120},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	input := `
+let newAdder = fn(x) {
+  fn(y) { x + y };
+};
+
+let addTwo = newAdder(2);
+addTwo(2);`
+
+	testIntegerObject(t, testEval(input), 4)
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `
+let inner = fn() { 1 / 0 };
+let outer = fn() { inner() };
+outer();`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := []string{"inner", "outer"}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. want=%q, got=%q", expected, errObj.Stack)
+	}
+
+	for i, frame := range expected {
+		if errObj.Stack[i] != frame {
+			t.Errorf("wrong stack frame %d. want=%q, got=%q", i, frame,
+				errObj.Stack[i])
+		}
+	}
+}
+
+func TestDeferStatements(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		// The value of the call is replaced by the error of a deferred
+		// expression, which shows that it ran.
+		{"let f = fn() { defer 1 / 0; 10 }; f()", "division by zero"},
+		{"let f = fn() { defer 1 / 0; return 10; 20 }; f()", "division by zero"},
+		{"let f = fn() { defer 1 / 0; throw 10 }; f()", "division by zero"},
+		{"let f = fn() { defer 1 / 0; 1 + true }; f()", "division by zero"},
+		{"let f = fn() { if (true) { defer 1 / 0; } 10 }; f()", "division by zero"},
+		// Deferred expressions run last-in-first-out.
+		{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn() { defer fail(1); defer fail(2); 10 };
+f()`, "1"},
+		// Deferred expressions see the environment they were deferred in.
+		{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn(x) { let y = x * 2; defer fail(y); 10 };
+f(4)`, "8"},
+		{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn(x) { try { throw x + 1 } catch (e) { defer fail(e) }; 10 };
+f(4)`, "5"},
+		// Deferred expressions belong to the innermost function call.
+		{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn() { let g = fn() { defer fail(1); 10 }; defer fail(2); g() };
+f()`, "2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)",
+				tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message for %q. expected=%q, got=%q",
+				tt.input, tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestDeferStatementValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn() { defer 5; 10 }; f()", 10},
+		{"let f = fn() { defer 5; return 10; }; f()", 10},
+		{"let f = fn() { defer if (true) { return 5; }; 10 }; f()", 10},
+		{"let f = fn() { defer 1 / 0; 10 }; try { f() } catch (e) { 20 }", 20},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTernaryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"true ? 10 : 20", 10},
+		{"false ? 10 : 20", 20},
+		{"1 < 2 ? 10 : 20", 10},
+		{"1 > 2 ? 10 : 1 > 0 ? 20 : 30", 20},
+		{"1 > 2 ? 10 : 1 < 0 ? 20 : 30", 30},
+		{"let abs = fn(x) { x < 0 ? -x : x }; abs(-5) + abs(5)", 10},
+		// Only the selected branch is evaluated.
+		{"true ? 10 : 1 / 0", 10},
+		{"false ? foobar : 20", 20},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(1000000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)",
+			5000050000},
+		{`
+let even = fn(n) { n == 0 ? 1 : odd(n - 1) };
+let odd = fn(n) { match (n) { 0 => 0, _ => even(n - 1) } };
+even(100001)`, 0},
+		{`
+let count = fn(n) { switch (n) { case 0: 42 default: count(n - 1) } };
+count(100000)`, 42},
+		{`
+let f = fn(n) { if (n > 0) { return f(n - 1); } let x = 7; x };
+f(100000)`, 7},
+		// Calls that aren't in tail position still work.
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)", 100},
+		{"let f = fn(n) { try { n == 0 ? 5 : f(n - 1) } finally { 0 } }; f(10)", 5},
+		// A tail call can call a value that isn't a function.
+		{"let f = fn() { g(1) }; let g = 5; try { f() } catch (e) { 3 }", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCallErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedStack   []string
+	}{
+		{`
+let inner = fn() { 1 / 0 };
+let middle = fn() { inner() };
+let outer = fn() { middle() };
+outer()`, "division by zero", []string{"inner", "middle", "outer"}},
+		{"let f = fn(n) { if (n == 0) { throw 1 } else { f(n - 1) } }; f(2)",
+			"1", []string{"f", "f", "f"}},
+		{"let f = fn() { g() }; let g = 5; f()", "not a function: INTEGER",
+			[]string{"g", "f"}},
+		// Deferred expressions of tail calls run innermost first, and the error
+		// of one replaces the result.
+		{`
+let fail = fn(x) { if (true) { throw x } };
+let g = fn() { defer fail(2); 10 };
+let f = fn() { defer fail(1); g() };
+f()`, "1", []string{"fail", "f"}},
+		{`
+let fail = fn(x) { if (true) { throw x } };
+let g = fn() { defer fail(2); 10 };
+let f = fn() { defer 0; g() };
+f()`, "2", []string{"fail", "g", "f"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input,
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message for %q. expected=%q, got=%q",
+				tt.input, tt.expectedMessage, errObj.Message)
+		}
+
+		if len(errObj.Stack) != len(tt.expectedStack) {
+			t.Errorf("wrong stack for %q. want=%q, got=%q", tt.input,
+				tt.expectedStack, errObj.Stack)
+			continue
+		}
+
+		for i, frame := range tt.expectedStack {
+			if errObj.Stack[i] != frame {
+				t.Errorf("wrong stack for %q. want=%q, got=%q", tt.input,
+					tt.expectedStack, errObj.Stack)
+				break
+			}
+		}
+	}
+}
+
+func TestRecursionLimit(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };"
+
+	tests := []struct {
+		input        string
+		maxDepth     int
+		expected     interface{}
+		expectedKind string
+		stackLength  int
+	}{
+		{input + "f(49)", 50, 49, "", 0},
+		// The call at depth 51 is the one that fails, so the stack has the
+		// names of all the calls.
+		{input + "f(50)", 50, nil, object.RECURSION_ERROR, 51},
+		{input + "try { f(50) } catch (e) { 7 }", 50, 7, "", 0},
+		// Tail calls don't count.
+		{"let g = fn(n) { n == 0 ? 1 : g(n - 1) }; g(100)", 50, 1, "", 0},
+		// The stack leaves out the outermost calls.
+		{input + "f(100000)", object.DefaultMaxCallDepth, nil,
+			object.RECURSION_ERROR, object.MaxErrorStack},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetMaxCallDepth(tt.maxDepth)
+
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		evaluated := Eval(p.ParseProgram(), env)
+
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+			continue
+		}
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input,
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Kind != tt.expectedKind {
+			t.Errorf("wrong error kind. want=%q, got=%q", tt.expectedKind,
+				errObj.Kind)
+		}
+
+		expectedMessage := fmt.Sprintf("maximum recursion depth exceeded (%d)",
+			tt.maxDepth)
+		if errObj.Message != expectedMessage {
+			t.Errorf("wrong error message. want=%q, got=%q", expectedMessage,
+				errObj.Message)
+		}
+
+		if len(errObj.Stack) != tt.stackLength {
+			t.Errorf("wrong stack length. want=%d, got=%d", tt.stackLength,
+				len(errObj.Stack))
+		}
+	}
+}
+
+func TestEvalContextBudget(t *testing.T) {
+	loop := "let loop = fn(n) { loop(n + 1) };"
+	count := "let count = fn(n) { n == 0 ? 0 : count(n - 1) };"
+
+	tests := []struct {
+		input    string
+		maxSteps int64
+		expected interface{}
+	}{
+		{count + "count(9)", 10, 0},
+		{count + "count(10)", 10, object.BUDGET_ERROR},
+		{count + "count(1000)", 0, 0},
+		{loop + "loop(0)", 1000, object.BUDGET_ERROR},
+		{loop + "try { loop(0) } catch (e) { 1 }", 1000, object.BUDGET_ERROR},
+		{loop + "let f = fn() { try { loop(0) } finally { return 1 } }; f()",
+			1000, object.BUDGET_ERROR},
+		{loop + "let f = fn() { defer loop(0); throw 1 }; f()",
+			1000, object.BUDGET_ERROR},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		env := object.NewEnvironment()
+		evaluated := EvalContext(context.Background(), p.ParseProgram(), env,
+			object.Limits{MaxSteps: tt.maxSteps})
+
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+		} else {
+			testInterruptError(t, evaluated, tt.expected.(string))
+		}
+
+		if env.Budget() != nil {
+			t.Errorf("budget of %q not removed from the environment", tt.input)
+		}
+	}
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	env := object.NewEnvironment()
+	evaluated := Eval(parser.New(lexer.New(
+		"let loop = fn(n) { loop(n + 1) };")).ParseProgram(), env)
+	if isError(evaluated) {
+		t.Fatalf("unexpected error: %s", evaluated.Inspect())
+	}
+
+	program := parser.New(lexer.New("loop(0)")).ParseProgram()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	evaluated = EvalContext(ctx, program, env, object.Limits{})
+	testInterruptError(t, evaluated, object.CANCELLED_ERROR)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	evaluated = EvalContext(ctx, program, env, object.Limits{})
+	testInterruptError(t, evaluated, object.TIMEOUT_ERROR)
+}
+
+func testInterruptError(t *testing.T, obj object.Object, expectedKind string) bool {
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		t.Errorf("no error object returned. got=%T(%+v)", obj, obj)
+		return false
+	}
+
+	if errObj.Kind != expectedKind {
+		t.Errorf("wrong error kind. want=%q, got=%q (%s)", expectedKind,
+			errObj.Kind, errObj.Message)
+		return false
+	}
+
+	if errObj.Catchable() {
+		t.Errorf("error %q is catchable", errObj.Kind)
+		return false
+	}
+
+	return true
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"random(1)", 0},
+		{"let r = random(10); r < 10 ? r > -1 : false", true},
+		{"now() > 0", true},
+		{"puts()", nil},
+		{"let random = fn(n) { n }; random(7)", 7},
+		{"random(0)", "argument to `random` must be a positive INTEGER, got 0"},
+		{"random(true)", "argument to `random` must be a positive INTEGER, got true"},
+		{"now(1)", "wrong number of arguments. got=1, want=0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected,
+					errObj.Message)
+			}
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	deny := object.NewSandbox(object.RANDOM_CAPABILITY, object.TIME_CAPABILITY)
+	deny.Deny["now"] = true
+
+	allow := object.NewSandbox()
+	allow.Allow["random"] = true
+
+	pure := &object.Builtin{
+		Name: "pure",
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			return args[0]
+		},
+	}
+
+	tests := []struct {
+		input    string
+		sandbox  *object.Sandbox
+		expected interface{}
+	}{
+		{"random(1)", nil, 0},
+		{"random(1)", object.NewSandbox(object.RANDOM_CAPABILITY), 0},
+		{"random(1)", object.NewSandbox(),
+			"capability denied: random needs the random capability"},
+		{"puts(1)", object.NewSandbox(object.RANDOM_CAPABILITY),
+			"capability denied: puts needs the io capability"},
+		{"random(1)", deny, 0},
+		{"now()", deny, "capability denied: now needs the time capability"},
+		{"random(1)", allow, 0},
+		{"pure(5)", object.NewSandbox(), 5},
+		// Functions are sandboxed by their caller.
+		{"let f = fn() { random(1) }; f()", object.NewSandbox(),
+			"capability denied: random needs the random capability"},
+		{"try { puts(1) } catch (e) { 3 }", object.NewSandbox(), 3},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("pure", pure)
+		env.SetSandbox(tt.sandbox)
+
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		evaluated := Eval(p.ParseProgram(), env)
+
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+			continue
+		}
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input,
+				evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != object.CAPABILITY_ERROR {
+			t.Errorf("wrong error kind. want=%q, got=%q", object.CAPABILITY_ERROR,
+				errObj.Kind)
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. want=%q, got=%q", tt.expected,
+				errObj.Message)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// build creates strings of 1 to n bytes.
+	build := `let build = fn(n, acc) { n == 0 ? len(acc) : build(n - 1, acc + "x") };`
+	// Closures, calls and integers don't count, however many a loop makes.
+	loop := "let loop = fn(n, acc) { n == 0 ? 0 : loop(n - 1, fn() { acc }) };"
+
+	tests := []struct {
+		input    string
+		maxBytes int64
+		expected interface{}
+	}{
+		{`len("ab" + "cd")`, 4, 4},
+		{`len("ab" + "cd")`, 3, nil},
+		{"[1, 2, 3]; 1", 48, 1},
+		{"[1, 2, 3]; 1", 47, nil},
+		{`{"a": 1}; 1`, 64, 1},
+		{`{"a": 1}; 1`, 63, nil},
+		{"len(push([1], 2))", 48, 2},
+		{"len(push([1], 2))", 47, nil},
+		{"match ([1, 2, 3]) { [h, ..t] => len(t) }", 80, 2},
+		{"match ([1, 2, 3]) { [h, ..t] => len(t) }", 79, nil},
+		{build + `build(100, "")`, 5050, 100},
+		{build + `build(100, "")`, 5049, nil},
+		{build + `try { build(100, "") } catch (e) { 3 }`, 5049, 3},
+		{build + `build(100, "")`, 0, 100},
+		{loop + "loop(10000, 0)", 1 << 20, 0},
+		{loop + "loop(10000, 0)", 1, 0},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		evaluated := EvalContext(context.Background(), p.ParseProgram(),
+			object.NewEnvironment(), object.Limits{MaxBytes: tt.maxBytes})
+
+		if expected, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(expected))
+			continue
+		}
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input,
+				evaluated, evaluated)
+			continue
+		}
+		if errObj.Kind != object.MEMORY_ERROR {
+			t.Errorf("wrong error kind. want=%q, got=%q", object.MEMORY_ERROR,
+				errObj.Kind)
+		}
+		expectedMessage := fmt.Sprintf("memory limit of %d bytes exceeded", tt.maxBytes)
+		if errObj.Message != expectedMessage {
+			t.Errorf("wrong error message. want=%q, got=%q", expectedMessage,
+				errObj.Message)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	tests := []struct {
+		input          string
+		stdin          string
+		expected       interface{}
+		expectedOutput string
+	}{
+		{"puts(1, true); puts()", "", nil, "1\ntrue\n"},
+		{"let f = fn(x) { puts(x) }; f(2); f(3)", "", nil, "2\n3\n"},
+		{`puts(1); eputs("two", 3)`, "", nil, "1\ntwo\n3\n"},
+		{"input() + input()", "1\n 2 \n", 3, ""},
+		{"input()", "false\r\n", false, ""},
+		{"input()", "7", 7, ""},
+		{"input()", "", nil, ""},
+		{"input(); input()", "1\n", nil, ""},
+		{"input()", "x\n", `input: not an INTEGER or BOOLEAN: "x"`, ""},
+		{"puts(input() * 2)", "21\n", nil, "42\n"},
+		{"readline()", " a line \r\n", " a line ", ""},
+		{"readline() + readline()", "1\n2", "12", ""},
+		{"readline()", "\n", "", ""},
+		{"readline()", "", nil, ""},
+		{"readline(1)", "", "wrong number of arguments. got=1, want=0", ""},
+		{"puts(len(readline()))", "four\n", nil, "4\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		env := object.NewEnvironment()
+		env.SetStreams(object.NewStreams(strings.NewReader(tt.stdin), &out))
+
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		evaluated := Eval(p.ParseProgram(), env)
+
+		testExpected(t, tt.input, evaluated, tt.expected)
+
+		if out.String() != tt.expectedOutput {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input,
+				tt.expectedOutput, out.String())
+		}
+	}
+
+	// Without streams, there is no input.
+	testNullObject(t, testEval("input()"))
+	testNullObject(t, testEval("readline()"))
+}
+
+func TestHostObjects(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"v.x + v.y", 7},
+		{"v.norm()", 25},
+		{"let f = v.scale; f(2).y", 8},
+		{"v.scale(3).scale(2).x", 18},
+		{"v[0] * 10 + v[1]", 34},
+		{"(v + v).y", 8},
+		{"(v * 2)[1]", 8},
+		{"v.big", true},
+		{"v.big == true", true},
+		{"v == v", true},
+		{"v + 1", "type mismatch: VECTOR + INTEGER"},
+		{"v.z", "property not found: VECTOR.z"},
+		{"v.z()", "property not found: VECTOR.z"},
+		{"v[2]", "index out of range: 2"},
+		{"5[0]", "index operator not supported: INTEGER"},
+		{"5.x", "property not found: INTEGER.x"},
+		{"v.scale()", "wrong number of arguments. got=0, want=1"},
+		{"let sum = fn(it, acc) { it.done() ? acc : sum(it, acc + it.next()) }; sum(iter(v), 0)", 7},
+		{"let it = iter(v); it.next(); it.next(); it.done()", true},
+		{"let it = iter(v); it.next(); it.next(); it.next()", "next: iteration is done"},
+		{"iter(1)", "argument to `iter` not supported, got INTEGER"},
+		{"let f = fn() { v.norm() }; f()", 25},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("v", &vector{x: 3, y: 4})
+
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %q", tt.input, p.Errors())
+		}
+		evaluated := Eval(program, env)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error for %q. got=%T (%+v)", tt.input,
+					evaluated, evaluated)
+			} else if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected,
+					errObj.Message)
+			}
+		}
+	}
+}
+
+// vector is a host object type that implements every optional interface.
+type vector struct {
+	x, y int64
+}
+
+func (v *vector) Type() object.ObjectType { return "VECTOR" }
+
+func (v *vector) Inspect() string { return fmt.Sprintf("vector(%d, %d)", v.x, v.y) }
+
+func (v *vector) GetProperty(name string) (object.Object, bool) {
+	switch name {
+	case "x":
+		return &object.Integer{Value: v.x}, true
+	case "y":
+		return &object.Integer{Value: v.y}, true
+	case "big":
+		return &object.Boolean{Value: v.x*v.x+v.y*v.y > 10}, true
+	}
+	return nil, false
+}
+
+func (v *vector) HasMethod(name string) bool {
+	return name == "norm" || name == "scale"
+}
+
+func (v *vector) CallMethod(name string, args []object.Object) object.Object {
+	if name == "norm" {
+		return &object.Integer{Value: v.x*v.x + v.y*v.y}
+	}
+
+	if len(args) != 1 {
+		return &object.Error{Kind: object.TYPE_ERROR,
+			Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	n := args[0].(*object.Integer).Value
+	return &vector{x: v.x * n, y: v.y * n}
+}
+
+func (v *vector) Index(index object.Object) object.Object {
+	i, ok := index.(*object.Integer)
+	if !ok || i.Value < 0 || i.Value > 1 {
+		return &object.Error{Kind: object.TYPE_ERROR,
+			Message: fmt.Sprintf("index out of range: %s", index.Inspect())}
+	}
+	if i.Value == 0 {
+		return &object.Integer{Value: v.x}
+	}
+	return &object.Integer{Value: v.y}
+}
+
+func (v *vector) Operate(operator string, right object.Object) (object.Object, bool) {
+	switch r := right.(type) {
+	case *vector:
+		if operator == "+" {
+			return &vector{x: v.x + r.x, y: v.y + r.y}, true
+		}
+	case *object.Integer:
+		if operator == "*" {
+			return &vector{x: v.x * r.Value, y: v.y * r.Value}, true
+		}
+	}
+	return nil, false
+}
+
+func (v *vector) Iterate() object.Iterator {
+	return &vectorIterator{v: v}
+}
+
+type vectorIterator struct {
+	v *vector
+	i int
+}
+
+func (it *vectorIterator) Next() (object.Object, bool) {
+	it.i++
+	switch it.i {
+	case 1:
+		return &object.Integer{Value: it.v.x}, true
+	case 2:
+		return &object.Integer{Value: it.v.y}, true
+	}
+	return nil, false
+}
+
+func TestValuelessBlocks(t *testing.T) {
+	tests := []string{
+		"let f = fn() {}; f()",
+		"let f = fn() { let x = 1 }; f()",
+		"let f = fn() { defer 1 }; f()",
+		"let f = fn(n) { if (n > 0) { f(n - 1) } else { let x = n } }; f(3)",
+		"if (true) {}",
+		"if (true) { let x = 1 }",
+		"try { let x = 1 } catch (e) { 1 }",
+		"try { throw 1 } catch (e) { let x = 1 }",
+		"switch (1) { case 1: let x = 1; }",
+		"switch (1) { default: }",
+		"match (1) { _ => fn() {}() }",
+	}
+
+	for _, tt := range tests {
+		testNullObject(t, testEval(tt))
+	}
+}
+
+func TestThrowValueless(t *testing.T) {
+	tests := []string{
+		"let f = fn() {}; throw f()",
+		"let f = fn() { let x = 1 }; throw f()",
+		"let f = fn() { defer 1 }; throw f()",
+		"throw if (true) {}",
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt,
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Kind != object.THROWN_ERROR || errObj.Message != "null" {
+			t.Errorf("wrong error for %q. got=%s: %s", tt, errObj.Kind, errObj.Message)
+		}
+		testNullObject(t, errObj.Value)
+	}
+}
+
+func TestMatchValueless(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn() {}; match (f()) { 1 => 1, _ => 2 }", 2},
+		{"let f = fn() { let x = 1 }; match (f()) { true => 1, n => 2 }", 2},
+		{"let f = fn() { defer 1 }; match (f()) { false => 1, _ => 2 }", 2},
+		{"let f = fn() {}; match (1) { n if f() => 1, _ => 2 }", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	evaluated := testEval("let f = fn() {}; match (f()) { 1 => 1 }")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "non-exhaustive match: no arm matches null" {
+		t.Errorf("wrong result for non-exhaustive match. got=%+v", evaluated)
+	}
+}
+
+func TestSwitchValueless(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn() {}; switch (f()) { case 1: 1 }", nil},
+		{"let f = fn() {}; switch (f()) { case 1: 1; default: 2 }", 2},
+		{"let f = fn() { let x = 1 }; switch (1) { case f(): 1; case 1: 2 }", 2},
+		{"let f = fn() {}; let g = fn() { defer 1 }; switch (f()) { case g(): 3 }", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"Hello World!"`, "Hello World!"},
+		{`"Hello" + " " + "World!"`, "Hello World!"},
+		{`"a\tb\n"`, "a\tb\n"},
+		{`let greet = fn(name) { "Hello, " + name }; greet("you")`, "Hello, you"},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`match ("b") { "a" => 1, "b" => 2, _ => 3 }`, 2},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+		{`"a" + 1`, "type mismatch: STRING + INTEGER"},
+	}
+
+	for _, tt := range tests {
+		testExpected(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestArrayExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2 * 2, 3 + 3][1]", 4},
+		{"let a = [1, 2, 3]; a[0] + a[1] + a[2]", 6},
+		{"let i = 0; [1][i]", 1},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+		{"[][0]", nil},
+		{"[fn(x) { x * 2 }][0](5)", 10},
+		{"[[1, 2], [3]][0][1]", 2},
+		{"let a = [1]; a == a", true},
+		{"[1] == [1]", false},
+		{"[1][true]", "array index must be INTEGER, got BOOLEAN"},
+		{"[1, x]", "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		testExpected(t, tt.input, testEval(tt.input), tt.expected)
+	}
+
+	evaluated := testEval("[1, 2 * 2, true]")
+	array, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+	if array.Inspect() != "[1, 4, true]" {
+		t.Errorf("wrong inspection. got=%q", array.Inspect())
+	}
+}
+
+func TestHashExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+		{`{1: 1}[true]`, nil},
+		{`{"a": 1, "a": 2}["a"]`, 2},
+		{`{"one": 10 - 9, "t" + "wo": 2, 4 - 1: 3}["two"]`, 2},
+		{`{"f": fn(x) { x }}["f"](7)`, 7},
+		{`{"name": "Monkey"}[fn(x) { x }]`, "unusable as hash key: FUNCTION"},
+		{`{[1]: 1}`, "unusable as hash key: ARRAY"},
+		{`{"a": x}`, "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		testExpected(t, tt.input, testEval(tt.input), tt.expected)
+	}
+
+	evaluated := testEval(`{"b": 2, "a": 1, 3: [true]}`)
+	hash, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("object is not Hash. got=%T (%+v)", evaluated, evaluated)
+	}
+	if hash.Inspect() != "{3: [true], a: 1, b: 2}" {
+		t.Errorf("wrong inspection. got=%q", hash.Inspect())
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`len({"a": 1})`, 1},
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, nil},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, nil},
+		{`rest([1, 2, 3])[0]`, 2},
+		{`len(rest([1, 2, 3]))`, 2},
+		{`rest([])`, nil},
+		{`let a = [1]; let b = push(a, 2); len(a) * 10 + len(b)`, 12},
+		{`push([], 1)[0]`, 1},
+		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+		{`push([1])`, "wrong number of arguments. got=1, want=2"},
+		{`let it = iter([1, 2]); it.next() + it.next()`, 3},
+	}
+
+	for _, tt := range tests {
+		testExpected(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+// testExpected checks evaluated against an int, bool, nil for NULL, or string.
+// A string is the value of a String, or the message of an error if evaluated
+// is one.
+func testExpected(t *testing.T, input string, evaluated object.Object, expected interface{}) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, evaluated, int64(expected))
+	case bool:
+		testBooleanObject(t, evaluated, expected)
+	case nil:
+		testNullObject(t, evaluated)
+	case string:
+		switch obj := evaluated.(type) {
+		case *object.String:
+			if obj.Value != expected {
+				t.Errorf("%s: wrong string. want=%q, got=%q", input, expected, obj.Value)
+			}
+		case *object.Error:
+			if obj.Message != expected {
+				t.Errorf("%s: wrong error message. want=%q, got=%q", input, expected,
+					obj.Message)
+			}
+		default:
+			t.Errorf("%s: object is not String or Error. got=%T (%+v)", input,
+				evaluated, evaluated)
+		}
+	}
+}
+
+func TestArrayAndHashPatterns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"match ([]) { [] => 1, _ => 2 }", 1},
+		{"match ([1]) { [] => 1, _ => 2 }", 2},
+		{"match ([1, 2]) { [a, b] => a * 10 + b }", 12},
+		{"match ([1, 2, 3]) { [a, b] => 1, _ => 2 }", 2},
+		{"match ([1, 2, 3]) { [1, x, 3] => x }", 2},
+		{"match ([1, 2, 3]) { [2, x, 3] => x, _ => 0 }", 0},
+		{"match ([1, 2, 3]) { [head, ..tail] => head * 10 + len(tail) }", 12},
+		{"match ([1]) { [head, ..tail] => len(tail) }", 0},
+		{"match ([]) { [head, ..tail] => 1, _ => 2 }", 2},
+		{"match ([1, 2]) { [..all] => len(all) }", 2},
+		{"match ([1, [2, 3]]) { [_, [_, x]] => x }", 3},
+		{"match (1) { [..all] => 1, _ => 2 }", 2},
+		{"match ([1, 2]) { [a, b] if a > b => 1, [a, b] => b }", 2},
+		{`match ({"a": 1, "b": 2}) { {"a": x} => x }`, 1},
+		{`match ({"a": 1}) { {"a": 2} => 1, {"a": n} => n * 10 }`, 10},
+		{`match ({"a": 1}) { {"b": x} => x, _ => 0 }`, 0},
+		{`match ({"a": if (false) { 1 }}) { {"a": x} => 1, _ => 0 }`, 1},
+		{`match ({1: [2], true: {"k": 3}}) { {1: [a], true: {"k": b}} => a + b }`, 5},
+		{`match ({-1: 4}) { {-1: x} => x }`, 4},
+		{`match ({}) { {} => 1 }`, 1},
+		{`match ([1]) { {} => 1, _ => 2 }`, 2},
+		{`match ([{"a": 1}, {"a": 2}]) { [{"a": x}, ..rest] => x + len(rest) }`, 2},
+		{"let x = 5; match ([1]) { [x] => x }; x", 5},
+		{"let f = fn(list) { match (list) { [] => 0, [h, ..t] => h + f(t) } }; f([1, 2, 3, 4])", 10},
+		{"match ([1]) { [] => 1 }", "non-exhaustive match: no arm matches [1]"},
+	}
+
+	for _, tt := range tests {
+		testExpected(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestExceptionProperties(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 / 0 } catch (e) { e.kind }`, "ZeroDivisionError"},
+		{`try { 1 / 0 } catch (e) { e.message }`, "division by zero"},
+		{`try { 1 + true } catch (e) { e.kind == "TypeError" }`, true},
+		{`try { throw 5 } catch (e) { e.kind }`, "Error"},
+		{`try { throw 5 } catch (e) { e.message }`, "5"},
+		{`try { throw 5 } catch (e) { e.value + 1 }`, 6},
+		{`try { throw [1, 2] } catch (e) { e.value[1] }`, 2},
+		{`try { 1 / 0 } catch (e) { e.value }`, nil},
+		{`try { 1 / 0 } catch (e) { len(e.stack) }`, 0},
+		{`
+let inner = fn() { 1 / 0 };
+let outer = fn() { inner() };
+try { outer() } catch (e) { len(e.stack) }`, 2},
+		{`
+let inner = fn() { 1 / 0 };
+let outer = fn() { inner() };
+try { outer() } catch (e) { e.stack[0] + " < " + e.stack[1] }`, "inner < outer"},
+		{`try { throw 1 } catch (e) { e.line }`, "property not found: EXCEPTION.line"},
+	}
+
+	for _, tt := range tests {
+		testExpected(t, tt.input, testEval(tt.input), tt.expected)
+	}
+}
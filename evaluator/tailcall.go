@@ -0,0 +1,186 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// evalFunc evaluates a node.  It is either Eval or evalTail for a position.
+type evalFunc func(node ast.Node, env *object.Environment) object.Object
+
+// position tells which calls in a node are tail calls, whose value is the
+// value of the function call they are in.
+type position int
+
+const (
+	// In return position, only the calls returned by return statements are
+	// tail calls.  The value of the node itself is discarded.
+	returnPosition position = iota
+	// In tail position, the value of the node is also the value of the
+	// function call, so calls that give the node its value are tail calls.
+	tailPosition
+)
+
+// tailCallObj is the type of tail calls, which never leave the evaluator.
+const tailCallObj = "TAIL_CALL"
+
+// tailCall is a call in tail position that was evaluated up to the point of
+// applying the function.  It is returned to applyFunction, which applies it.
+// name is the called expression, added to the stack of errors.
+type tailCall struct {
+	function object.Object
+	args     []object.Object
+	name     string
+}
+
+// Type returns the tail call type.
+func (tc *tailCall) Type() object.ObjectType {
+	return tailCallObj
+}
+
+// Inspect returns the string representation of the tail call type.
+func (tc *tailCall) Inspect() string {
+	return "tail call " + tc.name
+}
+
+// evalTail evaluates node, which is in position pos of a function body, like
+// Eval.  Instead of applying a tail call, it returns it as a *tailCall.  Nodes
+// that have no tail calls in pos are evaluated by Eval.  Tail calls stop the
+// evaluation of blocks like return statements do.
+func evalTail(node ast.Node, env *object.Environment, pos position) object.Object {
+	switch node := node.(type) {
+
+	case *ast.BlockStatement:
+		var result object.Object
+
+		for i, statement := range node.Statements {
+			statementPos := returnPosition
+			if i == len(node.Statements)-1 {
+				statementPos = pos
+			}
+
+			result = evalTail(statement, env, statementPos)
+
+			if result != nil {
+				rt := result.Type()
+				if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+					rt == tailCallObj {
+					return result
+				}
+			}
+		}
+
+		if result == nil {
+			return NULL
+		}
+		return result
+
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env, pos)
+
+	case *ast.ReturnStatement:
+		val := evalTail(node.ReturnValue, env, tailPosition)
+		if isError(val) || (val != nil && val.Type() == tailCallObj) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+
+	case *ast.CallExpression:
+		if pos != tailPosition {
+			break
+		}
+
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+
+		return &tailCall{function: function, args: args, name: node.Function.String()}
+
+	case *ast.IfExpression:
+		return evalIfExpression(node, env, tailEval(pos))
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env, tailEval(pos))
+
+	case *ast.SwitchExpression:
+		return evalSwitchExpression(node, env, tailEval(pos))
+
+	case *ast.TernaryExpression:
+		if pos == tailPosition {
+			return evalTernaryExpression(node, env, tailEval(pos))
+		}
+	}
+
+	return Eval(node, env)
+}
+
+// tailEval returns the evalFunc of the branches of a conditional in position
+// pos.  They are in the same position as the conditional.
+func tailEval(pos position) evalFunc {
+	return func(node ast.Node, env *object.Environment) object.Object {
+		return evalTail(node, env, pos)
+	}
+}
+
+// tailCalls records the function calls made by applyFunction.  Consecutive
+// calls of the same expression without deferred expressions are recorded
+// once, so that a loop written as a tail call uses constant memory.
+type tailCalls []tailCallFrame
+
+type tailCallFrame struct {
+	name  string
+	env   *object.Environment
+	count int
+}
+
+// push records a call of the expression name in env.  env is nil if the
+// function couldn't be applied.  The first call has no name, since its caller
+// adds it to the stack of errors.
+func (calls *tailCalls) push(name string, env *object.Environment) {
+	if env != nil && len(env.Deferred()) == 0 {
+		env = nil
+	}
+
+	n := len(*calls)
+	if n > 1 && env == nil {
+		last := &(*calls)[n-1]
+		if last.env == nil && last.name == name {
+			last.count++
+			return
+		}
+	}
+
+	*calls = append(*calls, tailCallFrame{name: name, env: env, count: 1})
+}
+
+// unwind returns from the recorded calls, innermost first, starting with the
+// value of the last one.  Each call runs its deferred expressions and adds its
+// name to the stack of an error, like nested calls would have.  A call never
+// returns nil: a function without a value returns NULL.
+func (calls tailCalls) unwind(result object.Object) object.Object {
+	for i := len(calls) - 1; i >= 0; i-- {
+		call := calls[i]
+
+		if call.env != nil {
+			result = runDeferred(call.env, result)
+		}
+		result = unwrapReturnValue(result)
+
+		if err, ok := result.(*object.Error); ok && i > 0 {
+			for j := 0; j < call.count; j++ {
+				err.AddToStack(call.name)
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
@@ -54,6 +54,9 @@ func (lex *Lexer) NextToken() token.Token {
 		if isEqualSign(lex.peekChar()) {
 			tok.Type = token.EQ
 			tok.Literal = lex.read(isEqualSign)
+		} else if lex.peekChar() == '>' {
+			lex.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		}
 	case '+':
 		tok = newToken(token.PLUS, lex.ch)
@@ -75,8 +78,18 @@ func (lex *Lexer) NextToken() token.Token {
 		}
 	case ';':
 		tok = newToken(token.SEMICOLON, lex.ch)
+	case '?':
+		tok = newToken(token.QUESTION, lex.ch)
+	case ':':
+		tok = newToken(token.COLON, lex.ch)
 	case ',':
 		tok = newToken(token.COMMA, lex.ch)
+	case '.':
+		tok = newToken(token.DOT, lex.ch)
+		if lex.peekChar() == '.' {
+			lex.readChar()
+			tok = token.Token{Type: token.DOTDOT, Literal: ".."}
+		}
 	case '(':
 		tok = newToken(token.LPAREN, lex.ch)
 	case ')':
@@ -85,6 +98,12 @@ func (lex *Lexer) NextToken() token.Token {
 		tok = newToken(token.LBRACE, lex.ch)
 	case '}':
 		tok = newToken(token.RBRACE, lex.ch)
+	case '[':
+		tok = newToken(token.LBRACKET, lex.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, lex.ch)
+	case '"':
+		tok = lex.readString()
 	default:
 		if isLetter(lex.ch) {
 			tok.Literal = lex.read(isLetter)
@@ -122,6 +141,48 @@ func (lex *Lexer) readWithOffset(boolFunc func(byte) bool, offset int) string {
 	return lex.input[position:lex.position]
 }
 
+// escapes maps the characters that may follow a backslash in a string to the
+// characters they stand for.
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+}
+
+// readString reads a string literal starting at the opening quote and leaves
+// the lexer on the closing quote.  The literal of the token is the value of the
+// string, with escape sequences replaced.  An unterminated string, or a string
+// with an unknown escape sequence, is ILLEGAL.
+func (lex *Lexer) readString() token.Token {
+	position := lex.position
+	var value []byte
+	legal := true
+	for {
+		lex.readChar()
+		switch lex.ch {
+		case '"':
+			if !legal {
+				return token.Token{Type: token.ILLEGAL,
+					Literal: lex.input[position : lex.position+1]}
+			}
+			return token.Token{Type: token.STRING, Literal: string(value)}
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: lex.input[position:]}
+		case '\\':
+			if ch, ok := escapes[lex.peekChar()]; ok {
+				value = append(value, ch)
+				lex.readChar()
+			} else {
+				legal = false
+			}
+		default:
+			value = append(value, lex.ch)
+		}
+	}
+}
+
 func (lex *Lexer) readChar() {
 	// 0 is a byte to indicate eof or no character
 	lex.ch = 0
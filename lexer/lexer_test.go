@@ -145,6 +145,101 @@ if (5 < 10) {
 	tokenTester(input, tests, t)
 }
 
+func TestMatchTokens(t *testing.T) {
+	input := `match (x) { 1 => true, _ => false }`
+
+	tests := []testToken{
+		{token.MATCH, "match"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.ARROW, "=>"},
+		{token.TRUE, "true"},
+		{token.COMMA, ","},
+		{token.IDENT, "_"},
+		{token.ARROW, "=>"},
+		{token.FALSE, "false"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	tokenTester(input, tests, t)
+}
+
+func TestSwitchTokens(t *testing.T) {
+	input := `switch (x) { case 1, 2: x; default: 0 }`
+
+	tests := []testToken{
+		{token.SWITCH, "switch"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.CASE, "case"},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.COLON, ":"},
+		{token.IDENT, "x"},
+		{token.SEMICOLON, ";"},
+		{token.DEFAULT, "default"},
+		{token.COLON, ":"},
+		{token.INT, "0"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	tokenTester(input, tests, t)
+}
+
+func TestExceptionTokens(t *testing.T) {
+	input := `try { throw 1; } catch (e) { e } finally { 2 } defer f()`
+
+	tests := []testToken{
+		{token.TRY, "try"},
+		{token.LBRACE, "{"},
+		{token.THROW, "throw"},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.CATCH, "catch"},
+		{token.LPAREN, "("},
+		{token.IDENT, "e"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "e"},
+		{token.RBRACE, "}"},
+		{token.FINALLY, "finally"},
+		{token.LBRACE, "{"},
+		{token.INT, "2"},
+		{token.RBRACE, "}"},
+		{token.DEFER, "defer"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.EOF, ""},
+	}
+
+	tokenTester(input, tests, t)
+}
+
+func TestTernaryTokens(t *testing.T) {
+	input := `a ? b : c`
+
+	tests := []testToken{
+		{token.IDENT, "a"},
+		{token.QUESTION, "?"},
+		{token.IDENT, "b"},
+		{token.COLON, ":"},
+		{token.IDENT, "c"},
+		{token.EOF, ""},
+	}
+
+	tokenTester(input, tests, t)
+}
+
 // utility functions
 func tokenTester(input string, tests []testToken, t *testing.T) {
 	lexer := New(input)
@@ -163,3 +258,57 @@ func tokenTester(input string, tests []testToken, t *testing.T) {
 		}
 	}
 }
+
+func TestDotAndBrackets(t *testing.T) {
+	input := `v.length(); v[1]`
+
+	tests := []testToken{
+		{token.IDENT, "v"},
+		{token.DOT, "."},
+		{token.IDENT, "length"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "v"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.RBRACKET, "]"},
+		{token.EOF, ""},
+	}
+
+	tokenTester(input, tests, t)
+}
+
+func TestStrings(t *testing.T) {
+	input := `"foo bar" "" "a\"b\\c\n\t" "bad\q" "open`
+
+	tests := []testToken{
+		{token.STRING, "foo bar"},
+		{token.STRING, ""},
+		{token.STRING, "a\"b\\c\n\t"},
+		{token.ILLEGAL, `"bad\q"`},
+		{token.ILLEGAL, `"open`},
+		{token.EOF, ""},
+	}
+
+	tokenTester(input, tests, t)
+}
+
+func TestRestPattern(t *testing.T) {
+	input := `[head, ..tail] a.b`
+
+	tests := []testToken{
+		{token.LBRACKET, "["},
+		{token.IDENT, "head"},
+		{token.COMMA, ","},
+		{token.DOTDOT, ".."},
+		{token.IDENT, "tail"},
+		{token.RBRACKET, "]"},
+		{token.IDENT, "a"},
+		{token.DOT, "."},
+		{token.IDENT, "b"},
+		{token.EOF, ""},
+	}
+
+	tokenTester(input, tests, t)
+}
@@ -1,13 +1,37 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
+	"monkey/vm"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
+const usage = `Usage:
+	monkey                  start the REPL
+	monkey run FILE         run FILE with the virtual machine
+	monkey compile FILE     compile FILE to a .mkc bytecode file
+	monkey disasm FILE      print the bytecode compiled from FILE
+
+FILE is either Monkey source or a .mkc bytecode file, except for compile,
+which takes source.
+`
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -16,6 +40,136 @@ func main() {
 	fmt.Printf("Hi %s!  This is the Monkey language REPL.\n", user.Username)
 	fmt.Printf("Feel free to type in commands.\n")
 	fmt.Printf("To exit, type 'exit' or press ctrl+d or ctrl+c.\n")
+	fmt.Printf("To save or restore the variables, type ':save FILE' or ':restore FILE'.\n")
 
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// runCommand runs the command given by args and returns the exit status.
+func runCommand(args []string, out, errOut io.Writer) int {
+	commands := map[string]func(path string, out io.Writer) error{
+		"run":     run,
+		"compile": compile,
+		"disasm":  disasm,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok || len(args) != 2 {
+		fmt.Fprint(errOut, usage)
+		return 2
+	}
+
+	if err := command(args[1], out); err != nil {
+		fmt.Fprintf(errOut, "monkey: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// load returns the bytecode in the file at path.  Files with the .mkc
+// extension are decoded, anything else is compiled as source.
+func load(path string) (*compiler.Bytecode, error) {
+	if filepath.Ext(path) == ".mkc" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		bytecode, err := compiler.Decode(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return bytecode, nil
+	}
+
+	input, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(string(input)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: parser errors:\n\t%s", path,
+			strings.Join(p.Errors(), "\n\t"))
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return comp.Bytecode(), nil
+}
+
+// run executes the program in the file at path and writes its result.
+func run(path string, out io.Writer) error {
+	bytecode, err := load(path)
+	if err != nil {
+		return err
+	}
+
+	machine := vm.New(bytecode)
+	streams := object.NewStreams(os.Stdin, out)
+	streams.Err = os.Stderr
+	machine.SetStreams(streams)
+	if err := machine.Run(); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	if result := machine.Result(); result != nil {
+		fmt.Fprintln(out, result.Inspect())
+	}
+	return nil
+}
+
+// compile writes the bytecode compiled from the source file at path next to
+// it, replacing its extension with .mkc.
+func compile(path string, out io.Writer) error {
+	if filepath.Ext(path) == ".mkc" {
+		return fmt.Errorf("%s: already compiled", path)
+	}
+
+	bytecode, err := load(path)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := compiler.Encode(&buf, bytecode); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	target := strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	return ioutil.WriteFile(target, buf.Bytes(), 0644)
+}
+
+// disasm writes the disassembled instructions of the main program in the file
+// at path and of every compiled function constant.
+func disasm(path string, out io.Writer) error {
+	bytecode, err := load(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "== main ==\n%s", bytecode.Instructions)
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+
+		fmt.Fprintf(out, "\n== constant %d: fn %s (parameters=%d, locals=%d, free=%d) ==\n%s",
+			i, name, fn.NumParameters, fn.NumLocals, len(fn.FreeNames),
+			fn.Instructions)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"monkey/object"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentInterpreters runs many interpreters at the same time.  Run it
+// with the race detector: go test -race ./monkey
+func TestConcurrentInterpreters(t *testing.T) {
+	const interpreters = 16
+	const rounds = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, interpreters)
+
+	for n := 0; n < interpreters; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			if err := runIsolated(n, rounds); err != nil {
+				errs <- fmt.Errorf("interpreter %d: %s", n, err)
+			}
+		}(n)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// runIsolated runs scripts in an interpreter of its own and checks that it
+// only sees its own state.
+func runIsolated(n, rounds int) error {
+	interp := New()
+	interp.MaxSteps = 100000
+
+	calls := 0
+	if err := interp.Register("count", func() int { calls++; return calls }); err != nil {
+		return err
+	}
+	interp.Set("id", &object.Integer{Value: int64(n)})
+
+	_, err := interp.Run(`
+	let fib = fn(x) { x < 2 ? x : fib(x - 1) + fib(x - 2) };
+	let adder = fn(a) { fn(b) { a + b } };
+	let addId = adder(id);
+	let check = fn(x) { if (x == id) { true } else { throw x } };
+	`)
+	if err != nil {
+		return err
+	}
+
+	for round := 1; round <= rounds; round++ {
+		result, err := interp.Run("addId(fib(15)) + random(1) + count() * 0")
+		if err != nil {
+			return err
+		}
+		var value int
+		if err := object.ToGo(result, &value); err != nil {
+			return err
+		}
+		if value != 610+n {
+			return fmt.Errorf("wrong value. want=%d, got=%d", 610+n, value)
+		}
+
+		result, err = interp.Call("check", n)
+		if err != nil {
+			return err
+		}
+		if b, ok := result.(*object.Boolean); !ok || !b.Value {
+			return fmt.Errorf("check failed. got=%v", result)
+		}
+
+		_, err = interp.Run(fmt.Sprintf("check(%d)", n+1))
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Err.Kind != object.THROWN_ERROR {
+			return fmt.Errorf("wrong error. got=%v", err)
+		}
+	}
+
+	if calls != rounds {
+		return fmt.Errorf("wrong number of host calls. want=%d, got=%d", rounds, calls)
+	}
+
+	// Each interpreter has its own budget, sandbox and deadline.
+	interp.Sandbox = object.NewSandbox()
+	if _, err := interp.Run("random(1)"); err == nil {
+		return fmt.Errorf("sandbox not applied")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(n)*time.Millisecond)
+	defer cancel()
+	interp.MaxSteps = 0
+	_, err = interp.RunContext(ctx, "let loop = fn() { loop() }; loop()")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Err.Kind != object.TIMEOUT_ERROR {
+		return fmt.Errorf("wrong error for timeout. got=%v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+/*
+Package monkey embeds the Monkey interpreter in Go programs.
+
+To run a script:
+
+	interp := New()
+	interp.Set("limit", &object.Integer{Value: 10})
+	result, err := interp.Run("let double = limit * 2; double")
+	double, _ := interp.Get("double")
+
+Go functions are registered as builtins with Register, and functions defined
+by scripts are called from Go with Call:
+
+	interp.Run("let handler = fn(n) { n * 2 }")
+	result, err := interp.Call("handler", 21)
+
+Go types that implement object.Object can be given to scripts with Set.  The
+optional interfaces in package object, like object.PropertyGetter and
+object.MethodCaller, let scripts use them with obj.name, obj.name(args),
+obj[index], infix operators and the iter builtin.
+
+The scripts run by an Interpreter share its global variables, like the lines
+typed in the REPL.  Parser errors and runtime errors are returned as Go errors
+of type *ParseError and *RuntimeError.
+
+# Concurrency
+
+An Interpreter must not be used by several goroutines at once, but separate
+Interpreters can run in separate goroutines.  They share no mutable state: each
+has its own environment, budget and sandbox, and the values shared by all of
+them, like the boolean and null singletons of the evaluator and the builtins
+in object.Builtins, are never modified.  Objects returned by one Interpreter
+shouldn't be passed to another one running at the same time, since functions
+refer to the environment they were created in.  Go functions registered with
+Register run in the goroutine of the script that calls them.
+*/
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/snapshot"
+	"os"
+	"strings"
+)
+
+// Interpreter runs Monkey scripts in an environment of its own.
+//
+// Stdin is the reader for the input of scripts, Stdout the writer for the
+// output of puts and Stderr the writer for the output of eputs.  MaxSteps is
+// the maximum number of function calls a single script may make and MaxBytes
+// the approximate number of bytes of the strings, arrays and hashes it may
+// create, with zero meaning no maximum.
+//
+// Sandbox decides which builtins scripts may call.  It is nil by default, and
+// a nil Sandbox permits every builtin, including the ones with side effects,
+// so the default is only meant for trusted scripts.  Untrusted scripts should
+// run with object.NewSandbox(), the restricted profile that denies every
+// builtin with side effects, to which capabilities are given as arguments.
+type Interpreter struct {
+	Stdin    io.Reader
+	Stdout   io.Writer
+	Stderr   io.Writer
+	MaxSteps int64
+	MaxBytes int64
+	Sandbox  *object.Sandbox
+
+	env *object.Environment
+
+	// streams buffer the input read from stdin, which was Stdin when they
+	// were created.
+	streams *object.Streams
+	stdin   io.Reader
+}
+
+// New returns a reference to a new Interpreter without global variables that
+// reads from os.Stdin and writes to os.Stdout and os.Stderr.
+func New() *Interpreter {
+	return &Interpreter{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		env:    object.NewEnvironment(),
+	}
+}
+
+// ParseError is returned for a script that can't be parsed.  Name is the file
+// name of the script, if it was read from a file.
+type ParseError struct {
+	Name   string
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	msg := "parser errors:\n\t" + strings.Join(e.Errors, "\n\t")
+	if e.Name != "" {
+		return e.Name + ": " + msg
+	}
+	return msg
+}
+
+// RuntimeError is returned for a script that raised an error it didn't catch.
+// Name is the file name of the script, if it was read from a file, or the name
+// of the function called by Call.
+type RuntimeError struct {
+	Name string
+	Err  *object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Err.Kind, e.Err.Message)
+	if e.Name != "" {
+		return e.Name + ": " + msg
+	}
+	return msg
+}
+
+// Run runs source and returns its value.
+func (i *Interpreter) Run(source string) (object.Object, error) {
+	return i.run(context.Background(), "", source)
+}
+
+// RunContext runs source like Run, but stops it once ctx is done.
+func (i *Interpreter) RunContext(ctx context.Context,
+	source string) (object.Object, error) {
+	return i.run(ctx, "", source)
+}
+
+// RunFile runs the script in the file at path and returns its value.
+func (i *Interpreter) RunFile(path string) (object.Object, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return i.run(context.Background(), path, string(source))
+}
+
+func (i *Interpreter) run(ctx context.Context,
+	name, source string) (object.Object, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Name: name, Errors: p.Errors()}
+	}
+
+	i.prepare()
+	result := evaluator.EvalContext(ctx, program, i.env, i.limits())
+	if err, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Name: name, Err: err}
+	}
+
+	return result, nil
+}
+
+// Call calls the function bound to the global variable name with args, which
+// are converted with object.FromGo, and returns its value.  Use object.ToGo to
+// convert the value back.
+func (i *Interpreter) Call(name string,
+	args ...interface{}) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext calls a function like Call, but stops it once ctx is done.
+func (i *Interpreter) CallContext(ctx context.Context, name string,
+	args ...interface{}) (object.Object, error) {
+	fn, ok := i.env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("call %s: no such variable", name)
+	}
+
+	objects := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := object.FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("call %s: argument %d: %s", name, n+1, err)
+		}
+		objects[n] = obj
+	}
+
+	i.prepare()
+	previous := i.env.Budget()
+	i.env.SetBudget(object.NewBudget(ctx, i.limits()))
+	defer i.env.SetBudget(previous)
+
+	result := evaluator.Apply(fn, objects, i.env)
+	if err, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Name: name, Err: err}
+	}
+
+	if result == nil {
+		return evaluator.NULL, nil
+	}
+	return result, nil
+}
+
+// prepare applies the configuration of the interpreter to its environment.
+func (i *Interpreter) prepare() {
+	if i.streams == nil || i.stdin != i.Stdin {
+		i.streams = object.NewStreams(i.Stdin, i.Stdout)
+		i.stdin = i.Stdin
+	}
+	i.streams.Out = i.Stdout
+	i.streams.Err = i.Stderr
+
+	i.env.SetSandbox(i.Sandbox)
+	i.env.SetStreams(i.streams)
+}
+
+func (i *Interpreter) limits() object.Limits {
+	return object.Limits{MaxSteps: i.MaxSteps, MaxBytes: i.MaxBytes}
+}
+
+// Register binds a builtin that calls the Go function fn to the global variable
+// name.  See object.NewBuiltin for the functions that can be registered.  The
+// builtin has the host capability.
+func (i *Interpreter) Register(name string, fn interface{}) error {
+	builtin, err := object.NewBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+	builtin.Capability = object.HOST_CAPABILITY
+
+	i.env.Set(name, builtin)
+	return nil
+}
+
+// Get returns the value of the global variable name.
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.env.Get(name)
+}
+
+// Set binds val to the global variable name.
+func (i *Interpreter) Set(name string, val object.Object) {
+	i.env.Set(name, val)
+}
+
+// Save writes the global variables of the interpreter to w in the format of
+// package snapshot.  It fails if a variable can't be saved, like a builtin
+// registered with Register.
+func (i *Interpreter) Save(w io.Writer) error {
+	return snapshot.Save(w, i.env)
+}
+
+// Restore reads global variables written by Save from r and binds them in the
+// interpreter, replacing variables with the same names.
+func (i *Interpreter) Restore(r io.Reader) error {
+	return snapshot.Restore(r, i.env)
+}
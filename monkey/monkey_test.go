@@ -0,0 +1,454 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"monkey/object"
+	"monkey/snapshot"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"5", 5},
+		{"let add = fn(a, b) { a + b }; add(2, 3)", 5},
+		{"limit * 2", 20},
+		{"add(limit, 1)", 11},
+	}
+
+	interp := New()
+	interp.Set("limit", &object.Integer{Value: 10})
+
+	for _, tt := range tests {
+		result, err := interp.Run(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		testIntegerObject(t, result, tt.expected)
+	}
+
+	add, ok := interp.Get("add")
+	if !ok {
+		t.Fatalf("global variable add not found")
+	}
+	if add.Type() != object.FUNCTION_OBJ {
+		t.Errorf("add is not a function. got=%s", add.Type())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	interp := New()
+
+	_, err := interp.Run("let = 5;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("not a parse error. got=%T(%v)", err, err)
+	}
+	if len(parseErr.Errors) == 0 {
+		t.Errorf("parse error without messages")
+	}
+
+	_, err = interp.Run("let f = fn() { throw 1 }; f()")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("not a runtime error. got=%T(%v)", err, err)
+	}
+	if runtimeErr.Err.Kind != object.THROWN_ERROR {
+		t.Errorf("wrong error kind. want=%q, got=%q", object.THROWN_ERROR,
+			runtimeErr.Err.Kind)
+	}
+	if runtimeErr.Error() != "Error: 1" {
+		t.Errorf("wrong message. got=%q", runtimeErr.Error())
+	}
+
+	interp.MaxSteps = 100
+	_, err = interp.Run("let loop = fn() { loop() }; loop()")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Err.Kind != object.BUDGET_ERROR {
+		t.Errorf("step budget not enforced. got=%v", err)
+	}
+
+	interp.MaxBytes = 1000
+	_, err = interp.Run(`let build = fn(acc) { build(acc + "x") }; build("")`)
+	if !errors.As(err, &runtimeErr) || runtimeErr.Err.Kind != object.MEMORY_ERROR {
+		t.Errorf("memory limit not enforced. got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = interp.RunContext(ctx, "loop()")
+	if !errors.As(err, &runtimeErr) || runtimeErr.Err.Kind != object.CANCELLED_ERROR {
+		t.Errorf("cancellation not enforced. got=%v", err)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "script.mk")
+	if err := ioutil.WriteFile(path, []byte("let x = 6; x * 7"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	interp := New()
+	result, err := interp.RunFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testIntegerObject(t, result, 42)
+
+	bad := filepath.Join(dir, "bad.mk")
+	if err := ioutil.WriteFile(bad, []byte("let = 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = interp.RunFile(bad)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Name != bad {
+		t.Errorf("wrong error for %s. got=%v", bad, err)
+	}
+
+	if _, err := interp.RunFile(filepath.Join(dir, "missing.mk")); !os.IsNotExist(err) {
+		t.Errorf("wrong error for a missing file. got=%v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	interp := New()
+
+	register := func(name string, fn interface{}) {
+		if err := interp.Register(name, fn); err != nil {
+			t.Fatalf("Register(%q) failed: %s", name, err)
+		}
+	}
+
+	register("add", func(a, b int) int { return a + b })
+	register("isSmall", func(n int8) bool { return n < 10 })
+	register("not", func(b bool) bool { return !b })
+	register("sum", func(ns ...int64) int64 {
+		var total int64
+		for _, n := range ns {
+			total += n
+		}
+		return total
+	})
+	register("check", func(n uint) (uint, error) {
+		if n == 0 {
+			return 0, errors.New("zero")
+		}
+		return n, nil
+	})
+	register("identity", func(v interface{}) interface{} { return v })
+	register("nothing", func() {})
+	register("apply", func(f object.Object) object.Object { return f })
+	register("greet", func(name string) string { return "hello " + name })
+	register("hasPrefix", func(n int64, prefix string) (bool, error) {
+		if prefix == "" {
+			return false, errors.New("empty prefix")
+		}
+		return strings.HasPrefix(strconv.FormatInt(n, 10), prefix), nil
+	})
+	register("explode", func() { panic("boom") })
+	register("total", func(ns []int) int {
+		total := 0
+		for _, n := range ns {
+			total += n
+		}
+		return total
+	})
+	register("sumPoint", func(p struct {
+		X int `monkey:"x"`
+		Y int `monkey:"y"`
+	}) int {
+		return p.X + p.Y
+	})
+	register("origin", func() map[string]int { return map[string]int{"x": 0, "y": 0} })
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"add(2, 3)", 5},
+		{"add(add(1, 2), 3) * 2", 12},
+		{"isSmall(3)", true},
+		{"isSmall(3) == true", true},
+		{"not(isSmall(3)) ? 1 : 2", 2},
+		{"sum()", 0},
+		{"sum(1, 2, 3)", 6},
+		{"check(4)", 4},
+		{"identity(7)", 7},
+		{"identity(false) == false", true},
+		{"nothing()", nil},
+		{"apply(fn(x) { x })(9)", 9},
+		{`greet("you") == "hello you"`, true},
+		{`identity("x") == "x"`, true},
+		{`hasPrefix(123, "12")`, true},
+		{`hasPrefix(123, "2")`, false},
+		{`hasPrefix(123, "")`, "HostError: hasPrefix: empty prefix"},
+		{`hasPrefix("123", "1")`, "TypeError: hasPrefix: argument 1: cannot use STRING as int64"},
+		{"explode()", "HostError: explode: panic: boom"},
+		{"try { explode() } catch (e) { 1 }", 1},
+		{"total([1, 2, 3])", 6},
+		{`sumPoint({"x": 3, "y": 4})`, 7},
+		{`sumPoint(origin()) + len(origin())`, 2},
+		{"total([1, true])", "TypeError: total: argument 1: index 1: cannot use BOOLEAN as int"},
+		{"try { check(0) } catch (e) { 1 }", 1},
+		{"add(1)", "TypeError: add: wrong number of arguments: want=2, got=1"},
+		{"add(1, true)", "TypeError: add: argument 2: cannot use BOOLEAN as int"},
+		{"isSmall(1000)", "TypeError: isSmall: argument 1: 1000 overflows int8"},
+		{"check(-1)", "TypeError: check: argument 1: -1 overflows uint"},
+		{"check(0)", "HostError: check: zero"},
+	}
+
+	for _, tt := range tests {
+		result, err := interp.Run(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+				continue
+			}
+			testIntegerObject(t, result, int64(expected))
+		case bool:
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+				continue
+			}
+			if b, ok := result.(*object.Boolean); !ok || b.Value != expected {
+				t.Errorf("%q: wrong result. want=%t, got=%v", tt.input, expected, result)
+			}
+		case nil:
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+				continue
+			}
+			if _, ok := result.(*object.Null); !ok {
+				t.Errorf("%q: result is not Null. got=%T", tt.input, result)
+			}
+		case string:
+			if err == nil || err.Error() != expected {
+				t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, expected, err)
+			}
+		}
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	tests := []struct {
+		fn       interface{}
+		expected string
+	}{
+		{5, "builtin f: not a function: int"},
+		{func(f float64) {}, "builtin f: unsupported parameter type float64"},
+		{func() (int, int) { return 0, 0 },
+			"builtin f: unsupported result types of func() (int, int)"},
+		{func() float64 { return 0 },
+			"builtin f: unsupported result types of func() float64"},
+	}
+
+	for _, tt := range tests {
+		err := New().Register("f", tt.fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := New()
+	interp.MaxSteps = 1000
+	if err := interp.Register("triple", func(n int) int { return 3 * n }); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := interp.Run(`
+	let handler = fn(n, double) { double ? n * 2 : n };
+	let empty = fn() {};
+	let fail = fn(n) { throw n };
+	let loop = fn() { loop() };
+	let five = 5;
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result, err := interp.Call("handler", 21, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var n int
+	if err := object.ToGo(result, &n); err != nil || n != 42 {
+		t.Errorf("wrong result. got=%d (%v)", n, err)
+	}
+
+	result, err = interp.Call("triple", int8(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testIntegerObject(t, result, 12)
+
+	result, err = interp.Call("empty")
+	if _, ok := result.(*object.Null); !ok || err != nil {
+		t.Errorf("wrong result of empty. got=%v (%v)", result, err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected string
+	}{
+		{"missing", nil, "call missing: no such variable"},
+		{"handler", []interface{}{1.5, true},
+			"call handler: argument 1: cannot convert float64 to an object"},
+		{"handler", []interface{}{"text", true},
+			"handler: TypeError: type mismatch: STRING * INTEGER"},
+		{"handler", []interface{}{1},
+			"handler: TypeError: wrong number of arguments: want=2, got=1"},
+		{"five", nil, "five: TypeError: not a function: INTEGER"},
+		{"fail", []interface{}{7}, "fail: Error: 7"},
+		{"loop", nil, "loop: BudgetExceededError: step budget of 1000 exceeded"},
+	}
+
+	for _, tt := range tests {
+		_, err := interp.Call(tt.name, tt.args...)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+
+	if interp.env.Budget() != nil {
+		t.Errorf("budget not removed from the environment")
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	interp := New()
+	if err := interp.Register("double", func(n int) int { return 2 * n }); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sandbox  *object.Sandbox
+		input    string
+		expected string
+	}{
+		{nil, "double(random(1))", ""},
+		{object.NewSandbox(), "double(1)",
+			"CapabilityError: capability denied: double needs the host capability"},
+		{object.NewSandbox(object.HOST_CAPABILITY), "double(1)", ""},
+		{object.NewSandbox(object.HOST_CAPABILITY), "random(1)",
+			"CapabilityError: capability denied: random needs the random capability"},
+	}
+
+	for _, tt := range tests {
+		interp.Sandbox = tt.sandbox
+
+		_, err := interp.Run(tt.input)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	interp.Sandbox = object.NewSandbox()
+	if _, err := interp.Call("double", 1); err == nil {
+		t.Errorf("sandbox not applied to Call")
+	}
+}
+
+func TestStreams(t *testing.T) {
+	var out, errOut bytes.Buffer
+	interp := New()
+	interp.Stdin = strings.NewReader("1\n2\n3\n")
+	interp.Stdout = &out
+	interp.Stderr = &errOut
+
+	for _, input := range []string{"puts(input())", "puts(input() + input())"} {
+		if _, err := interp.Run(input); err != nil {
+			t.Fatalf("%q: unexpected error: %s", input, err)
+		}
+	}
+
+	interp.Stdin = strings.NewReader("4\n")
+	if _, err := interp.Run("let echo = fn() { puts(input()) }"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := interp.Call("echo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := interp.Run(`eputs("warning", 1); puts(2)`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if out.String() != "1\n5\n4\n2\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+	if errOut.String() != "warning\n1\n" {
+		t.Errorf("wrong error output. got=%q", errOut.String())
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	interp := New()
+	if _, err := interp.Run("let base = 40; let add = fn(n) { fn(x) { base + n + x } }(1);"); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := interp.Save(&buf); err != nil {
+		t.Fatalf("save error: %s", err)
+	}
+
+	restored := New()
+	restored.Set("base", &object.Integer{Value: 0})
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("restore error: %s", err)
+	}
+
+	result, err := restored.Call("add", 1)
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	testIntegerObject(t, result, 42)
+
+	if err := interp.Register("double", func(n int) int { return 2 * n }); err != nil {
+		t.Fatalf("register error: %s", err)
+	}
+	err = interp.Save(&buf)
+	if !errors.Is(err, snapshot.ErrNotSerializable) {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits are the limits of an evaluation.  MaxSteps is the maximum number of
+// steps and MaxBytes the maximum number of bytes allocated for strings, arrays
+// and hashes.  Zero or less means no maximum.
+type Limits struct {
+	MaxSteps int64
+	MaxBytes int64
+}
+
+// Budget stops an evaluation once its context is done or it has taken more
+// than its maximum number of steps.  The evaluator takes a step for every
+// function call, including tail calls, which is where a program can loop.
+//
+// A Budget also counts the approximate number of bytes allocated for the
+// strings, arrays and hashes the evaluation creates, which are the values
+// whose size a program controls.  Other values and the environments of
+// function calls have a fixed size, and the call depth and steps limit how
+// many of them a program makes.  The Budget counts every allocation, not the
+// memory in use, since it can't tell when a value is no longer referenced.
+type Budget struct {
+	ctx     context.Context
+	done    <-chan struct{}
+	limits  Limits
+	steps   int64
+	bytes   int64
+	stopped *Error
+}
+
+// NewBudget returns a reference to a new Budget with the given limits.  ctx
+// may be nil.
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	b := &Budget{ctx: ctx, limits: limits}
+	if ctx != nil {
+		b.done = ctx.Done()
+	}
+	return b
+}
+
+// Steps returns the number of steps taken.
+func (b *Budget) Steps() int64 {
+	return b.steps
+}
+
+// Bytes returns the number of bytes allocated.
+func (b *Budget) Bytes() int64 {
+	return b.bytes
+}
+
+// Step takes a step.  It returns an error once the evaluation has to stop,
+// and keeps returning one for every step after that.  A nil Budget is
+// unlimited.
+func (b *Budget) Step() *Error {
+	if b == nil {
+		return nil
+	}
+
+	if b.stopped == nil {
+		b.steps++
+
+		select {
+		case <-b.done:
+			if b.ctx.Err() == context.DeadlineExceeded {
+				b.stopped = &Error{Kind: TIMEOUT_ERROR, Message: "evaluation timed out"}
+			} else {
+				b.stopped = &Error{Kind: CANCELLED_ERROR, Message: "evaluation cancelled"}
+			}
+		default:
+			if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+				b.stopped = &Error{Kind: BUDGET_ERROR,
+					Message: fmt.Sprintf("step budget of %d exceeded", b.limits.MaxSteps)}
+			}
+		}
+
+		if b.stopped == nil {
+			return nil
+		}
+	}
+
+	// Each error gets a stack of its own.
+	return &Error{Kind: b.stopped.Kind, Message: b.stopped.Message}
+}
+
+// Approximate sizes in bytes of the parts of arrays and hashes.  A string
+// counts one byte per byte.
+const (
+	elementSize = 16
+	pairSize    = 64
+)
+
+// AllocateObject counts the bytes of obj as allocated, if it is a string, an
+// array or a hash, like Allocate.  The elements of an array and the pairs of a
+// hash count, but not the values they hold, which were counted when they were
+// created.
+func (b *Budget) AllocateObject(obj Object) *Error {
+	switch obj := obj.(type) {
+	case *String:
+		return b.Allocate(int64(len(obj.Value)))
+	case *Array:
+		return b.Allocate(elementSize * int64(len(obj.Elements)))
+	case *Hash:
+		return b.Allocate(pairSize * int64(len(obj.Pairs)))
+	}
+	return nil
+}
+
+// Allocate counts n bytes as allocated.  It returns an error if that exceeds
+// the maximum, in which case the bytes aren't counted.  Unlike the errors
+// returned by Step, it can be caught, and allocations that fit still succeed
+// afterwards.
+func (b *Budget) Allocate(n int64) *Error {
+	if b == nil {
+		return nil
+	}
+
+	if b.limits.MaxBytes > 0 && b.bytes+n > b.limits.MaxBytes {
+		return &Error{Kind: MEMORY_ERROR,
+			Message: fmt.Sprintf("memory limit of %d bytes exceeded", b.limits.MaxBytes)}
+	}
+
+	b.bytes += n
+	return nil
+}
@@ -0,0 +1,286 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Capabilities of builtins.  A builtin without a capability has no side
+// effects.
+const (
+	IO_CAPABILITY     = "io"
+	TIME_CAPABILITY   = "time"
+	RANDOM_CAPABILITY = "random"
+	HOST_CAPABILITY   = "host"
+)
+
+// Builtins holds the builtins that every program can refer to by name, unless
+// a variable with the same name hides them.  They are shared by every evaluation,
+// including concurrent ones, so they must never be modified.
+var Builtins = []*Builtin{
+	{
+		Name:       "puts",
+		Capability: IO_CAPABILITY,
+		Fn: func(env *Environment, args ...Object) Object {
+			var out io.Writer = os.Stdout
+			if streams := env.Streams(); streams != nil {
+				out = streams.Out
+			}
+
+			for _, arg := range args {
+				fmt.Fprintln(out, arg.Inspect())
+			}
+			return nil
+		},
+	},
+	{
+		// eputs is puts for error messages.
+		Name:       "eputs",
+		Capability: IO_CAPABILITY,
+		Fn: func(env *Environment, args ...Object) Object {
+			var out io.Writer = os.Stderr
+			if streams := env.Streams(); streams != nil {
+				out = streams.Err
+			}
+
+			for _, arg := range args {
+				fmt.Fprintln(out, arg.Inspect())
+			}
+			return nil
+		},
+	},
+	{
+		// input reads a line and returns the integer or boolean on it, or null
+		// at the end of the input.
+		Name:       "input",
+		Capability: IO_CAPABILITY,
+		Fn: func(env *Environment, args ...Object) Object {
+			if len(args) != 0 {
+				return newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=0",
+					len(args))
+			}
+
+			streams := env.Streams()
+			if streams == nil {
+				return nil
+			}
+
+			line, err := streams.ReadLine()
+			if err == io.EOF && line == "" {
+				return nil
+			}
+			if err != nil && err != io.EOF {
+				return newError(HOST_ERROR, "input: %s", err)
+			}
+
+			line = strings.TrimSpace(line)
+			switch line {
+			case "true":
+				return &Boolean{Value: true}
+			case "false":
+				return &Boolean{Value: false}
+			}
+
+			n, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return newError(TYPE_ERROR, "input: not an INTEGER or BOOLEAN: %q", line)
+			}
+			return &Integer{Value: n}
+		},
+	},
+	{
+		// readline reads a line and returns it as a string without the line
+		// ending, or null at the end of the input.
+		Name:       "readline",
+		Capability: IO_CAPABILITY,
+		Fn: func(env *Environment, args ...Object) Object {
+			if len(args) != 0 {
+				return newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=0",
+					len(args))
+			}
+
+			streams := env.Streams()
+			if streams == nil {
+				return nil
+			}
+
+			line, err := streams.ReadLine()
+			if err == io.EOF && line == "" {
+				return nil
+			}
+			if err != nil && err != io.EOF {
+				return newError(HOST_ERROR, "readline: %s", err)
+			}
+
+			str := &String{Value: line}
+			if err := env.Budget().AllocateObject(str); err != nil {
+				return err
+			}
+			return str
+		},
+	},
+	{
+		Name:       "now",
+		Capability: TIME_CAPABILITY,
+		Fn: func(env *Environment, args ...Object) Object {
+			if len(args) != 0 {
+				return newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=0",
+					len(args))
+			}
+			return &Integer{Value: time.Now().UnixNano() / int64(time.Millisecond)}
+		},
+	},
+	{
+		Name:       "random",
+		Capability: RANDOM_CAPABILITY,
+		Fn: func(env *Environment, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			n, ok := args[0].(*Integer)
+			if !ok || n.Value <= 0 {
+				return newError(TYPE_ERROR, "argument to `random` must be a positive INTEGER, got %s",
+					args[0].Inspect())
+			}
+			return &Integer{Value: rand.Int63n(n.Value)}
+		},
+	},
+	{
+		Name: "iter",
+		Fn: func(env *Environment, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			iterable, ok := args[0].(Iterable)
+			if !ok {
+				return newError(TYPE_ERROR, "argument to `iter` not supported, got %s",
+					args[0].Type())
+			}
+			return NewIteration(iterable)
+		},
+	},
+	{
+		// len returns the number of bytes of a string or the number of
+		// elements of an array or a hash.
+		Name: "len",
+		Fn: func(env *Environment, args ...Object) Object {
+			if len(args) != 1 {
+				return newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Hash:
+				return &Integer{Value: int64(len(arg.Pairs))}
+			default:
+				return newError(TYPE_ERROR, "argument to `len` not supported, got %s",
+					arg.Type())
+			}
+		},
+	},
+	{
+		Name: "first",
+		Fn: func(env *Environment, args ...Object) Object {
+			elements, err := arrayArgument("first", 1, args)
+			if err != nil {
+				return err
+			}
+			if len(elements) == 0 {
+				return nil
+			}
+			return elements[0]
+		},
+	},
+	{
+		Name: "last",
+		Fn: func(env *Environment, args ...Object) Object {
+			elements, err := arrayArgument("last", 1, args)
+			if err != nil {
+				return err
+			}
+			if len(elements) == 0 {
+				return nil
+			}
+			return elements[len(elements)-1]
+		},
+	},
+	{
+		// rest returns a new array without the first element, or null if the
+		// array is empty.
+		Name: "rest",
+		Fn: func(env *Environment, args ...Object) Object {
+			elements, err := arrayArgument("rest", 1, args)
+			if err != nil {
+				return err
+			}
+			if len(elements) == 0 {
+				return nil
+			}
+			rest := &Array{Elements: make([]Object, len(elements)-1)}
+			copy(rest.Elements, elements[1:])
+			if err := env.Budget().AllocateObject(rest); err != nil {
+				return err
+			}
+			return rest
+		},
+	},
+	{
+		// push returns a new array with the second argument added at the end.
+		Name: "push",
+		Fn: func(env *Environment, args ...Object) Object {
+			elements, err := arrayArgument("push", 2, args)
+			if err != nil {
+				return err
+			}
+			pushed := &Array{Elements: make([]Object, len(elements), len(elements)+1)}
+			copy(pushed.Elements, elements)
+			pushed.Elements = append(pushed.Elements, args[1])
+			if err := env.Budget().AllocateObject(pushed); err != nil {
+				return err
+			}
+			return pushed
+		},
+	},
+}
+
+// arrayArgument checks that a builtin called name got want arguments and that
+// the first one is an array, and returns its elements.
+func arrayArgument(name string, want int, args []Object) ([]Object, *Error) {
+	if len(args) != want {
+		return nil, newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=%d",
+			len(args), want)
+	}
+	array, ok := args[0].(*Array)
+	if !ok {
+		return nil, newError(TYPE_ERROR, "argument to `%s` must be ARRAY, got %s",
+			name, args[0].Type())
+	}
+	return array.Elements, nil
+}
+
+// GetBuiltinByName returns the builtin called name, or nil if there is none.
+func GetBuiltinByName(name string) *Builtin {
+	for _, b := range Builtins {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+func newError(kind string, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,273 @@
+package object
+
+import (
+	"monkey/ast"
+	"sort"
+)
+
+// Environment keeps track of the values bound to identifiers.  An environment
+// created with NewEnclosedEnvironment falls back to its outer environment when
+// an identifier can't be found in its own store.
+//
+// Identifiers that have been resolved are bound to slots instead, which are
+// indexed by position rather than looked up by name.  If the environment has
+// slot names, its slots can also be looked up and bound by name.
+type Environment struct {
+	store     map[string]Object
+	outer     *Environment
+	slots     []Object
+	slotNames SlotNames
+
+	// function is true for the environment of a function call.  Only those
+	// environments hold deferred expressions.
+	function bool
+	deferred []Deferred
+
+	// depth is the number of function calls in progress while evaluating in
+	// the environment.  It may not exceed maxDepth.
+	depth    int
+	maxDepth int
+
+	// budget limits the evaluation, sandbox the builtins it may call and
+	// streams are the input and output of builtins, if not nil.
+	budget  *Budget
+	sandbox *Sandbox
+	streams *Streams
+}
+
+// SlotNames gives names to the slots of an environment.  The resolver
+// implements it for the global variables of the programs it resolves.
+type SlotNames interface {
+	// Slot returns the slot of name and reports whether it has one.
+	Slot(name string) (int, bool)
+
+	// Define gives name a slot, unless it already has one, and returns it.
+	Define(name string) int
+
+	// Names returns the names that have slots.
+	Names() []string
+}
+
+// DefaultMaxCallDepth is the maximum call depth of new environments.  It is
+// low enough for the evaluator to stay well within the Go stack.
+const DefaultMaxCallDepth = 10000
+
+// Deferred is an expression whose evaluation is postponed until the function
+// call that deferred it returns.  Env is the environment the expression is
+// evaluated in.
+type Deferred struct {
+	Expression ast.Expression
+	Env        *Environment
+}
+
+// NewEnvironment returns a reference to a new, empty Environment.
+func NewEnvironment() *Environment {
+	s := make(map[string]Object)
+	return &Environment{store: s, maxDepth: DefaultMaxCallDepth}
+}
+
+// NewEnclosedEnvironment returns a new Environment that extends outer.  It is
+// evaluated during the same function call as outer.
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	env := NewEnvironment()
+	env.outer = outer
+	env.depth = outer.depth
+	env.maxDepth = outer.maxDepth
+	env.budget = outer.budget
+	env.sandbox = outer.sandbox
+	env.streams = outer.streams
+	return env
+}
+
+// NewFunctionEnvironment returns a new Environment that extends outer and
+// holds the arguments and deferred expressions of a single function call made
+// from the environment caller.  The call depth, its limit, the budget, the
+// sandbox and the streams come from caller.
+func NewFunctionEnvironment(outer, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.function = true
+	env.depth = caller.depth + 1
+	env.maxDepth = caller.maxDepth
+	env.budget = caller.budget
+	env.sandbox = caller.sandbox
+	env.streams = caller.streams
+	return env
+}
+
+// CallDepth returns the number of function calls in progress while evaluating
+// in e.
+func (e *Environment) CallDepth() int {
+	return e.depth
+}
+
+// MaxCallDepth returns the maximum call depth allowed while evaluating in e.
+func (e *Environment) MaxCallDepth() int {
+	return e.maxDepth
+}
+
+// SetMaxCallDepth sets the maximum call depth of e and of the environments
+// created from it afterwards.
+func (e *Environment) SetMaxCallDepth(depth int) {
+	e.maxDepth = depth
+}
+
+// Get returns the object bound to name, searching outer environments if
+// needed.
+func (e *Environment) Get(name string) (Object, bool) {
+	obj, ok := e.store[name]
+	if !ok && e.slotNames != nil {
+		if index, named := e.slotNames.Slot(name); named {
+			obj, ok = e.GetAt(0, index)
+		}
+	}
+	if !ok && e.outer != nil {
+		obj, ok = e.outer.Get(name)
+	}
+	return obj, ok
+}
+
+// Set binds val to name in this environment and returns val.  If the
+// environment has slot names, val is bound to the slot of name.
+func (e *Environment) Set(name string, val Object) Object {
+	if e.slotNames != nil {
+		return e.SetAt(e.slotNames.Define(name), val)
+	}
+	e.store[name] = val
+	return val
+}
+
+// Names returns the sorted names bound in this environment, not counting its
+// outer environments and the slots without names.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	if e.slotNames != nil {
+		for _, name := range e.slotNames.Names() {
+			if _, ok := e.store[name]; ok {
+				continue
+			}
+			index, _ := e.slotNames.Slot(name)
+			if _, ok := e.GetAt(0, index); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Outer returns the environment that e extends, which is nil for a global
+// environment.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// HasSlots reports whether values are bound to slots in this environment,
+// whether or not the slots have names.
+func (e *Environment) HasSlots() bool {
+	for _, val := range e.slots {
+		if val != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAt returns the object in slot index of the environment depth levels out
+// from e.  It reports false if nothing was bound to the slot yet.
+func (e *Environment) GetAt(depth, index int) (Object, bool) {
+	env := e
+	for i := 0; i < depth; i++ {
+		env = env.outer
+	}
+
+	if index >= len(env.slots) || env.slots[index] == nil {
+		return nil, false
+	}
+	return env.slots[index], true
+}
+
+// SetAt binds val to slot index in this environment and returns val.
+func (e *Environment) SetAt(index int, val Object) Object {
+	switch {
+	case index < len(e.slots):
+	case index < cap(e.slots):
+		e.slots = e.slots[:index+1]
+	default:
+		slots := make([]Object, index+1, 2*index+2)
+		copy(slots, e.slots)
+		e.slots = slots
+	}
+
+	e.slots[index] = val
+	return val
+}
+
+// SlotNames returns the names of the slots of e, which is nil if they have
+// none.
+func (e *Environment) SlotNames() SlotNames {
+	return e.slotNames
+}
+
+// SetSlotNames sets the names of the slots of e.  Set then binds names to
+// slots, defining the names that don't have one yet.
+func (e *Environment) SetSlotNames(n SlotNames) {
+	e.slotNames = n
+}
+
+// Budget returns the budget of evaluations in e, which is nil if they are
+// unlimited.
+func (e *Environment) Budget() *Budget {
+	return e.budget
+}
+
+// SetBudget sets the budget of evaluations in e and in the environments
+// created from it afterwards.
+func (e *Environment) SetBudget(b *Budget) {
+	e.budget = b
+}
+
+// Sandbox returns the sandbox of evaluations in e, which is nil if they may
+// call every builtin.
+func (e *Environment) Sandbox() *Sandbox {
+	return e.sandbox
+}
+
+// SetSandbox sets the sandbox of evaluations in e and in the environments
+// created from it afterwards.
+func (e *Environment) SetSandbox(s *Sandbox) {
+	e.sandbox = s
+}
+
+// Streams returns the input and output of builtins called in e.  Without
+// streams, output goes to os.Stdout and there is no input.
+func (e *Environment) Streams() *Streams {
+	return e.streams
+}
+
+// SetStreams sets the input and output of builtins called in e and in the
+// environments created from it afterwards.
+func (e *Environment) SetStreams(s *Streams) {
+	e.streams = s
+}
+
+// Defer adds d to the deferred expressions of the innermost function call
+// environment that e belongs to.  It reports whether there was such an
+// environment.
+func (e *Environment) Defer(d Deferred) bool {
+	for env := e; env != nil; env = env.outer {
+		if env.function {
+			env.deferred = append(env.deferred, d)
+			return true
+		}
+	}
+	return false
+}
+
+// Deferred returns the expressions deferred in the function call environment e
+// in the order they were deferred.
+func (e *Environment) Deferred() []Deferred {
+	return e.deferred
+}
@@ -0,0 +1,104 @@
+package object
+
+// Host code defines object types of its own by implementing Object, and gives
+// them behavior in programs by also implementing the interfaces below.  The
+// evaluator checks for them whenever it uses a value.
+
+// PropertyGetter is implemented by objects with properties, which programs
+// read with obj.name.  GetProperty reports false if there is no property name.
+type PropertyGetter interface {
+	Object
+	GetProperty(name string) (Object, bool)
+}
+
+// MethodCaller is implemented by objects with methods, which programs call
+// with obj.name(args).  A property with the same name takes precedence.
+// CallMethod returns an *Error to raise an error.
+type MethodCaller interface {
+	Object
+	HasMethod(name string) bool
+	CallMethod(name string, args []Object) Object
+}
+
+// Indexer is implemented by objects that programs index with obj[index].
+// Index returns an *Error to raise an error.
+type Indexer interface {
+	Object
+	Index(index Object) Object
+}
+
+// Operator is implemented by objects that define infix operators for which
+// they are the left operand, like obj + 1.  Operate reports false if it
+// doesn't define the operator for right, which leaves it to the evaluator.
+type Operator interface {
+	Object
+	Operate(operator string, right Object) (Object, bool)
+}
+
+// Iterable is implemented by objects that programs iterate over with the
+// iter builtin.
+type Iterable interface {
+	Object
+	Iterate() Iterator
+}
+
+// Iterator returns the elements of an Iterable one at a time.  Next reports
+// false once there are no more elements.
+type Iterator interface {
+	Next() (Object, bool)
+}
+
+// Iteration is what the iter builtin returns.  Programs call its done method
+// to know whether there is another element and its next method to get it.
+type Iteration struct {
+	iterator Iterator
+
+	// next is the element returned by the next call to the next method, if
+	// it was fetched already.
+	next    Object
+	fetched bool
+	done    bool
+}
+
+// NewIteration returns a reference to a new Iteration over the elements of it.
+func NewIteration(it Iterable) *Iteration {
+	return &Iteration{iterator: it.Iterate()}
+}
+
+// Type returns the Iteration type.
+func (i *Iteration) Type() ObjectType {
+	return ITERATION_OBJ
+}
+
+// Inspect returns the string representation of the Iteration type.
+func (i *Iteration) Inspect() string {
+	return "iteration"
+}
+
+// HasMethod reports whether name is a method of Iteration.
+func (i *Iteration) HasMethod(name string) bool {
+	return name == "done" || name == "next"
+}
+
+// CallMethod calls the method name of Iteration.
+func (i *Iteration) CallMethod(name string, args []Object) Object {
+	if len(args) != 0 {
+		return newError(TYPE_ERROR, "wrong number of arguments. got=%d, want=0",
+			len(args))
+	}
+
+	if !i.fetched && !i.done {
+		i.next, i.fetched = i.iterator.Next()
+		i.done = !i.fetched
+	}
+
+	if name == "done" {
+		return &Boolean{Value: i.done}
+	}
+
+	if i.done {
+		return newError(TYPE_ERROR, "next: iteration is done")
+	}
+	i.fetched = false
+	return i.next
+}
@@ -2,7 +2,14 @@
 // the Monkey language.
 package object
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"sort"
+	"strings"
+)
 
 // List of different objects supported in Monkey.
 const (
@@ -10,6 +17,37 @@ const (
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	ERROR_OBJ        = "ERROR"
+	EXCEPTION_OBJ    = "EXCEPTION"
+	FUNCTION_OBJ     = "FUNCTION"
+	BUILTIN_OBJ      = "BUILTIN"
+	ITERATION_OBJ    = "ITERATION"
+	STRING_OBJ       = "STRING"
+	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+)
+
+// Kinds of errors.  Errors raised by a throw statement have the kind
+// THROWN_ERROR and errors returned by Go functions called as builtins have the
+// kind HOST_ERROR.  The other kinds are raised by the evaluator.  Errors that
+// stop an evaluation from outside, CANCELLED_ERROR, TIMEOUT_ERROR and
+// BUDGET_ERROR, can't be caught.
+const (
+	THROWN_ERROR        = "Error"
+	NAME_ERROR          = "NameError"
+	TYPE_ERROR          = "TypeError"
+	ZERO_DIVISION_ERROR = "ZeroDivisionError"
+	MATCH_ERROR         = "MatchError"
+	RECURSION_ERROR     = "RecursionError"
+	CANCELLED_ERROR     = "CancelledError"
+	TIMEOUT_ERROR       = "TimeoutError"
+	BUDGET_ERROR        = "BudgetExceededError"
+	HOST_ERROR          = "HostError"
+	CAPABILITY_ERROR    = "CapabilityError"
+	MEMORY_ERROR        = "MemoryError"
 )
 
 // ObjectType represents a value.  All types are represented as Objects.
@@ -66,6 +104,119 @@ func (n *Null) Inspect() string {
 	return "null"
 }
 
+// String represents a sequence of bytes.
+type String struct {
+	Value string
+}
+
+// Type returns the String type.
+func (s *String) Type() ObjectType {
+	return STRING_OBJ
+}
+
+// Inspect returns the value of the String, without quotes.
+func (s *String) Inspect() string {
+	return s.Value
+}
+
+// Array represents a list of values.  Programs never modify an array: push
+// and rest return a new one.
+type Array struct {
+	Elements []Object
+}
+
+// Type returns the Array type.
+func (a *Array) Type() ObjectType {
+	return ARRAY_OBJ
+}
+
+// Inspect returns the string representation of the Array type.
+func (a *Array) Inspect() string {
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// Iterate returns an Iterator over the elements of the Array.
+func (a *Array) Iterate() Iterator {
+	return &arrayIterator{elements: a.Elements}
+}
+
+type arrayIterator struct {
+	elements []Object
+}
+
+func (it *arrayIterator) Next() (Object, bool) {
+	if len(it.elements) == 0 {
+		return nil, false
+	}
+	next := it.elements[0]
+	it.elements = it.elements[1:]
+	return next, true
+}
+
+// HashKey identifies a key of a Hash.  Keys of different types are different
+// even if they have the same value.
+type HashKey struct {
+	Type  ObjectType
+	Value int64
+	Text  string
+}
+
+// Hashable is implemented by the objects that can be keys of a Hash.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+// HashKey returns the key of the Integer in a Hash.
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: i.Value}
+}
+
+// HashKey returns the key of the Boolean in a Hash.
+func (b *Boolean) HashKey() HashKey {
+	if b.Value {
+		return HashKey{Type: b.Type(), Value: 1}
+	}
+	return HashKey{Type: b.Type()}
+}
+
+// HashKey returns the key of the String in a Hash.
+func (s *String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Text: s.Value}
+}
+
+// HashPair is a key of a Hash and the value it maps to.
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash represents a mapping of keys to values.  Like arrays, programs never
+// modify a hash.
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+// Type returns the Hash type.
+func (h *Hash) Type() ObjectType {
+	return HASH_OBJ
+}
+
+// Inspect returns the string representation of the Hash type.  The pairs are
+// sorted so that equal hashes look the same.
+func (h *Hash) Inspect() string {
+	pairs := []string{}
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
 // ReturnValue wraps the value to return.
 type ReturnValue struct {
 	Value Object
@@ -80,3 +231,185 @@ func (rv *ReturnValue) Type() ObjectType {
 func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
+
+// Error represents a runtime error.  Errors stop the evaluation of the program
+// in the same way a return statement does, unless they are caught by a try
+// expression.  Kind is one of the error kinds listed above and Stack holds the
+// calls the error propagated through, innermost first, up to MaxErrorStack of
+// them.  Value is the thrown
+// object for errors raised by a throw statement and nil otherwise.
+type Error struct {
+	Message string
+	Kind    string
+	Stack   []string
+	Value   Object
+}
+
+// MaxErrorStack is the maximum number of calls the stack of an error holds.
+// Deep recursion would otherwise leave thousands of copies of the same call.
+const MaxErrorStack = 100
+
+// Type returns the Error type.
+func (e *Error) Type() ObjectType {
+	return ERROR_OBJ
+}
+
+// AddToStack adds call to the stack of e, unless the stack is full.  The
+// outermost calls are left out of a full stack.
+func (e *Error) AddToStack(call string) {
+	if len(e.Stack) < MaxErrorStack {
+		e.Stack = append(e.Stack, call)
+	}
+}
+
+// Inspect returns the string representation of the Error type.
+func (e *Error) Inspect() string {
+	return "ERROR: " + e.Message
+}
+
+// Catchable reports whether a try expression can catch e.
+func (e *Error) Catchable() bool {
+	switch e.Kind {
+	case CANCELLED_ERROR, TIMEOUT_ERROR, BUDGET_ERROR:
+		return false
+	default:
+		return true
+	}
+}
+
+// Exception is an error that has been caught by a try expression.  Unlike an
+// Error, it doesn't stop the evaluation of the program so it can be bound to
+// identifiers, passed around like any other value and thrown again.
+type Exception struct {
+	Error *Error
+}
+
+// Type returns the Exception type.
+func (e *Exception) Type() ObjectType {
+	return EXCEPTION_OBJ
+}
+
+// Inspect returns the string representation of the Exception type.
+func (e *Exception) Inspect() string {
+	return e.Error.Kind + ": " + e.Error.Message
+}
+
+// GetProperty returns the properties of the caught error: its kind, its
+// message, its stack as an array of strings, innermost call first, and the
+// thrown value, which is null unless the error was raised by a throw statement.
+func (e *Exception) GetProperty(name string) (Object, bool) {
+	switch name {
+	case "kind":
+		return &String{Value: e.Error.Kind}, true
+	case "message":
+		return &String{Value: e.Error.Message}, true
+	case "stack":
+		stack := make([]Object, len(e.Error.Stack))
+		for i, call := range e.Error.Stack {
+			stack[i] = &String{Value: call}
+		}
+		return &Array{Elements: stack}, true
+	case "value":
+		if e.Error.Value == nil {
+			return &Null{}, true
+		}
+		return e.Error.Value, true
+	}
+	return nil, false
+}
+
+// Function represents a function value.  Env is the environment the function
+// was defined in, which makes every function a closure.
+type Function struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+// Type returns the Function type.
+func (f *Function) Type() ObjectType {
+	return FUNCTION_OBJ
+}
+
+// Inspect returns the string representation of the Function type.
+func (f *Function) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(f.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// BuiltinFunction is the Go function behind a builtin.  env is the environment
+// of the call, which holds the streams for input and output.  It returns an
+// *Error to raise an error.
+type BuiltinFunction func(env *Environment, args ...Object) Object
+
+// Builtin represents a function provided by Go code rather than defined by the
+// program.  Capability is the group of side effects the function has, if any,
+// which a Sandbox may deny.
+type Builtin struct {
+	Name       string
+	Capability string
+	Fn         BuiltinFunction
+}
+
+// Type returns the Builtin type.
+func (b *Builtin) Type() ObjectType {
+	return BUILTIN_OBJ
+}
+
+// Inspect returns the string representation of the Builtin type.
+func (b *Builtin) Inspect() string {
+	return "builtin function " + b.Name
+}
+
+// CompiledFunction represents a function that was compiled to bytecode.
+// LocalNames and FreeNames hold the names of the local and free variables by
+// slot index so that errors can refer to variables by name.  Temporary slots
+// used by the compiler have an empty name.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Name          string
+	LocalNames    []string
+	FreeNames     []string
+}
+
+// Type returns the CompiledFunction type.
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+
+// Inspect returns the string representation of the CompiledFunction type.
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure represents a compiled function together with the free variables it
+// captured when it was created.  How the variables are held is up to the
+// virtual machine.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+// Type returns the Closure type.
+func (c *Closure) Type() ObjectType {
+	return CLOSURE_OBJ
+}
+
+// Inspect returns the string representation of the Closure type.
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
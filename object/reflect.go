@@ -0,0 +1,517 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var (
+	objectType    = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// NewBuiltin returns a Builtin named name that calls the Go function fn.
+// Arguments are converted to the types of the parameters of fn and the result
+// back to an Object:
+//
+//   - Integers convert to and from every integer type, as long as the value
+//     fits.
+//   - Booleans convert to and from bool.
+//   - Strings convert to and from string.
+//   - Arrays convert to and from slices and Go arrays of the same length, and
+//     hashes to and from maps whose keys are integers, booleans or strings.
+//   - Hashes also convert to and from structs.  The keys are the names of the
+//     exported fields, or the names given by a `monkey:"name"` field tag.
+//     Fields tagged `monkey:"-"` are left out.  Converting a hash to a struct
+//     ignores keys without a field and leaves fields without a key unset.
+//   - Null converts to nil slices and maps.
+//   - Parameters and results of type Object, or of a type that implements it,
+//     take and give objects as they are.
+//   - Parameters of an empty interface type take integers as int64, booleans
+//     as bool, strings as string, arrays as []interface{}, hashes as
+//     map[interface{}]interface{}, null as nil and any other object as it is.
+//
+// fn may be variadic and may return nothing, a value, an error, or a value and
+// an error.  A non-nil error, or a panic in fn, is raised as an error of kind
+// HOST_ERROR.  Calls with the wrong number or types of arguments raise a
+// TYPE_ERROR.
+//
+// NewBuiltin returns an error if fn isn't a function or has a parameter or
+// result type that no object converts to.
+func NewBuiltin(name string, fn interface{}) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, fmt.Errorf("builtin %s: not a function: %T", name, fn)
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		if !convertible(in) {
+			return nil, fmt.Errorf("builtin %s: unsupported parameter type %s", name, in)
+		}
+	}
+
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	results := t.NumOut()
+	if returnsError {
+		results--
+	}
+	if results > 1 || (results == 1 && !convertible(t.Out(0))) {
+		return nil, fmt.Errorf("builtin %s: unsupported result types of %s", name, t)
+	}
+
+	call := func(env *Environment, args ...Object) (result Object) {
+		in, err := goArguments(t, args)
+		if err != nil {
+			return newError(TYPE_ERROR, "%s: %s", name, err)
+		}
+
+		// A panic in fn must not crash the program embedding the interpreter.
+		defer func() {
+			if r := recover(); r != nil {
+				result = newError(HOST_ERROR, "%s: panic: %v", name, r)
+			}
+		}()
+
+		out := v.Call(in)
+
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return newError(HOST_ERROR, "%s: %s", name, err)
+			}
+		}
+
+		if results == 0 {
+			return nullObject
+		}
+
+		obj, err := fromGoValue(out[0])
+		if err != nil {
+			return newError(TYPE_ERROR, "%s: %s", name, err)
+		}
+		return obj
+	}
+
+	return &Builtin{Name: name, Fn: call}, nil
+}
+
+// FromGo converts the Go value v to an object.  Integers of every integer type
+// convert to Integer, bools to Boolean, strings to String, slices and Go arrays
+// to Array, maps and structs to Hash, nil to Null, and objects stay as they
+// are.  Interfaces and pointers convert what they hold.  Structs convert like
+// the struct parameters of a builtin created by NewBuiltin.  v must not refer
+// to itself.
+func FromGo(v interface{}) (Object, error) {
+	if v == nil {
+		return nullObject, nil
+	}
+	return fromGoValue(reflect.ValueOf(v))
+}
+
+// ToGo stores obj in the variable target points to, converting it like the
+// arguments of a builtin created by NewBuiltin.  It returns an error if target
+// isn't a non-nil pointer or obj doesn't convert to the type it points to.
+func ToGo(obj Object, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("target is not a non-nil pointer: %T", target)
+	}
+
+	elem := ptr.Elem()
+	if !convertible(elem.Type()) {
+		return fmt.Errorf("cannot convert to %s", elem.Type())
+	}
+
+	v, err := toGoValue(obj, elem.Type())
+	if err != nil {
+		return err
+	}
+
+	elem.Set(v)
+	return nil
+}
+
+// nullObject is what a builtin returns when its Go function returns nothing or
+// nil.  The evaluator replaces the booleans and nulls returned by builtins with
+// its own singletons.
+var nullObject = &Null{}
+
+// goArguments converts the arguments of a call to a function of type t.
+func goArguments(t reflect.Type, args []Object) ([]reflect.Value, error) {
+	n := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < n-1 {
+			return nil, fmt.Errorf("wrong number of arguments: want at least %d, got=%d",
+				n-1, len(args))
+		}
+	} else if len(args) != n {
+		return nil, fmt.Errorf("wrong number of arguments: want=%d, got=%d", n, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var target reflect.Type
+		if t.IsVariadic() && i >= n-1 {
+			target = t.In(n - 1).Elem()
+		} else {
+			target = t.In(i)
+		}
+
+		v, err := toGoValue(arg, target)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i+1, err)
+		}
+		in[i] = v
+	}
+
+	return in, nil
+}
+
+// convertible reports whether objects convert to and from values of type t.
+func convertible(t reflect.Type) bool {
+	return convertibleType(t, map[reflect.Type]bool{})
+}
+
+// convertibleType is convertible for the types that t is made of.  seen holds
+// the types already being checked, so that recursive types are checked once.
+func convertibleType(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] || t.Implements(objectType) {
+		return true
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0 || objectType.Implements(t)
+	case reflect.Slice, reflect.Array:
+		return convertibleType(t.Elem(), seen)
+	case reflect.Map:
+		return hashableKind(t.Key().Kind()) && convertibleType(t.Elem(), seen)
+	case reflect.Struct:
+		for _, field := range structFields(t) {
+			if !convertibleType(field.typ, seen) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// hashableKind reports whether Go values of kind k convert to objects that can
+// be keys of a Hash.
+func hashableKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// structField is a field of a struct that converts to a key of a Hash.
+type structField struct {
+	name  string
+	index int
+	typ   reflect.Type
+}
+
+// structFields returns the fields of the struct type t that convert to keys of
+// a Hash, named by their monkey field tags.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: i, typ: field.Type})
+	}
+	return fields
+}
+
+// toGoValue converts obj to a value of type t.
+func toGoValue(obj Object, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = nullObject
+	}
+
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := obj.(*Boolean)
+		if !ok {
+			return mismatch()
+		}
+		return reflect.ValueOf(b.Value).Convert(t), nil
+
+	case reflect.String:
+		str, ok := obj.(*String)
+		if !ok {
+			return mismatch()
+		}
+		return reflect.ValueOf(str.Value).Convert(t), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*Integer)
+		if !ok {
+			return mismatch()
+		}
+		v := reflect.New(t).Elem()
+		if v.OverflowInt(i.Value) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+		}
+		v.SetInt(i.Value)
+		return v, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := obj.(*Integer)
+		if !ok {
+			return mismatch()
+		}
+		v := reflect.New(t).Elem()
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+		}
+		v.SetUint(uint64(i.Value))
+		return v, nil
+
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			v := reflect.New(t).Elem()
+			switch obj := obj.(type) {
+			case *Integer:
+				v.Set(reflect.ValueOf(obj.Value))
+			case *Boolean:
+				v.Set(reflect.ValueOf(obj.Value))
+			case *String:
+				v.Set(reflect.ValueOf(obj.Value))
+			case *Array:
+				elements, err := toGoValue(obj, reflect.SliceOf(interfaceType))
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.Set(elements)
+			case *Hash:
+				pairs, err := toGoValue(obj, reflect.MapOf(interfaceType, interfaceType))
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.Set(pairs)
+			case *Null:
+			default:
+				v.Set(reflect.ValueOf(obj))
+			}
+			return v, nil
+		}
+
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		if !t.Implements(objectType) {
+			return toGoComposite(obj, t)
+		}
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		v := reflect.New(t).Elem()
+		v.Set(reflect.ValueOf(obj))
+		return v, nil
+	}
+
+	return mismatch()
+}
+
+// toGoComposite converts obj to a slice, array, map or struct of type t.
+func toGoComposite(obj Object, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+
+	if _, ok := obj.(*Null); ok && (t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+		return v, nil
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		switch t.Kind() {
+		case reflect.Slice:
+			v = reflect.MakeSlice(t, len(obj.Elements), len(obj.Elements))
+		case reflect.Array:
+			if len(obj.Elements) != t.Len() {
+				return reflect.Value{}, fmt.Errorf("cannot use ARRAY of %d elements as %s",
+					len(obj.Elements), t)
+			}
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot use ARRAY as %s", t)
+		}
+
+		for i, element := range obj.Elements {
+			e, err := toGoValue(element, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("index %d: %s", i, err)
+			}
+			v.Index(i).Set(e)
+		}
+		return v, nil
+
+	case *Hash:
+		switch t.Kind() {
+		case reflect.Map:
+			v = reflect.MakeMapWithSize(t, len(obj.Pairs))
+			for _, pair := range obj.Pairs {
+				key, err := toGoValue(pair.Key, t.Key())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+				}
+				value, err := toGoValue(pair.Value, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("value of key %s: %s",
+						pair.Key.Inspect(), err)
+				}
+				v.SetMapIndex(key, value)
+			}
+			return v, nil
+
+		case reflect.Struct:
+			for _, field := range structFields(t) {
+				pair, ok := obj.Pairs[(&String{Value: field.name}).HashKey()]
+				if !ok {
+					continue
+				}
+				value, err := toGoValue(pair.Value, field.typ)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("field %s: %s", field.name, err)
+				}
+				v.Field(field.index).Set(value)
+			}
+			return v, nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
+
+// fromGoValue converts v to an object.
+func fromGoValue(v reflect.Value) (Object, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return &Boolean{Value: v.Bool()}, nil
+
+	case reflect.String:
+		if obj, ok := v.Interface().(Object); ok {
+			return obj, nil
+		}
+		return &String{Value: v.String()}, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nullObject, nil
+		}
+		if obj, ok := v.Interface().(Object); ok {
+			return obj, nil
+		}
+		return fromGoValue(v.Elem())
+
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		if obj, ok := v.Interface().(Object); ok {
+			return obj, nil
+		}
+		return fromGoComposite(v)
+	}
+
+	if obj, ok := v.Interface().(Object); ok {
+		return obj, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to an object", v.Type())
+}
+
+// fromGoComposite converts the slice, array, map or struct v to an object.
+func fromGoComposite(v reflect.Value) (Object, error) {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nullObject, nil
+		}
+
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := fromGoValue(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %s", i, err)
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nullObject, nil
+		}
+
+		pairs := make(map[HashKey]HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromGoValue(iter.Key())
+			if err != nil {
+				return nil, fmt.Errorf("key: %s", err)
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf("cannot use %s as a hash key", key.Type())
+			}
+
+			value, err := fromGoValue(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("value of key %s: %s", key.Inspect(), err)
+			}
+			pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return &Hash{Pairs: pairs}, nil
+
+	default:
+		fields := structFields(v.Type())
+		pairs := make(map[HashKey]HashPair, len(fields))
+		for _, field := range fields {
+			value, err := fromGoValue(v.Field(field.index))
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", field.name, err)
+			}
+			key := &String{Value: field.name}
+			pairs[key.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return &Hash{Pairs: pairs}, nil
+	}
+}
@@ -0,0 +1,178 @@
+package object
+
+import (
+	"reflect"
+	"testing"
+)
+
+type config struct {
+	Name    string
+	Limit   int32 `monkey:"limit"`
+	Tags    []string
+	Weights map[string]uint8 `monkey:"weights"`
+	Secret  string           `monkey:"-"`
+	hidden  int
+}
+
+func TestFromGo(t *testing.T) {
+	limit := int32(10)
+
+	tests := []struct {
+		v        interface{}
+		expected string
+	}{
+		{limit, "10"},
+		{&limit, "10"},
+		{true, "true"},
+		{nil, "null"},
+		{uint64(1) << 40, "1099511627776"},
+		{"monkey", "monkey"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{[]interface{}{1, "a", nil, []int{}}, "[1, a, null, []]"},
+		{[]int(nil), "null"},
+		{map[string]int{"a": 1, "b": 2}, "{a: 1, b: 2}"},
+		{map[int]bool{1: true}, "{1: true}"},
+		{map[string]int(nil), "null"},
+		{
+			config{Name: "x", Limit: 3, Tags: []string{"t"}, Secret: "s", hidden: 1},
+			"{Name: x, Tags: [t], limit: 3, weights: null}",
+		},
+		{&Integer{Value: 5}, "5"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.v)
+		if err != nil {
+			t.Errorf("FromGo(%v) failed: %s", tt.v, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromGo(%v): wrong object. want=%q, got=%q", tt.v, tt.expected,
+				obj.Inspect())
+		}
+	}
+
+	errorTests := []struct {
+		v        interface{}
+		expected string
+	}{
+		{1.5, "cannot convert float64 to an object"},
+		{[]float64{1}, "index 0: cannot convert float64 to an object"},
+		{map[string]float64{"a": 1}, "value of key a: cannot convert float64 to an object"},
+		{map[float64]int{1: 1}, "key: cannot convert float64 to an object"},
+		{map[interface{}]int{&Array{}: 1}, "cannot use ARRAY as a hash key"},
+		{struct{ A []float64 }{[]float64{1}}, "field A: index 0: cannot convert float64 to an object"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := FromGo(tt.v)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %v. want=%q, got=%v", tt.v, tt.expected, err)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	n := &Integer{Value: 20 + 1<<40}
+
+	var i int64
+	if err := ToGo(n, &i); err != nil || i != n.Value {
+		t.Errorf("wrong int64 value. got=%d (%v)", i, err)
+	}
+
+	var small uint8
+	if err := ToGo(n, &small); err == nil {
+		t.Errorf("no error for overflow of uint8")
+	}
+
+	var b bool
+	if err := ToGo(&Boolean{Value: true}, &b); err != nil || !b {
+		t.Errorf("wrong bool value. got=%t (%v)", b, err)
+	}
+
+	var s string
+	if err := ToGo(&String{Value: "text"}, &s); err != nil || s != "text" {
+		t.Errorf("wrong string value. got=%q (%v)", s, err)
+	}
+
+	hash := newHash(
+		&String{Value: "Name"}, &String{Value: "x"},
+		&String{Value: "limit"}, &Integer{Value: 3},
+		&String{Value: "Tags"}, &Array{Elements: []Object{&String{Value: "t"}}},
+		&String{Value: "weights"}, newHash(&String{Value: "w"}, &Integer{Value: 4}),
+		&String{Value: "Secret"}, &String{Value: "s"},
+		&String{Value: "other"}, &Integer{Value: 5},
+	)
+	var c config
+	if err := ToGo(hash, &c); err != nil {
+		t.Fatalf("ToGo failed for a struct: %s", err)
+	}
+	expected := config{Name: "x", Limit: 3, Tags: []string{"t"},
+		Weights: map[string]uint8{"w": 4}}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("wrong struct value. want=%+v, got=%+v", expected, c)
+	}
+
+	var pair [2]int
+	if err := ToGo(&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}},
+		&pair); err != nil || pair != [2]int{1, 2} {
+		t.Errorf("wrong array value. got=%v (%v)", pair, err)
+	}
+
+	ints := []int{1}
+	if err := ToGo(&Null{}, &ints); err != nil || ints != nil {
+		t.Errorf("null didn't convert to a nil slice. got=%v (%v)", ints, err)
+	}
+
+	var value interface{}
+	if err := ToGo(n, &value); err != nil || value != n.Value {
+		t.Errorf("wrong interface value. got=%v (%v)", value, err)
+	}
+
+	array := &Array{Elements: []Object{&Integer{Value: 1}, newHash(&Boolean{Value: true}, &Null{})}}
+	if err := ToGo(array, &value); err != nil {
+		t.Fatalf("ToGo failed for an array in an interface: %s", err)
+	}
+	expectedValue := []interface{}{int64(1), map[interface{}]interface{}{true: nil}}
+	if !reflect.DeepEqual(value, expectedValue) {
+		t.Errorf("wrong interface value. want=%#v, got=%#v", expectedValue, value)
+	}
+
+	errorTests := []struct {
+		obj      Object
+		target   interface{}
+		expected string
+	}{
+		{n, i, "target is not a non-nil pointer: int64"},
+		{n, &s, "cannot use INTEGER as string"},
+		{n, new(float64), "cannot convert to float64"},
+		{n, new(map[float64]int), "cannot convert to map[float64]int"},
+		{n, &ints, "cannot use INTEGER as []int"},
+		{&Array{Elements: []Object{&Boolean{}}}, &ints, "index 0: cannot use BOOLEAN as int"},
+		{&Array{}, &pair, "cannot use ARRAY of 0 elements as [2]int"},
+		{newHash(&Integer{Value: 1}, &Integer{Value: 1}), new(map[string]int),
+			"key 1: cannot use INTEGER as string"},
+		{newHash(&String{Value: "a"}, &Boolean{}), new(map[string]int),
+			"value of key a: cannot use BOOLEAN as int"},
+		{newHash(&String{Value: "limit"}, &Integer{Value: 1 << 40}), &c,
+			"field limit: 1099511627776 overflows int32"},
+		{&Array{}, &c, "cannot use ARRAY as object.config"},
+	}
+
+	for _, tt := range errorTests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.obj.Inspect(), tt.expected, err)
+		}
+	}
+}
+
+// newHash returns a Hash of the keys and values in pairs.
+func newHash(pairs ...Object) *Hash {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for i := 0; i < len(pairs); i += 2 {
+		hash.Pairs[pairs[i].(Hashable).HashKey()] = HashPair{Key: pairs[i], Value: pairs[i+1]}
+	}
+	return hash
+}
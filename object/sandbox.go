@@ -0,0 +1,52 @@
+package object
+
+// Sandbox decides which builtins a program may call.  A builtin is permitted
+// if it has no capability, its capability is in Capabilities or its name is in
+// Allow, unless its name is in Deny.
+type Sandbox struct {
+	Capabilities map[string]bool
+	Allow        map[string]bool
+	Deny         map[string]bool
+}
+
+// NewSandbox returns a reference to a new Sandbox that permits the builtins
+// with the given capabilities.  Without capabilities, it is the default
+// profile, which only permits builtins without side effects.
+func NewSandbox(capabilities ...string) *Sandbox {
+	s := &Sandbox{
+		Capabilities: make(map[string]bool),
+		Allow:        make(map[string]bool),
+		Deny:         make(map[string]bool),
+	}
+	for _, c := range capabilities {
+		s.Capabilities[c] = true
+	}
+	return s
+}
+
+// Permits reports whether b may be called.  A nil Sandbox permits every
+// builtin.
+func (s *Sandbox) Permits(b *Builtin) bool {
+	if s == nil {
+		return true
+	}
+
+	if s.Deny[b.Name] {
+		return false
+	}
+	return b.Capability == "" || s.Capabilities[b.Capability] || s.Allow[b.Name]
+}
+
+// Check returns the capability error raised by calling b, or nil if b may be
+// called.
+func (s *Sandbox) Check(b *Builtin) *Error {
+	if s.Permits(b) {
+		return nil
+	}
+
+	if b.Capability == "" {
+		return newError(CAPABILITY_ERROR, "capability denied: %s", b.Name)
+	}
+	return newError(CAPABILITY_ERROR,
+		"capability denied: %s needs the %s capability", b.Name, b.Capability)
+}
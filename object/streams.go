@@ -0,0 +1,32 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Streams are what builtins read input from and write output to.  Out is for
+// the output of programs and Err for their error messages.
+type Streams struct {
+	Out io.Writer
+	Err io.Writer
+	in  *bufio.Reader
+}
+
+// NewStreams returns a reference to new Streams that read from in and write
+// output and error messages to out.  in is buffered, so nothing else should
+// read from it afterwards.
+func NewStreams(in io.Reader, out io.Writer) *Streams {
+	return &Streams{Out: out, Err: out, in: bufio.NewReader(in)}
+}
+
+// ReadLine reads a line of input and returns it without the line ending.  At
+// the end of the input, it returns io.EOF, along with the last line if it had
+// no line ending.
+func (s *Streams) ReadLine() (string, error) {
+	line, err := s.in.ReadString('\n')
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, err
+}
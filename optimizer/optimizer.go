@@ -0,0 +1,386 @@
+/*
+Package optimizer rewrites the abstract syntax tree of a Monkey program into an
+equivalent one that is cheaper to evaluate or compile.
+
+To optimize:
+
+	transformations := Optimize(program)
+
+The program is rewritten in place and the transformations that were applied
+are returned in the order they were applied.  Subexpressions are optimized
+before the expressions that contain them, so that folding can cascade.
+
+A rewrite never changes the value of a program, including the errors it raises
+at run time.  For example, 1 / 0 is left alone so that it still raises a
+division by zero error, and x + 0 is only simplified to x when x can't be
+anything but an integer.
+*/
+package optimizer
+
+import (
+	"monkey/ast"
+	"monkey/token"
+	"strconv"
+)
+
+// Kinds of transformations.
+const (
+	// FOLD replaces an operation on literals by its result.
+	FOLD = "fold"
+	// SIMPLIFY removes an operation that doesn't change its operand.
+	SIMPLIFY = "simplify"
+	// BRANCH replaces a conditional with a constant condition by the branch
+	// that is always taken.
+	BRANCH = "branch"
+)
+
+// Transformation describes a rewrite of an expression.  Before and After are
+// the string representations of the expression before and after the rewrite.
+type Transformation struct {
+	Kind   string
+	Before string
+	After  string
+}
+
+// String returns a one line description of t.
+func (t Transformation) String() string {
+	return t.Kind + ": " + t.Before + " => " + t.After
+}
+
+// Optimize rewrites program in place and returns the transformations applied.
+func Optimize(program *ast.Program) []Transformation {
+	o := &optimizer{}
+	o.statements(program.Statements)
+	return o.transformations
+}
+
+type optimizer struct {
+	transformations []Transformation
+}
+
+func (o *optimizer) record(kind string, before, after ast.Expression) ast.Expression {
+	o.transformations = append(o.transformations, Transformation{
+		Kind:   kind,
+		Before: before.String(),
+		After:  after.String(),
+	})
+	return after
+}
+
+func (o *optimizer) statements(statements []ast.Statement) {
+	for _, s := range statements {
+		o.statement(s)
+	}
+}
+
+func (o *optimizer) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		s.Expression = o.expression(s.Expression)
+	case *ast.LetStatement:
+		s.Value = o.expression(s.Value)
+	case *ast.ReturnStatement:
+		s.ReturnValue = o.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		s.Value = o.expression(s.Value)
+	case *ast.DeferStatement:
+		s.Expression = o.expression(s.Expression)
+	case *ast.BlockStatement:
+		o.block(s)
+	}
+}
+
+func (o *optimizer) block(b *ast.BlockStatement) {
+	if b != nil {
+		o.statements(b.Statements)
+	}
+}
+
+// expression optimizes e and returns the expression that replaces it.
+func (o *optimizer) expression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		e.Right = o.expression(e.Right)
+		return o.prefix(e)
+
+	case *ast.InfixExpression:
+		e.Left = o.expression(e.Left)
+		e.Right = o.expression(e.Right)
+		return o.infix(e)
+
+	case *ast.IfExpression:
+		e.Condition = o.expression(e.Condition)
+		o.block(e.Consequence)
+		o.block(e.Alternative)
+		return o.ifExpression(e)
+
+	case *ast.TernaryExpression:
+		e.Condition = o.expression(e.Condition)
+		e.Consequence = o.expression(e.Consequence)
+		e.Alternative = o.expression(e.Alternative)
+
+		if truthy, ok := constantTruthiness(e.Condition); ok {
+			if truthy {
+				return o.record(BRANCH, e, e.Consequence)
+			}
+			return o.record(BRANCH, e, e.Alternative)
+		}
+
+	case *ast.FunctionLiteral:
+		o.block(e.Body)
+
+	case *ast.CallExpression:
+		e.Function = o.expression(e.Function)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = o.expression(arg)
+		}
+
+	case *ast.DotExpression:
+		e.Left = o.expression(e.Left)
+
+	case *ast.IndexExpression:
+		e.Left = o.expression(e.Left)
+		e.Index = o.expression(e.Index)
+
+	case *ast.ArrayLiteral:
+		for i, element := range e.Elements {
+			e.Elements[i] = o.expression(element)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range e.Pairs {
+			pair.Key = o.expression(pair.Key)
+			pair.Value = o.expression(pair.Value)
+		}
+
+	case *ast.MatchExpression:
+		// Patterns are left alone: they aren't evaluated.
+		e.Subject = o.expression(e.Subject)
+		for _, arm := range e.Arms {
+			if arm.Guard != nil {
+				arm.Guard = o.expression(arm.Guard)
+			}
+			arm.Body = o.expression(arm.Body)
+		}
+
+	case *ast.SwitchExpression:
+		e.Subject = o.expression(e.Subject)
+		for _, c := range e.Cases {
+			for i, value := range c.Values {
+				c.Values[i] = o.expression(value)
+			}
+			o.block(c.Body)
+		}
+		o.block(e.Default)
+
+	case *ast.TryExpression:
+		o.block(e.Block)
+		o.block(e.Catch)
+		o.block(e.Finally)
+	}
+
+	return e
+}
+
+func (o *optimizer) prefix(e *ast.PrefixExpression) ast.Expression {
+	switch e.Operator {
+	case "-":
+		if right, ok := e.Right.(*ast.IntegerLiteral); ok {
+			return o.record(FOLD, e, newInteger(-right.Value))
+		}
+
+	case "!":
+		if truthy, ok := constantTruthiness(e.Right); ok {
+			return o.record(FOLD, e, newBoolean(!truthy))
+		}
+
+		// !!x is x if x is a boolean.
+		if inner, ok := e.Right.(*ast.PrefixExpression); ok && inner.Operator == "!" {
+			if isBooleanValued(inner.Right) {
+				return o.record(SIMPLIFY, e, inner.Right)
+			}
+		}
+	}
+
+	return e
+}
+
+func (o *optimizer) infix(e *ast.InfixExpression) ast.Expression {
+	left, leftIsInt := e.Left.(*ast.IntegerLiteral)
+	right, rightIsInt := e.Right.(*ast.IntegerLiteral)
+
+	if leftIsInt && rightIsInt {
+		if folded := foldIntegers(e.Operator, left.Value, right.Value); folded != nil {
+			return o.record(FOLD, e, folded)
+		}
+		return e
+	}
+
+	leftBool, leftIsBool := e.Left.(*ast.Boolean)
+	rightBool, rightIsBool := e.Right.(*ast.Boolean)
+
+	if leftIsBool && rightIsBool {
+		switch e.Operator {
+		case "==":
+			return o.record(FOLD, e, newBoolean(leftBool.Value == rightBool.Value))
+		case "!=":
+			return o.record(FOLD, e, newBoolean(leftBool.Value != rightBool.Value))
+		}
+		return e
+	}
+
+	// Identities only hold if the other operand is an integer.  Otherwise the
+	// operation raises a type error that has to be kept.
+	switch {
+	case rightIsInt && isIntegerValued(e.Left) && isRightIdentity(e.Operator, right.Value):
+		return o.record(SIMPLIFY, e, e.Left)
+	case leftIsInt && isIntegerValued(e.Right) && isLeftIdentity(e.Operator, left.Value):
+		return o.record(SIMPLIFY, e, e.Right)
+	}
+
+	return e
+}
+
+// ifExpression replaces an if expression with a constant condition by the
+// expression of the branch that is taken.  It is only done if that branch
+// holds a single expression statement, since other statements can't be
+// turned into an expression.
+func (o *optimizer) ifExpression(e *ast.IfExpression) ast.Expression {
+	truthy, ok := constantTruthiness(e.Condition)
+	if !ok {
+		return e
+	}
+
+	branch := e.Alternative
+	if truthy {
+		branch = e.Consequence
+	}
+
+	if branch == nil || len(branch.Statements) != 1 {
+		return e
+	}
+
+	if s, ok := branch.Statements[0].(*ast.ExpressionStatement); ok {
+		return o.record(BRANCH, e, s.Expression)
+	}
+
+	return e
+}
+
+// foldIntegers returns the literal result of applying operator to two
+// integers, or nil if it can't be computed at compile time.
+func foldIntegers(operator string, left, right int64) ast.Expression {
+	switch operator {
+	case "+":
+		return newInteger(left + right)
+	case "-":
+		return newInteger(left - right)
+	case "*":
+		return newInteger(left * right)
+	case "/":
+		// Division by zero must remain a run time error.
+		if right == 0 {
+			return nil
+		}
+		return newInteger(left / right)
+	case "<":
+		return newBoolean(left < right)
+	case ">":
+		return newBoolean(left > right)
+	case "==":
+		return newBoolean(left == right)
+	case "!=":
+		return newBoolean(left != right)
+	}
+
+	return nil
+}
+
+func isRightIdentity(operator string, value int64) bool {
+	switch operator {
+	case "+", "-":
+		return value == 0
+	case "*", "/":
+		return value == 1
+	}
+	return false
+}
+
+func isLeftIdentity(operator string, value int64) bool {
+	switch operator {
+	case "+":
+		return value == 0
+	case "*":
+		return value == 1
+	}
+	return false
+}
+
+// constantTruthiness reports whether e is a literal and if so, whether it is
+// truthy.
+func constantTruthiness(e ast.Expression) (truthy bool, ok bool) {
+	switch e := e.(type) {
+	case *ast.Boolean:
+		return e.Value, true
+	case *ast.IntegerLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+// isIntegerValued reports whether e evaluates to an integer whenever it
+// doesn't raise an error.  Negation only applies to integers, but an infix
+// operator may give a value of another type unless its operands are integer
+// valued too: a string or a host object on the left defines the operator.
+func isIntegerValued(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return e.Operator == "-"
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "+", "-", "*", "/":
+			return isIntegerValued(e.Left) && isIntegerValued(e.Right)
+		}
+	}
+	return false
+}
+
+// isBooleanValued reports whether e evaluates to a boolean whenever it doesn't
+// raise an error.  Like the infix operators of isIntegerValued, comparisons
+// only qualify if their operands are integer or boolean valued.
+func isBooleanValued(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		return e.Operator == "!"
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "<", ">":
+			return isIntegerValued(e.Left) && isIntegerValued(e.Right)
+		case "==", "!=":
+			return isIntegerValued(e.Left) && isIntegerValued(e.Right) ||
+				isBooleanValued(e.Left) && isBooleanValued(e.Right)
+		}
+	}
+	return false
+}
+
+func newInteger(value int64) *ast.IntegerLiteral {
+	literal := strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{
+		Token: token.Token{Type: token.INT, Literal: literal},
+		Value: value,
+	}
+}
+
+func newBoolean(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"},
+			Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"},
+		Value: false}
+}
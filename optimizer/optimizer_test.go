@@ -0,0 +1,147 @@
+package optimizer
+
+import (
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input                   string
+		expected                string
+		expectedTransformations []string
+	}{
+		{"2 * 3 + 1", "7", []string{
+			"fold: (2 * 3) => 6",
+			"fold: (6 + 1) => 7",
+		}},
+		{"v[1 + 1].x", "((v[2]).x)", []string{
+			"fold: (1 + 1) => 2",
+		}},
+		{"[1 + 1, {2 * 2: !true}]", "[2, {4: false}]", []string{
+			"fold: (1 + 1) => 2",
+			"fold: (2 * 2) => 4",
+			"fold: (!true) => false",
+		}},
+		{"-5 - -5", "0", []string{
+			"fold: (-5) => -5",
+			"fold: (-5) => -5",
+			"fold: (-5 - -5) => 0",
+		}},
+		{"!true", "false", []string{"fold: (!true) => false"}},
+		{"!!5", "true", []string{"fold: (!5) => false", "fold: (!false) => true"}},
+		{"1 < 2 == true", "true", []string{
+			"fold: (1 < 2) => true",
+			"fold: (true == true) => true",
+		}},
+		{"if (true) { a } else { b }", "a", []string{
+			"branch: iftrue aelse b => a",
+		}},
+		{"if (1 > 2) { a } else { b }", "b", []string{
+			"fold: (1 > 2) => false",
+			"branch: iffalse aelse b => b",
+		}},
+		{"true ? 1 + 1 : b", "2", []string{
+			"fold: (1 + 1) => 2",
+			"branch: (true ? 2 : b) => 2",
+		}},
+		{"(2 * -a) + 0", "(2 * (-a))", []string{"simplify: ((2 * (-a)) + 0) => (2 * (-a))"}},
+		{"1 * -a", "(-a)", []string{"simplify: (1 * (-a)) => (-a)"}},
+		{"!!(1 < -a)", "(1 < (-a))", []string{"simplify: (!(!(1 < (-a)))) => (1 < (-a))"}},
+		{"!!!a", "(!a)", []string{"simplify: (!(!(!a))) => (!a)"}},
+		{"let f = fn(x) { x * (2 + 2) }", "let f = fn(x) (x * 4);", []string{
+			"fold: (2 + 2) => 4",
+		}},
+		// Operations that may raise errors are kept.
+		{"1 / 0", "(1 / 0)", nil},
+		{"a + 0", "(a + 0)", nil},
+		{"1 + true", "(1 + true)", nil},
+		{"true + false", "(true + false)", nil},
+		{"!!a", "(!(!a))", nil},
+		// Operands that may define the operator keep it.
+		{"(v + 1) + 0", "((v + 1) + 0)", nil},
+		{"0 + v * 2", "(0 + (v * 2))", nil},
+		{`("a" + "b") + 0`, "((a + b) + 0)", nil},
+		{"!!(a == b)", "(!(!(a == b)))", nil},
+		{"!!(a < 1)", "(!(!(a < 1)))", nil},
+		{"-true", "(-true)", nil},
+		// Branches that aren't a single expression are kept.
+		{"if (false) { a }", "iffalse a", nil},
+		{"if (true) { let a = 1; a }", "iftrue let a = 1;a", nil},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		transformations := Optimize(program)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input,
+				tt.expected, program.String())
+		}
+
+		if len(transformations) != len(tt.expectedTransformations) {
+			t.Errorf("wrong transformations for %q. want=%q, got=%q", tt.input,
+				tt.expectedTransformations, transformations)
+			continue
+		}
+
+		for i, expected := range tt.expectedTransformations {
+			if transformations[i].String() != expected {
+				t.Errorf("wrong transformation %d for %q. want=%q, got=%q", i,
+					tt.input, expected, transformations[i].String())
+			}
+		}
+	}
+}
+
+// TestOptimizeAgainstEvaluator checks that optimized programs evaluate to the
+// same value as the original ones.
+func TestOptimizeAgainstEvaluator(t *testing.T) {
+	tests := []string{
+		"(5 + 10 * 2 + 15 / 3) * 2 + -10",
+		"let x = 3; x * (4 - 3) + 0 * 1",
+		"let x = 3; !!(x > 2) == true",
+		"if (10 > 20) { 1 } else { 2 * 21 }",
+		"let f = fn(x) { if (true) { x + 1 - 1 } }; f(41)",
+		"1 / (3 - 3)",
+		"let x = true; x + 0",
+		"let x = true; 1 * x",
+		"let b = fn() { true }; !!b()",
+		"-(1 + 1) == 0 - 2",
+		"match (2 * 3) { 6 => 1 + 1, _ => 0 }",
+		"switch (1 + 1) { case 2 - 0: 10 * 10 default: 0 }",
+		"try { throw 2 * 3 } catch (e) { e }",
+		"false ? 1 / 0 : 5 - 5",
+		`[1 + 1, {"a" + "b": 2 * 3}]`,
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(parse(t, input), object.NewEnvironment())
+
+		program := parse(t, input)
+		Optimize(program)
+		actual := evaluator.Eval(program, object.NewEnvironment())
+
+		if expected.Inspect() != actual.Inspect() {
+			t.Errorf("optimized %q evaluated differently. want=%s, got=%s",
+				input, expected.Inspect(), actual.Inspect())
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %q", input, p.Errors())
+	}
+
+	return program
+}
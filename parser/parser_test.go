@@ -397,6 +397,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"-a.b * c[d + 1]",
+			"((-(a.b)) * (c[(d + 1)]))",
+		},
+		{
+			"a.b.c(d)[e]",
+			"(((a.b).c)(d)[e])",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -489,6 +497,26 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a < b ? a + 1 : b * 2",
+			"((a < b) ? (a + 1) : (b * 2))",
+		},
+		{
+			"a ? b : c ? d : e",
+			"(a ? b : (c ? d : e))",
+		},
+		{
+			"a ? b ? c : d : e",
+			"(a ? (b ? c : d) : e)",
+		},
+		{
+			"(a ? b : c) ? d : e",
+			"((a ? b : c) ? d : e)",
+		},
+		{
+			"a == b ? -c : add(d)",
+			"((a == b) ? (-c) : add(d))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -726,6 +754,34 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestFunctionParameterErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"fn(a, a) { a }", "duplicate parameter a"},
+		{"fn(a, b, c, b) { a }", "duplicate parameter b"},
+		{"fn(a, b) { fn(b, b) { a } }", "duplicate parameter b"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("expected one parser error for %q. got=%q", tt.input, errors)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input,
+				tt.expectedError, errors[0])
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -850,3 +906,603 @@ func TestLetStatements(t *testing.T) {
 		}
 	}
 }
+
+func TestMatchExpression(t *testing.T) {
+	input := `match (x) { 1 => 10, -2 => y, true => 20, n if n > 5 => n, _ => 0, }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T",
+			stmt.Expression)
+	}
+
+	if !testIdentifier(t, exp.Subject, "x") {
+		return
+	}
+
+	if len(exp.Arms) != 5 {
+		t.Fatalf("exp.Arms does not contain 5 arms. got=%d", len(exp.Arms))
+	}
+
+	testLiteralExpression(t, exp.Arms[0].Pattern, 1)
+	testLiteralExpression(t, exp.Arms[0].Body, 10)
+
+	if exp.Arms[1].Pattern.String() != "(-2)" {
+		t.Errorf("exp.Arms[1].Pattern wrong. want=%q, got=%q", "(-2)",
+			exp.Arms[1].Pattern.String())
+	}
+	testLiteralExpression(t, exp.Arms[1].Body, "y")
+
+	testLiteralExpression(t, exp.Arms[2].Pattern, true)
+	testLiteralExpression(t, exp.Arms[2].Body, 20)
+
+	testLiteralExpression(t, exp.Arms[3].Pattern, "n")
+	testInfixExpression(t, exp.Arms[3].Guard, "n", ">", 5)
+	testLiteralExpression(t, exp.Arms[3].Body, "n")
+
+	testLiteralExpression(t, exp.Arms[4].Pattern, "_")
+	if exp.Arms[4].Guard != nil {
+		t.Errorf("exp.Arms[4].Guard was not nil. got=%+v", exp.Arms[4].Guard)
+	}
+
+	expected := "match(x) { 1 => 10, (-2) => y, true => 20, n if (n > 5) => n, _ => 0 }"
+	if exp.String() != expected {
+		t.Errorf("exp.String() wrong. want=%q, got=%q", expected, exp.String())
+	}
+}
+
+func TestMatchPatterns(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { [] => 0 }", "[]"},
+		{"match (x) { [a, 1, _] => 0 }", "[a, 1, _]"},
+		{"match (x) { [head, ..tail] => 0 }", "[head, ..tail]"},
+		{"match (x) { [..rest] => 0 }", "[..rest]"},
+		{"match (x) { [[a], {1: b}] => 0 }", "[[a], {1: b}]"},
+		{`match (x) { {"name": n, -1: true, false: [_]} => 0 }`,
+			"{name: n, (-1): true, false: [_]}"},
+		{"match (x) { {} => 0 }", "{}"},
+		{`match (x) { "s" => 0 }`, "s"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp := stmt.Expression.(*ast.MatchExpression)
+		if got := exp.Arms[0].Pattern.String(); got != tt.expected {
+			t.Errorf("wrong pattern for %q. want=%q, got=%q", tt.input,
+				tt.expected, got)
+		}
+	}
+
+	l := lexer.New("match (x) { [a, ..b] => 0 }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	arm := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression).Arms[0]
+	pattern, ok := arm.Pattern.(*ast.ArrayPattern)
+	if !ok {
+		t.Fatalf("pattern is not ast.ArrayPattern. got=%T", arm.Pattern)
+	}
+	if len(pattern.Elements) != 1 || pattern.Rest == nil || pattern.Rest.Value != "b" {
+		t.Errorf("wrong array pattern. got=%+v", pattern)
+	}
+}
+
+func TestMatchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"match (x) { 1 + 2 => 3 }", "expected next token to be =>, got + instead"},
+		{"match (x) { fn => 3 }", "invalid match pattern FUNCTION"},
+		{"match (x) { 1 => 3 2 => 4 }", "expected next token to be }, got INT instead"},
+		{"match (x) { [..a, b] => 3 }", "expected next token to be ], got , instead"},
+		{"match (x) { [..1] => 3 }", "expected next token to be IDENT, got INT instead"},
+		{"match (x) { [a b] => 3 }", "expected next token to be ,, got IDENT instead"},
+		{"match (x) { {a: 1} => 3 }", "invalid match pattern IDENT"},
+		{`match (x) { {"a"} => 3 }`, "expected next token to be :, got } instead"},
+		{"match (x) { [1 + 2] => 3 }", "expected next token to be ,, got + instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q. got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input,
+				tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestSwitchExpression(t *testing.T) {
+	input := `
+switch (x) {
+case 1, 2:
+  let y = x;
+  y * 2;
+default:
+  0
+case 3:
+  x
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.SwitchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.SwitchExpression. got=%T",
+			stmt.Expression)
+	}
+
+	if !testIdentifier(t, exp.Subject, "x") {
+		return
+	}
+
+	if len(exp.Cases) != 2 {
+		t.Fatalf("exp.Cases does not contain 2 cases. got=%d", len(exp.Cases))
+	}
+
+	if len(exp.Cases[0].Values) != 2 {
+		t.Fatalf("exp.Cases[0].Values does not contain 2 values. got=%d",
+			len(exp.Cases[0].Values))
+	}
+	testLiteralExpression(t, exp.Cases[0].Values[0], 1)
+	testLiteralExpression(t, exp.Cases[0].Values[1], 2)
+
+	if len(exp.Cases[0].Body.Statements) != 2 {
+		t.Fatalf("exp.Cases[0].Body does not contain 2 statements. got=%d",
+			len(exp.Cases[0].Body.Statements))
+	}
+	testLetStatement(t, exp.Cases[0].Body.Statements[0], "y")
+
+	if exp.Default == nil || len(exp.Default.Statements) != 1 {
+		t.Fatalf("exp.Default does not contain 1 statement. got=%+v", exp.Default)
+	}
+
+	expected := "switch(x) { case 1, 2: let y = x;(y * 2) case 3: x default: 0 }"
+	if exp.String() != expected {
+		t.Errorf("exp.String() wrong. want=%q, got=%q", expected, exp.String())
+	}
+}
+
+func TestSwitchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"switch (x) { default: 1 default: 2 }", "duplicate default case in switch"},
+		{"switch (x) { 1: 2 }", "expected case or default in switch, got INT instead"},
+		{"switch (x) { case 1 2 }", "expected next token to be :, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q. got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input,
+				tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	input := "throw 5 + x;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.TokenLiteral() != "throw" {
+		t.Errorf("stmt.TokenLiteral not 'throw'. got=%q", stmt.TokenLiteral())
+	}
+
+	testInfixExpression(t, stmt.Value, 5, "+", "x")
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedCatch   string
+		expectedFinally bool
+		expectedString  string
+	}{
+		{"try { x } catch (e) { e }", "e", false, "try xcatch(e) e"},
+		{"try { x } finally { y }", "", true, "try xfinally y"},
+		{"try { x } catch (err) { y } finally { z }", "err", true,
+			"try xcatch(err) yfinally z"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T",
+				stmt.Expression)
+		}
+
+		if len(exp.Block.Statements) != 1 {
+			t.Errorf("exp.Block does not contain 1 statement. got=%d",
+				len(exp.Block.Statements))
+		}
+
+		if tt.expectedCatch == "" {
+			if exp.Catch != nil {
+				t.Errorf("exp.Catch was not nil. got=%+v", exp.Catch)
+			}
+		} else {
+			testIdentifier(t, exp.CatchParameter, tt.expectedCatch)
+		}
+
+		if (exp.Finally != nil) != tt.expectedFinally {
+			t.Errorf("exp.Finally wrong. got=%+v", exp.Finally)
+		}
+
+		if exp.String() != tt.expectedString {
+			t.Errorf("exp.String() wrong. want=%q, got=%q", tt.expectedString,
+				exp.String())
+		}
+	}
+}
+
+func TestTryExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"try { x }", "expected catch or finally after try block"},
+		{"try { x } catch { y }", "expected next token to be (, got { instead"},
+		{"try { x } catch (1) { y }", "expected next token to be IDENT, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q. got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input,
+				tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestDeferStatement(t *testing.T) {
+	input := "fn() { defer close(x); x }"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	function := stmt.Expression.(*ast.FunctionLiteral)
+
+	if len(function.Body.Statements) != 2 {
+		t.Fatalf("function.Body.Statements has not 2 statements. got=%d",
+			len(function.Body.Statements))
+	}
+
+	deferStmt, ok := function.Body.Statements[0].(*ast.DeferStatement)
+	if !ok {
+		t.Fatalf("function.Body.Statements[0] is not ast.DeferStatement. got=%T",
+			function.Body.Statements[0])
+	}
+
+	if deferStmt.String() != "defer close(x);" {
+		t.Errorf("deferStmt.String() wrong. got=%q", deferStmt.String())
+	}
+
+	if _, ok := deferStmt.Expression.(*ast.CallExpression); !ok {
+		t.Errorf("deferStmt.Expression is not ast.CallExpression. got=%T",
+			deferStmt.Expression)
+	}
+}
+
+func TestDeferStatementOutsideFunction(t *testing.T) {
+	tests := []string{
+		"defer close(x);",
+		"if (true) { defer close(x); }",
+		"let f = fn() { 1 }; defer close(x);",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("expected 1 parser error for %q. got=%q", input, errors)
+			continue
+		}
+
+		if errors[0] != "defer statement outside of function body" {
+			t.Errorf("wrong error for %q. got=%q", input, errors[0])
+		}
+	}
+}
+
+func TestTernaryExpression(t *testing.T) {
+	input := "x < y ? x : y"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.TernaryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TernaryExpression. got=%T",
+			stmt.Expression)
+	}
+
+	testInfixExpression(t, exp.Condition, "x", "<", "y")
+	testIdentifier(t, exp.Consequence, "x")
+	testIdentifier(t, exp.Alternative, "y")
+
+	// The string representation parses back to the same expression.
+	l = lexer.New(exp.String())
+	p = New(l)
+	reparsed := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if reparsed.String() != exp.String() {
+		t.Errorf("round trip wrong. want=%q, got=%q", exp.String(),
+			reparsed.String())
+	}
+}
+
+func TestTernaryExpressionErrors(t *testing.T) {
+	l := lexer.New("a ? b c")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	expected := "expected next token to be :, got IDENT instead"
+	if len(errors) == 0 || errors[0] != expected {
+		t.Errorf("wrong errors. want=%q, got=%q", expected, errors)
+	}
+}
+
+func TestDotExpression(t *testing.T) {
+	input := "vector.length"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.DotExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.DotExpression. got=%T",
+			stmt.Expression)
+	}
+
+	testIdentifier(t, exp.Left, "vector")
+	if exp.Name != "length" {
+		t.Errorf("exp.Name is not %q. got=%q", "length", exp.Name)
+	}
+}
+
+func TestIndexExpression(t *testing.T) {
+	input := "vector[1 + 1]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IndexExpression. got=%T",
+			stmt.Expression)
+	}
+
+	testIdentifier(t, exp.Left, "vector")
+	testInfixExpression(t, exp.Index, 1, "+", 1)
+}
+
+func TestDotAndIndexExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a.1", "expected next token to be IDENT, got INT instead"},
+		{"a[1", "expected next token to be ], got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input,
+				tt.expected, errors)
+		}
+	}
+}
+
+func TestStringLiteralExpression(t *testing.T) {
+	input := `"hello world";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.StringLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if literal.Value != "hello world" {
+		t.Errorf("literal.Value is not %q. got=%q", "hello world", literal.Value)
+	}
+}
+
+func TestArrayLiteral(t *testing.T) {
+	input := "[1, 2 * 2, x]; []"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.ArrayLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(array.Elements) != 3 {
+		t.Fatalf("len(array.Elements) is not 3. got=%d", len(array.Elements))
+	}
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testIdentifier(t, array.Elements[2], "x")
+
+	empty := program.Statements[1].(*ast.ExpressionStatement).Expression
+	if array, ok := empty.(*ast.ArrayLiteral); !ok || len(array.Elements) != 0 {
+		t.Errorf("second statement is not an empty array. got=%s", empty)
+	}
+}
+
+func TestHashLiteral(t *testing.T) {
+	input := `{"one": 1, two: 1 + 1, 3: true}; {}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.HashLiteral. got=%T",
+			stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("len(hash.Pairs) is not 3. got=%d", len(hash.Pairs))
+	}
+	if key, ok := hash.Pairs[0].Key.(*ast.StringLiteral); !ok || key.Value != "one" {
+		t.Errorf("first key is not %q. got=%s", "one", hash.Pairs[0].Key)
+	}
+	testIntegerLiteral(t, hash.Pairs[0].Value, 1)
+	testIdentifier(t, hash.Pairs[1].Key, "two")
+	testInfixExpression(t, hash.Pairs[1].Value, 1, "+", 1)
+	testIntegerLiteral(t, hash.Pairs[2].Key, 3)
+	testLiteralExpression(t, hash.Pairs[2].Value, true)
+
+	empty := program.Statements[1].(*ast.ExpressionStatement).Expression
+	if hash, ok := empty.(*ast.HashLiteral); !ok || len(hash.Pairs) != 0 {
+		t.Errorf("second statement is not an empty hash. got=%s", empty)
+	}
+}
+
+func TestLiteralErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2", "expected next token to be ], got EOF instead"},
+		{`{"a" 1}`, "expected next token to be :, got INT instead"},
+		{`{"a": 1 "b": 2}`, "expected next token to be ,, got STRING instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 || errors[0] != tt.expected {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input,
+				tt.expected, errors)
+		}
+	}
+}
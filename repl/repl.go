@@ -1,37 +1,59 @@
 package repl
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"monkey/evaluator"
 	"monkey/lexer"
+	"monkey/object"
 	"monkey/parser"
+	"monkey/resolver"
+	"monkey/snapshot"
+	"os"
+	"strings"
 )
 
 // PROMPT is printed at the beginning of every line
 const PROMPT = ">>"
 
-// Start is the main loop to run the repl
+// Start is the main loop to run the repl.  Programs read their input from in
+// and write their output to out, like the repl itself.
+//
+// Besides programs, the repl runs these commands:
+//
+//	:save FILE      saves the global variables to FILE
+//	:restore FILE   restores the global variables saved in FILE
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	streams := object.NewStreams(in, out)
+	env := object.NewEnvironment()
+	env.SetStreams(streams)
+	r := resolver.New()
+	env.SetSlotNames(r)
 
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := streams.ReadLine()
+		if err != nil && (err != io.EOF || line == "") {
 			// Without this fmt.Println(), the terminal prompt stays on the same
 			// line as the repl prompt when exiting using ctrl+d or ctrl+c.
 			fmt.Println()
 			return
 		}
 
-		line := scanner.Text()
 		if line == "exit" {
 			// No need for fmt.Println() here.
 			return
 		}
 
+		if strings.HasPrefix(line, ":") {
+			if err := runCommand(line, env); err != nil {
+				printParserErrors(out, []string{err.Error()})
+			}
+			continue
+		}
+
 		l := lexer.New(line)
 		p := parser.New(l)
 
@@ -41,7 +63,8 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		evaluated := evaluator.Eval(program)
+		r.Resolve(program)
+		evaluated := evaluator.Eval(program, env)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -49,6 +72,30 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
+// runCommand runs a repl command on the global variables of env.
+func runCommand(line string, env *object.Environment) error {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || (fields[0] != ":save" && fields[0] != ":restore") {
+		return fmt.Errorf("unknown command %s, want :save FILE or :restore FILE", line)
+	}
+
+	if fields[0] == ":save" {
+		// Save to a buffer first, so that a failed save leaves no file behind.
+		var buf bytes.Buffer
+		if err := snapshot.Save(&buf, env); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(fields[1], buf.Bytes(), 0644)
+	}
+
+	f, err := os.Open(fields[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return snapshot.Restore(f, env)
+}
+
 func printParserErrors(out io.Writer, errors []string) {
 	for _, msg := range errors {
 		io.WriteString(out, "\t"+msg+"\n")
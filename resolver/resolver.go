@@ -0,0 +1,384 @@
+/*
+Package resolver binds the identifiers of a Monkey program to variable slots
+before it is evaluated.
+
+To resolve:
+
+	r := New()
+	r.Resolve(program)
+
+Most identifiers of a resolved program are bound to slots: their Depth and
+Index fields locate their variables among the slots of the environments that
+the evaluator creates, and the evaluator uses them instead of looking the
+identifiers up by name.  A Resolver keeps the global variables of the programs
+it resolved, so successive programs can be resolved and evaluated in the same
+environment.  It also names the slots of the global variables, so that they
+can be looked up and bound by name in that environment:
+
+	env.SetSlotNames(r)
+
+An identifier that no enclosing scope binds at its position is left
+unresolved, and the evaluator looks it up by name when it runs, like it does
+in programs that weren't resolved.  This happens to builtins, to global
+variables that a later program defines, and to names used before the let
+statement that binds them in the same scope: until then, the name refers to
+the variable of an enclosing scope, if any.  A function may refer to a
+variable bound later in an enclosing scope, since it is usually called after
+the binding.
+*/
+package resolver
+
+import (
+	"monkey/ast"
+	"sort"
+)
+
+// scope corresponds to an environment created by the evaluator: the global
+// environment, a function call, a match arm or a catch block.
+type scope struct {
+	outer    *scope
+	function bool
+
+	// slots holds the slot of every name bound in the scope, including the
+	// ones whose let statement hasn't been resolved yet.  defined holds the
+	// names that are bound at the current position.
+	slots   map[string]int
+	defined map[string]bool
+}
+
+func newScope(outer *scope, function bool) *scope {
+	return &scope{
+		outer:    outer,
+		function: function,
+		slots:    make(map[string]int),
+		defined:  make(map[string]bool),
+	}
+}
+
+// declare reserves a slot for name, reusing its slot if it has one.
+func (s *scope) declare(name string) int {
+	if index, ok := s.slots[name]; ok {
+		return index
+	}
+
+	index := len(s.slots)
+	s.slots[name] = index
+	return index
+}
+
+// Resolver resolves programs.
+type Resolver struct {
+	global *scope
+}
+
+// New returns a reference to a new Resolver.
+func New() *Resolver {
+	return &Resolver{global: newScope(nil, false)}
+}
+
+// Resolve resolves the identifiers of program.
+func (r *Resolver) Resolve(program *ast.Program) {
+	r.declareStatements(r.global, program.Statements)
+	r.statements(r.global, program.Statements)
+}
+
+// Slot returns the slot of the global variable name and reports whether it has
+// one.
+func (r *Resolver) Slot(name string) (int, bool) {
+	index, ok := r.global.slots[name]
+	return index, ok
+}
+
+// Define defines the global variable name, like a let statement, and returns
+// its slot.
+func (r *Resolver) Define(name string) int {
+	index := r.global.declare(name)
+	r.global.defined[name] = true
+	return index
+}
+
+// Names returns the sorted names of the global variables.
+func (r *Resolver) Names() []string {
+	names := make([]string, 0, len(r.global.slots))
+	for name := range r.global.slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// declareStatements reserves slots for the names bound by let statements in
+// s, including the ones in blocks, which don't have scopes of their own.
+func (r *Resolver) declareStatements(s *scope, statements []ast.Statement) {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *ast.LetStatement:
+			s.declare(statement.Name.Value)
+			r.declareExpression(s, statement.Value)
+		case *ast.ExpressionStatement:
+			r.declareExpression(s, statement.Expression)
+		case *ast.ReturnStatement:
+			r.declareExpression(s, statement.ReturnValue)
+		case *ast.ThrowStatement:
+			r.declareExpression(s, statement.Value)
+		case *ast.DeferStatement:
+			r.declareExpression(s, statement.Expression)
+		case *ast.BlockStatement:
+			r.declareBlock(s, statement)
+		}
+	}
+}
+
+func (r *Resolver) declareBlock(s *scope, block *ast.BlockStatement) {
+	if block != nil {
+		r.declareStatements(s, block.Statements)
+	}
+}
+
+// declareExpression reserves slots for the names bound in the blocks of e that
+// share the scope of e.  Function literals, match arms and catch blocks have
+// scopes of their own.
+func (r *Resolver) declareExpression(s *scope, e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		r.declareExpression(s, e.Right)
+	case *ast.InfixExpression:
+		r.declareExpression(s, e.Left)
+		r.declareExpression(s, e.Right)
+	case *ast.IfExpression:
+		r.declareExpression(s, e.Condition)
+		r.declareBlock(s, e.Consequence)
+		r.declareBlock(s, e.Alternative)
+	case *ast.TernaryExpression:
+		r.declareExpression(s, e.Condition)
+		r.declareExpression(s, e.Consequence)
+		r.declareExpression(s, e.Alternative)
+	case *ast.CallExpression:
+		r.declareExpression(s, e.Function)
+		for _, arg := range e.Arguments {
+			r.declareExpression(s, arg)
+		}
+	case *ast.DotExpression:
+		r.declareExpression(s, e.Left)
+	case *ast.IndexExpression:
+		r.declareExpression(s, e.Left)
+		r.declareExpression(s, e.Index)
+	case *ast.ArrayLiteral:
+		for _, element := range e.Elements {
+			r.declareExpression(s, element)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range e.Pairs {
+			r.declareExpression(s, pair.Key)
+			r.declareExpression(s, pair.Value)
+		}
+	case *ast.MatchExpression:
+		r.declareExpression(s, e.Subject)
+	case *ast.SwitchExpression:
+		r.declareExpression(s, e.Subject)
+		for _, c := range e.Cases {
+			for _, v := range c.Values {
+				r.declareExpression(s, v)
+			}
+			r.declareBlock(s, c.Body)
+		}
+		r.declareBlock(s, e.Default)
+	case *ast.TryExpression:
+		r.declareBlock(s, e.Block)
+		r.declareBlock(s, e.Finally)
+	}
+}
+
+// statements resolves statements in the order they are evaluated.
+func (r *Resolver) statements(s *scope, statements []ast.Statement) {
+	for _, statement := range statements {
+		r.statement(s, statement)
+	}
+}
+
+func (r *Resolver) statement(s *scope, statement ast.Statement) {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		r.expression(s, statement.Value)
+		r.define(s, statement.Name)
+	case *ast.ExpressionStatement:
+		r.expression(s, statement.Expression)
+	case *ast.ReturnStatement:
+		r.expression(s, statement.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(s, statement.Value)
+	case *ast.DeferStatement:
+		r.expression(s, statement.Expression)
+	case *ast.BlockStatement:
+		r.block(s, statement)
+	}
+}
+
+func (r *Resolver) block(s *scope, block *ast.BlockStatement) {
+	if block != nil {
+		r.statements(s, block.Statements)
+	}
+}
+
+// define binds ident in s from now on.
+func (r *Resolver) define(s *scope, ident *ast.Identifier) {
+	ident.Resolved = true
+	ident.Depth = 0
+	ident.Index = s.declare(ident.Value)
+	s.defined[ident.Value] = true
+}
+
+// resolve binds ident to the innermost scope that binds its name at this
+// position.  Identifiers that no scope binds, such as builtins, are left
+// unresolved.
+func (r *Resolver) resolve(s *scope, ident *ast.Identifier) {
+	name := ident.Value
+
+	// crossed is true once the search leaves a function, whose body runs
+	// later than the code around it.
+	crossed := false
+
+	for depth := 0; s != nil; depth++ {
+		// Until its let statement, a name refers to the variable of an
+		// enclosing scope.
+		if index, ok := s.slots[name]; ok && (s.defined[name] || crossed) {
+			ident.Resolved = true
+			ident.Depth = depth
+			ident.Index = index
+			return
+		}
+
+		crossed = crossed || s.function
+		s = s.outer
+	}
+}
+
+func (r *Resolver) expression(s *scope, e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		r.resolve(s, e)
+
+	case *ast.PrefixExpression:
+		r.expression(s, e.Right)
+
+	case *ast.InfixExpression:
+		r.expression(s, e.Left)
+		r.expression(s, e.Right)
+
+	case *ast.IfExpression:
+		r.expression(s, e.Condition)
+		r.block(s, e.Consequence)
+		r.block(s, e.Alternative)
+
+	case *ast.TernaryExpression:
+		r.expression(s, e.Condition)
+		r.expression(s, e.Consequence)
+		r.expression(s, e.Alternative)
+
+	case *ast.FunctionLiteral:
+		r.function(s, e)
+
+	case *ast.CallExpression:
+		r.expression(s, e.Function)
+		for _, arg := range e.Arguments {
+			r.expression(s, arg)
+		}
+
+	case *ast.DotExpression:
+		// The name after the dot isn't a variable.
+		r.expression(s, e.Left)
+
+	case *ast.IndexExpression:
+		r.expression(s, e.Left)
+		r.expression(s, e.Index)
+
+	case *ast.ArrayLiteral:
+		for _, element := range e.Elements {
+			r.expression(s, element)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range e.Pairs {
+			r.expression(s, pair.Key)
+			r.expression(s, pair.Value)
+		}
+
+	case *ast.MatchExpression:
+		r.expression(s, e.Subject)
+		for _, arm := range e.Arms {
+			armScope := newScope(s, false)
+
+			r.pattern(armScope, arm.Pattern)
+
+			r.declareExpression(armScope, arm.Guard)
+			r.declareExpression(armScope, arm.Body)
+
+			if arm.Guard != nil {
+				r.expression(armScope, arm.Guard)
+			}
+			r.expression(armScope, arm.Body)
+		}
+
+	case *ast.SwitchExpression:
+		r.expression(s, e.Subject)
+		for _, c := range e.Cases {
+			for _, v := range c.Values {
+				r.expression(s, v)
+			}
+			r.block(s, c.Body)
+		}
+		r.block(s, e.Default)
+
+	case *ast.TryExpression:
+		r.block(s, e.Block)
+
+		if e.Catch != nil {
+			catchScope := newScope(s, false)
+			r.define(catchScope, e.CatchParameter)
+			r.declareBlock(catchScope, e.Catch)
+			r.block(catchScope, e.Catch)
+		}
+
+		r.block(s, e.Finally)
+	}
+}
+
+// pattern defines the identifiers bound by a match pattern in the scope of its
+// arm.
+func (r *Resolver) pattern(s *scope, pattern ast.Expression) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			r.define(s, pattern)
+		}
+
+	case *ast.ArrayPattern:
+		for _, element := range pattern.Elements {
+			r.pattern(s, element)
+		}
+		if pattern.Rest != nil {
+			r.pattern(s, pattern.Rest)
+		}
+
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			r.pattern(s, pair.Value)
+		}
+
+	default:
+		r.expression(s, pattern)
+	}
+}
+
+// function resolves a function literal in a scope of its own.  The parameters
+// take the first slots, in order.
+func (r *Resolver) function(s *scope, fn *ast.FunctionLiteral) {
+	fnScope := newScope(s, true)
+
+	for _, param := range fn.Parameters {
+		r.define(fnScope, param)
+	}
+
+	r.declareBlock(fnScope, fn.Body)
+	r.block(fnScope, fn.Body)
+}
@@ -0,0 +1,289 @@
+package resolver
+
+import (
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %q", input, p.Errors())
+	}
+
+	return program
+}
+
+// collectIdentifiers returns the identifiers of the program in the order they
+// appear in its string representation.
+func collectIdentifiers(node ast.Node) []*ast.Identifier {
+	var idents []*ast.Identifier
+
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.LetStatement:
+			walk(node.Name)
+			walk(node.Value)
+		case *ast.ExpressionStatement:
+			walk(node.Expression)
+		case *ast.ReturnStatement:
+			walk(node.ReturnValue)
+		case *ast.Identifier:
+			idents = append(idents, node)
+		case *ast.InfixExpression:
+			walk(node.Left)
+			walk(node.Right)
+		case *ast.FunctionLiteral:
+			for _, p := range node.Parameters {
+				walk(p)
+			}
+			walk(node.Body)
+		case *ast.CallExpression:
+			walk(node.Function)
+			for _, a := range node.Arguments {
+				walk(a)
+			}
+		case *ast.MatchExpression:
+			walk(node.Subject)
+			for _, arm := range node.Arms {
+				walk(arm.Pattern)
+				walk(arm.Body)
+			}
+		case *ast.TryExpression:
+			walk(node.Block)
+			walk(node.CatchParameter)
+			walk(node.Catch)
+		}
+	}
+
+	walk(node)
+	return idents
+}
+
+func TestResolve(t *testing.T) {
+	type binding struct {
+		name  string
+		depth int
+		index int
+	}
+
+	tests := []struct {
+		input    string
+		expected []binding
+	}{
+		{"let a = 1; let b = a; a + b", []binding{
+			{"a", 0, 0}, {"b", 0, 1}, {"a", 0, 0}, {"a", 0, 0}, {"b", 0, 1},
+		}},
+		{"let a = 1; let a = a + 1;", []binding{
+			{"a", 0, 0}, {"a", 0, 0}, {"a", 0, 0},
+		}},
+		{"let f = fn(x, y) { let z = x; fn() { z + y } }", []binding{
+			{"f", 0, 0}, {"x", 0, 0}, {"y", 0, 1}, {"z", 0, 2}, {"x", 0, 0},
+			{"z", 1, 2}, {"y", 1, 1},
+		}},
+		// Until the let statement, x is the global variable.
+		{"let x = 1; let f = fn() { let y = x; let x = 2; x }", []binding{
+			{"x", 0, 0}, {"f", 0, 1}, {"y", 0, 0}, {"x", 1, 0}, {"x", 0, 1},
+			{"x", 0, 1},
+		}},
+		// A function may refer to a variable bound later.
+		{"let f = fn() { g() }; let g = fn() { f() };", []binding{
+			{"f", 0, 0}, {"g", 1, 1}, {"g", 0, 1}, {"f", 1, 0},
+		}},
+		{"let x = 1; match (x) { y => y + x }", []binding{
+			{"x", 0, 0}, {"x", 0, 0}, {"y", 0, 0}, {"y", 0, 0}, {"x", 1, 0},
+		}},
+		{"let x = 1; try { x } catch (e) { e }", []binding{
+			{"x", 0, 0}, {"x", 0, 0}, {"e", 0, 0}, {"e", 0, 0},
+		}},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+
+		New().Resolve(program)
+
+		idents := collectIdentifiers(program)
+		if len(idents) != len(tt.expected) {
+			t.Errorf("wrong number of identifiers in %q. want=%d, got=%d",
+				tt.input, len(tt.expected), len(idents))
+			continue
+		}
+
+		for i, b := range tt.expected {
+			ident := idents[i]
+			if ident.Value != b.name || !ident.Resolved ||
+				ident.Depth != b.depth || ident.Index != b.index {
+				t.Errorf("wrong binding %d in %q. want=%+v, got=%+v", i,
+					tt.input, b, ident)
+			}
+		}
+	}
+}
+
+func TestResolveUnresolved(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"foobar", []string{"foobar"}},
+		{"len([])", []string{"len"}},
+		{"let f = fn() { y }", []string{"y"}},
+		{"x; let x = 1;", []string{"x"}},
+		{"let x = x + 1", []string{"x"}},
+		{"match (1) { n => y }; let y = 2", []string{"y"}},
+		{"try { 1 } catch (e) { 2 }; e", []string{"e"}},
+		{"match (1) { n => n }; n", []string{"n"}},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		New().Resolve(program)
+
+		var unresolved []string
+		for _, ident := range collectIdentifiers(program) {
+			if !ident.Resolved {
+				unresolved = append(unresolved, ident.Value)
+			}
+		}
+
+		if strings.Join(unresolved, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("wrong unresolved identifiers for %q. want=%q, got=%q",
+				tt.input, tt.expected, unresolved)
+		}
+	}
+}
+
+func TestResolveSuccessivePrograms(t *testing.T) {
+	r := New()
+	env := object.NewEnvironment()
+	env.SetSlotNames(r)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1", ""},
+		{"let f = fn() { let b = a; let a = 2; b }", ""},
+		{"f()", "1"},
+		{"let h = fn() { k }", ""},
+		{"let k = 5", ""},
+		{"h()", "5"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		r.Resolve(program)
+		result := evaluator.Eval(program, env)
+		if tt.expected != "" && result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input,
+				tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestSlotNames(t *testing.T) {
+	r := New()
+	env := object.NewEnvironment()
+	env.SetSlotNames(r)
+
+	program := parse(t, "let x = 1; let f = fn() { x + y };")
+	r.Resolve(program)
+	evaluator.Eval(program, env)
+
+	// y is defined by name and used by the programs resolved afterwards.
+	env.Set("y", &object.Integer{Value: 2})
+	program = parse(t, "let x = 1; let f = fn() { x + y }; f()")
+	r.Resolve(program)
+	if result := evaluator.Eval(program, env); result.Inspect() != "3" {
+		t.Errorf("wrong result. want=3, got=%s", result.Inspect())
+	}
+
+	if x, ok := env.Get("x"); !ok || x.Inspect() != "1" {
+		t.Errorf("x is not bound by name. got=%v", x)
+	}
+	if index, ok := r.Slot("y"); !ok || index != 2 {
+		t.Errorf("wrong slot for y. got=%d (%t)", index, ok)
+	}
+	if names := strings.Join(env.Names(), " "); names != "f x y" {
+		t.Errorf("wrong names. got=%q", names)
+	}
+}
+
+// TestResolvedEvaluation checks that resolved programs, which the evaluator
+// runs with slots, evaluate like programs that weren't resolved.
+func TestResolvedEvaluation(t *testing.T) {
+	tests := []string{
+		"let a = 5; let b = a * 2; let a = b + 1; a",
+		"let add = fn(a) { fn(b) { a + b } }; add(2)(3)",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+		"let f = fn() { let g = fn() { y }; let y = 2; g() }; f()",
+		"let f = fn() { g() }; let g = fn() { 5 }; f()",
+		"let f = fn() { g() }; f(); let g = fn() { 5 };",
+		"let x = 1; let f = fn() { if (true) { let x = 2; } x }; f() + x",
+		"let x = 1; match (5) { x => x }; x",
+		"match (5) { 1 => 10, n if n > 3 => n * 2, _ => 0 }",
+		"let x = 2; switch (x) { case 1: 10 case x: 20 }",
+		"let x = 1; try { throw 1 } catch (e) { let x = 2; e } finally { let x = x + 1 }; x",
+		"let fail = fn(x) { if (true) { throw x } }; let f = fn(x) { let y = x * 2; defer fail(y); 10 }; f(4)",
+		"let counter = fn(x) { fn(y) { fn(z) { x + y + z } } }; counter(1)(2)(3)",
+		"let r = random; r(1)",
+		"let random = fn(n) { n }; random(7)",
+		`let x = 1; let f = fn(list) { match (list) { [] => x, [h, ..t] => h + f(t), {"a": [y]} => y } }; f([1, 2]) + f({"a": [5]})`,
+		`let k = "b"; let f = fn(x) { [x, {"a": x, k: x * 2}] }; f(3)[1][k] + len(f(1))`,
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(parse(t, input), object.NewEnvironment())
+
+		program := parse(t, input)
+		New().Resolve(program)
+		actual := evaluator.Eval(program, object.NewEnvironment())
+
+		if expected.Inspect() != actual.Inspect() {
+			t.Errorf("resolved %q evaluated differently. want=%s, got=%s",
+				input, expected.Inspect(), actual.Inspect())
+		}
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	input := `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(20)`
+
+	l := lexer.New(input)
+	program := parser.New(l).ParseProgram()
+
+	b.Run("Unresolved", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			evaluator.Eval(program, object.NewEnvironment())
+		}
+	})
+
+	New().Resolve(program)
+
+	b.Run("Resolved", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			evaluator.Eval(program, object.NewEnvironment())
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package snapshot
+
+import "monkey/ast"
+
+// outerSlots calls visit for every identifier of node that the resolver bound
+// to a slot outside the function call that node is evaluated in.  level is the
+// number of environments that node is nested in within the call: match arms,
+// catch blocks and function literals have environments of their own.  visit
+// gets the number of environments between the environment of the function and
+// the one holding the slot.
+func outerSlots(node ast.Node, level int, visit func(ident *ast.Identifier, out int)) {
+	walk := func(node ast.Node) {
+		outerSlots(node, level, visit)
+	}
+	nested := func(node ast.Node) {
+		outerSlots(node, level+1, visit)
+	}
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if node != nil && node.Resolved && node.Depth > level {
+			visit(node, node.Depth-level-1)
+		}
+
+	case *ast.LetStatement:
+		walk(node.Value)
+
+	case *ast.ReturnStatement:
+		walk(node.ReturnValue)
+
+	case *ast.ThrowStatement:
+		walk(node.Value)
+
+	case *ast.DeferStatement:
+		walk(node.Expression)
+
+	case *ast.ExpressionStatement:
+		walk(node.Expression)
+
+	case *ast.BlockStatement:
+		if node != nil {
+			for _, statement := range node.Statements {
+				walk(statement)
+			}
+		}
+
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			walk(element)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			walk(pair.Key)
+			walk(pair.Value)
+		}
+
+	case *ast.PrefixExpression:
+		walk(node.Right)
+
+	case *ast.InfixExpression:
+		walk(node.Left)
+		walk(node.Right)
+
+	case *ast.IfExpression:
+		walk(node.Condition)
+		walk(node.Consequence)
+		walk(node.Alternative)
+
+	case *ast.TernaryExpression:
+		walk(node.Condition)
+		walk(node.Consequence)
+		walk(node.Alternative)
+
+	case *ast.FunctionLiteral:
+		nested(node.Body)
+
+	case *ast.CallExpression:
+		walk(node.Function)
+		for _, arg := range node.Arguments {
+			walk(arg)
+		}
+
+	case *ast.DotExpression:
+		walk(node.Left)
+
+	case *ast.IndexExpression:
+		walk(node.Left)
+		walk(node.Index)
+
+	case *ast.MatchExpression:
+		walk(node.Subject)
+		for _, arm := range node.Arms {
+			nested(arm.Pattern)
+			nested(arm.Guard)
+			nested(arm.Body)
+		}
+
+	case *ast.ArrayPattern:
+		for _, element := range node.Elements {
+			walk(element)
+		}
+
+	case *ast.HashPattern:
+		for _, pair := range node.Pairs {
+			walk(pair.Value)
+		}
+
+	case *ast.SwitchExpression:
+		walk(node.Subject)
+		for _, c := range node.Cases {
+			for _, v := range c.Values {
+				walk(v)
+			}
+			walk(c.Body)
+		}
+		walk(node.Default)
+
+	case *ast.TryExpression:
+		walk(node.Block)
+		nested(node.Catch)
+		walk(node.Finally)
+	}
+}
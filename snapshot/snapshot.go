@@ -0,0 +1,493 @@
+/*
+Package snapshot saves the global variables of a Monkey environment to a file
+and restores them into another environment, possibly in another process.
+
+To save and restore:
+
+	err := snapshot.Save(w, env)
+	err = snapshot.Restore(r, object.NewEnvironment())
+
+A snapshot is a JSON document:
+
+	{
+	  "format": "monkey-snapshot",
+	  "version": 1,
+	  "globals": {"name": value, ...},
+	  "environments": [{"outer": -1, "values": {"name": value, ...}}, ...]
+	}
+
+Values are integers, booleans, strings, arrays, hashes, null, exceptions caught
+by try expressions and functions.  A function is saved as its source code and
+the index of the environment it was defined in, where -1 is the global
+environment.  The other environments are those of the function calls and
+blocks that closures were created in, listed after their outer environment.
+Closures that share an environment still share it once restored.
+
+The global variables of an environment whose programs are resolved are saved
+and restored through the names of its slots, which the resolver gives it:
+
+	env.SetSlotNames(r)
+
+The slots of function calls and blocks have no names, so of their variables
+only the ones that the resolved identifiers of saved functions refer to are
+saved, under the names of those identifiers.
+
+Builtins, host objects and global variables bound to slots without names can't
+be saved, and Save reports them with an error wrapping ErrNotSerializable.
+*/
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"sort"
+)
+
+// Format identifies snapshots and Version is the version of the format that
+// Save writes.
+const (
+	Format  = "monkey-snapshot"
+	Version = 1
+)
+
+// Errors returned by Save for values it can't save and by Restore for files
+// that aren't valid snapshots.
+var (
+	ErrNotSerializable    = errors.New("value can't be saved")
+	ErrBadFormat          = errors.New("not a Monkey snapshot")
+	ErrUnsupportedVersion = errors.New("unsupported snapshot version")
+	ErrCorrupt            = errors.New("corrupt snapshot")
+)
+
+// global is the index of the global environment in snapshots.
+const global = -1
+
+type file struct {
+	Format       string            `json:"format"`
+	Version      int               `json:"version"`
+	Globals      map[string]*value `json:"globals"`
+	Environments []*environment    `json:"environments"`
+}
+
+type environment struct {
+	Outer  int               `json:"outer"`
+	Values map[string]*value `json:"values"`
+}
+
+// value is a saved object.  Type tells which of the other fields are used.
+type value struct {
+	Type    object.ObjectType `json:"type"`
+	Integer int64             `json:"integer,omitempty"`
+	Boolean bool              `json:"boolean,omitempty"`
+	String  string            `json:"string,omitempty"`
+	Items   []*value          `json:"items,omitempty"`
+	Pairs   []*pair           `json:"pairs,omitempty"`
+	Source  string            `json:"source,omitempty"`
+	Env     int               `json:"env,omitempty"`
+	Error   *errorValue       `json:"error,omitempty"`
+}
+
+type pair struct {
+	Key   *value `json:"key"`
+	Value *value `json:"value"`
+}
+
+type errorValue struct {
+	Kind    string   `json:"kind"`
+	Message string   `json:"message"`
+	Stack   []string `json:"stack,omitempty"`
+	Value   *value   `json:"value,omitempty"`
+}
+
+// Save writes the global variables of env to w.  It fails without writing
+// anything if a variable, or a variable of an environment that a function
+// refers to, can't be saved.
+func Save(w io.Writer, env *object.Environment) error {
+	if env.HasSlots() && env.SlotNames() == nil {
+		return fmt.Errorf("%w: global variables are resolved", ErrNotSerializable)
+	}
+
+	f := &file{Format: Format, Version: Version, Environments: []*environment{}}
+	s := &saver{root: env, file: f, indexes: map[*object.Environment]int{}}
+
+	f.Globals = map[string]*value{}
+	for _, name := range env.Names() {
+		obj, _ := env.Get(name)
+		v, err := s.value(obj)
+		if err != nil {
+			return fmt.Errorf("variable %s: %w", name, err)
+		}
+		f.Globals[name] = v
+	}
+
+	// Saving a value can list more environments and variables to save.
+	for len(s.pending) != 0 {
+		p := s.pending[0]
+		s.pending = s.pending[1:]
+
+		v, err := s.value(p.obj)
+		if err != nil {
+			return fmt.Errorf("closure variable %s: %w", p.name, err)
+		}
+		f.Environments[p.env].Values[p.name] = v
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// saver numbers the environments of the saved functions in the order they are
+// found, outer environments first.
+type saver struct {
+	root    *object.Environment
+	file    *file
+	indexes map[*object.Environment]int
+
+	// pending holds the variables of the listed environments that are still
+	// to be saved.
+	pending []variable
+}
+
+// variable is a variable of the environment listed at index env.
+type variable struct {
+	env  int
+	name string
+	obj  object.Object
+}
+
+// add adds a variable of the environment listed at index to the variables to
+// save, unless it was added already.
+func (s *saver) add(index int, name string, obj object.Object) {
+	values := s.file.Environments[index].Values
+	if _, ok := values[name]; ok {
+		return
+	}
+	// Save replaces the nil value once it has saved obj.
+	values[name] = nil
+	s.pending = append(s.pending, variable{env: index, name: name, obj: obj})
+}
+
+func (s *saver) value(obj object.Object) (*value, error) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return &value{Type: object.INTEGER_OBJ, Integer: obj.Value}, nil
+
+	case *object.Boolean:
+		return &value{Type: object.BOOLEAN_OBJ, Boolean: obj.Value}, nil
+
+	case *object.Null:
+		return &value{Type: object.NULL_OBJ}, nil
+
+	case *object.String:
+		return &value{Type: object.STRING_OBJ, String: obj.Value}, nil
+
+	case *object.Array:
+		v := &value{Type: object.ARRAY_OBJ}
+		for _, element := range obj.Elements {
+			item, err := s.value(element)
+			if err != nil {
+				return nil, err
+			}
+			v.Items = append(v.Items, item)
+		}
+		return v, nil
+
+	case *object.Hash:
+		// Sort the pairs so that saving the same hash gives the same file.
+		keys := make([]object.HashKey, 0, len(obj.Pairs))
+		for key := range obj.Pairs {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := keys[i], keys[j]
+			if a.Type != b.Type {
+				return a.Type < b.Type
+			}
+			if a.Value != b.Value {
+				return a.Value < b.Value
+			}
+			return a.Text < b.Text
+		})
+
+		v := &value{Type: object.HASH_OBJ}
+		for _, key := range keys {
+			k, err := s.value(obj.Pairs[key].Key)
+			if err != nil {
+				return nil, err
+			}
+			val, err := s.value(obj.Pairs[key].Value)
+			if err != nil {
+				return nil, err
+			}
+			v.Pairs = append(v.Pairs, &pair{Key: k, Value: val})
+		}
+		return v, nil
+
+	case *object.Exception:
+		e := &errorValue{
+			Kind:    obj.Error.Kind,
+			Message: obj.Error.Message,
+			Stack:   obj.Error.Stack,
+		}
+		if obj.Error.Value != nil {
+			v, err := s.value(obj.Error.Value)
+			if err != nil {
+				return nil, err
+			}
+			e.Value = v
+		}
+		return &value{Type: object.EXCEPTION_OBJ, Error: e}, nil
+
+	case *object.Function:
+		index, err := s.index(obj.Env)
+		if err != nil {
+			return nil, err
+		}
+		s.addSlots(obj)
+		literal := &ast.FunctionLiteral{Parameters: obj.Parameters, Body: obj.Body}
+		return &value{Type: object.FUNCTION_OBJ, Source: source(literal), Env: index}, nil
+
+	case *object.Builtin:
+		return nil, fmt.Errorf("%w: builtin %s", ErrNotSerializable, obj.Name)
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotSerializable, obj.Type())
+	}
+}
+
+// index returns the index of env, adding it and its outer environments to the
+// list if needed.
+func (s *saver) index(env *object.Environment) (int, error) {
+	if env == s.root {
+		return global, nil
+	}
+	if index, ok := s.indexes[env]; ok {
+		return index, nil
+	}
+
+	if env.Outer() == nil {
+		return 0, fmt.Errorf("%w: function of another global environment",
+			ErrNotSerializable)
+	}
+
+	outer, err := s.index(env.Outer())
+	if err != nil {
+		return 0, err
+	}
+
+	index := len(s.file.Environments)
+	s.indexes[env] = index
+	s.file.Environments = append(s.file.Environments, &environment{
+		Outer:  outer,
+		Values: map[string]*value{},
+	})
+	for _, name := range env.Names() {
+		obj, _ := env.Get(name)
+		s.add(index, name, obj)
+	}
+	return index, nil
+}
+
+// addSlots adds the variables bound to slots that fn refers to, which have no
+// names of their own, under the names of the identifiers that refer to them.
+// The environments of fn must be listed.
+func (s *saver) addSlots(fn *object.Function) {
+	outerSlots(fn.Body, 0, func(ident *ast.Identifier, out int) {
+		env := fn.Env
+		for i := 0; i < out && env != nil; i++ {
+			env = env.Outer()
+		}
+		if env == nil || env == s.root {
+			return
+		}
+
+		// The slot is empty if its let statement wasn't evaluated yet.
+		if obj, ok := env.GetAt(0, ident.Index); ok {
+			s.add(s.indexes[env], ident.Value, obj)
+		}
+	})
+}
+
+// Restore reads a snapshot written by Save from r and binds its global
+// variables in env, replacing variables with the same names.  It fails without
+// changing env if the snapshot is invalid.
+func Restore(r io.Reader, env *object.Environment) error {
+	var f file
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return fmt.Errorf("%w: %s", ErrBadFormat, err)
+	}
+	if f.Format != Format {
+		return ErrBadFormat
+	}
+	if f.Version != Version {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, f.Version)
+	}
+
+	rs := &restorer{root: env}
+	for i, e := range f.Environments {
+		if e.Outer < global || e.Outer >= i {
+			return fmt.Errorf("%w: environment %d has outer environment %d",
+				ErrCorrupt, i, e.Outer)
+		}
+		rs.envs = append(rs.envs, object.NewEnclosedEnvironment(rs.env(e.Outer)))
+	}
+
+	for i, e := range f.Environments {
+		if err := rs.values(rs.envs[i], e.Values); err != nil {
+			return err
+		}
+	}
+
+	globals := object.NewEnvironment()
+	if err := rs.values(globals, f.Globals); err != nil {
+		return err
+	}
+	for _, name := range globals.Names() {
+		obj, _ := globals.Get(name)
+		env.Set(name, obj)
+	}
+	return nil
+}
+
+type restorer struct {
+	root *object.Environment
+	envs []*object.Environment
+}
+
+func (rs *restorer) env(index int) *object.Environment {
+	if index == global {
+		return rs.root
+	}
+	return rs.envs[index]
+}
+
+func (rs *restorer) values(env *object.Environment, values map[string]*value) error {
+	for name, v := range values {
+		obj, err := rs.value(v)
+		if err != nil {
+			return fmt.Errorf("%w: variable %s: %s", ErrCorrupt, name, err)
+		}
+		env.Set(name, obj)
+	}
+	return nil
+}
+
+func (rs *restorer) value(v *value) (object.Object, error) {
+	if v == nil {
+		return nil, errors.New("missing value")
+	}
+
+	switch v.Type {
+	case object.INTEGER_OBJ:
+		return &object.Integer{Value: v.Integer}, nil
+
+	case object.BOOLEAN_OBJ:
+		if v.Boolean {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+
+	case object.NULL_OBJ:
+		return evaluator.NULL, nil
+
+	case object.STRING_OBJ:
+		return &object.String{Value: v.String}, nil
+
+	case object.ARRAY_OBJ:
+		elements := make([]object.Object, len(v.Items))
+		for i, item := range v.Items {
+			element, err := rs.value(item)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case object.HASH_OBJ:
+		pairs := make(map[object.HashKey]object.HashPair, len(v.Pairs))
+		for _, p := range v.Pairs {
+			if p == nil {
+				return nil, errors.New("missing pair")
+			}
+			key, err := rs.value(p.Key)
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			val, err := rs.value(p.Value)
+			if err != nil {
+				return nil, err
+			}
+			pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: val}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+
+	case object.EXCEPTION_OBJ:
+		if v.Error == nil {
+			return nil, errors.New("exception without error")
+		}
+		e := &object.Error{
+			Kind:    v.Error.Kind,
+			Message: v.Error.Message,
+			Stack:   v.Error.Stack,
+		}
+		if v.Error.Value != nil {
+			val, err := rs.value(v.Error.Value)
+			if err != nil {
+				return nil, err
+			}
+			e.Value = val
+		}
+		return &object.Exception{Error: e}, nil
+
+	case object.FUNCTION_OBJ:
+		if v.Env < global || v.Env >= len(rs.envs) {
+			return nil, fmt.Errorf("no environment %d", v.Env)
+		}
+		literal, err := parseFunction(v.Source)
+		if err != nil {
+			return nil, err
+		}
+		return &object.Function{
+			Parameters: literal.Parameters,
+			Body:       literal.Body,
+			Env:        rs.env(v.Env),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown type %q", v.Type)
+	}
+}
+
+// parseFunction parses the source of a saved function.
+func parseFunction(src string) (*ast.FunctionLiteral, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("function %q: %s", src, p.Errors()[0])
+	}
+
+	if len(program.Statements) == 1 {
+		if stmt, ok := program.Statements[0].(*ast.ExpressionStatement); ok {
+			if literal, ok := stmt.Expression.(*ast.FunctionLiteral); ok {
+				return literal, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("function %q: not a function literal", src)
+}
@@ -0,0 +1,362 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"monkey/ast"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/optimizer"
+	"monkey/parser"
+	"monkey/resolver"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []string{
+		"let x = -5 + 3 * (2 - 1);",
+		"!true == false;",
+		"let f = fn(a, b) { if (a < b) { a } else { return b; } };",
+		"fn() { defer puts(1); throw 2; }();",
+		"let t = true ? 1 : 2 ? 3 : 4;",
+		"match (x) { -1 => false, 0 if y => true, n => n * 2 };",
+		"switch (x) { case 1, 2: let y = 3; y default: 0 case 3: }",
+		"try { f(1)(2) } catch (e) { e } finally { 0 };",
+		"try { 1 } finally { };",
+		"obj.name(1)[obj.index];",
+		"(if (x) { 1 }) + 2;",
+		`let s = "a \"quoted\"\tline\n" + "\\";`,
+		`[1, [x, "y"], {}][0];`,
+		`{"a": [1], b: {1: true}}["a"];`,
+		`match (s) { "a" => 1, _ => 2 };`,
+		`match (x) { [] => 0, [a, ..b] => 1, [..c] => 2, {"k": [-1, _], 2: {}} => 3 };`,
+	}
+
+	for _, input := range tests {
+		program := parse(t, input)
+		src := source(program.Statements[0])
+
+		reparsed := parse(t, src)
+		if got := reparsed.String(); got != program.String() {
+			t.Errorf("source %q of %q parses differently. want=%q, got=%q",
+				src, input, program.String(), got)
+		}
+		if again := source(reparsed.Statements[0]); again != src {
+			t.Errorf("source of %q isn't stable. want=%q, got=%q", input, src, again)
+		}
+	}
+}
+
+// TestSourceOptimized checks that the negative integers the optimizer creates
+// are written as valid source.
+func TestSourceOptimized(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fn() { 2 - 7 }", -5},
+		{"fn() { 0 - 9223372036854775807 - 1 }", -9223372036854775807 - 1},
+		{"fn() { 1 - 3 - 4 }", -6},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		optimizer.Optimize(program)
+
+		src := source(program.Statements[0].(*ast.ExpressionStatement).Expression)
+		result := evaluator.Eval(parse(t, src+"()"), object.NewEnvironment())
+
+		integer, ok := result.(*object.Integer)
+		if !ok || integer.Value != tt.expected {
+			t.Errorf("source %q evaluated to %v, want=%d", src, result, tt.expected)
+		}
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	input := `
+let limit = -100000;
+let yes = true;
+let nothing = if (false) { 1 };
+let newAdder = fn(a) { let offset = a * 2; fn(b) { offset + b } };
+let addTwo = newAdder(1);
+let fib = fn(n) { n < 2 ? n : fib(n - 1) + fib(n - 2) };
+let caught = try { throw addTwo } catch (e) { e };
+let failed = try { 1 / 0 } catch (e) { e };
+let text = "a \"b\"\n";
+let list = [1, "two", [true], fn(x) { x * limit }];
+let table = {"k": [1], 2: "v", true: {}};
+`
+	env := object.NewEnvironment()
+	if result := evaluator.Eval(parse(t, input), env); isError(result) {
+		t.Fatalf("eval error: %s", result.Inspect())
+	}
+
+	var buf bytes.Buffer
+	if err := Save(&buf, env); err != nil {
+		t.Fatalf("save error: %s", err)
+	}
+
+	restored := object.NewEnvironment()
+	if err := Restore(&buf, restored); err != nil {
+		t.Fatalf("restore error: %s", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"limit", -100000},
+		{"yes", true},
+		{"addTwo(3)", 5},
+		{"newAdder(10)(1)", 21},
+		{"fib(15)", 610},
+		{"try { throw caught } catch (e) { 0 }", 0},
+		{`text == "a \"b\"\n"`, true},
+		{"len(list)", 4},
+		{"list[2][0]", true},
+		{"list[3](2)", -200000},
+		{`list[1] == "two"`, true},
+		{`table["k"][0] + len(table[true])`, 1},
+		{`table[2] == "v"`, true},
+	}
+
+	for _, tt := range tests {
+		result := evaluator.Eval(parse(t, tt.input), restored)
+		switch expected := tt.expected.(type) {
+		case int:
+			integer, ok := result.(*object.Integer)
+			if !ok || integer.Value != int64(expected) {
+				t.Errorf("%s: wrong result. want=%d, got=%v", tt.input, expected, result)
+			}
+		case bool:
+			if result != evaluator.TRUE && result != evaluator.FALSE ||
+				result.(*object.Boolean).Value != expected {
+				t.Errorf("%s: wrong result. want=%t, got=%v", tt.input, expected, result)
+			}
+		}
+	}
+
+	if nothing, _ := restored.Get("nothing"); nothing != evaluator.NULL {
+		t.Errorf("nothing is not NULL. got=%v", nothing)
+	}
+
+	caught, _ := restored.Get("caught")
+	exception, ok := caught.(*object.Exception)
+	if !ok {
+		t.Fatalf("caught is not an exception. got=%T", caught)
+	}
+	if fn, ok := exception.Error.Value.(*object.Function); !ok {
+		t.Errorf("thrown value is not a function. got=%T", exception.Error.Value)
+	} else if _, ok := fn.Env.Get("offset"); !ok {
+		t.Errorf("thrown function lost its environment")
+	}
+
+	failed, _ := restored.Get("failed")
+	if e, ok := failed.(*object.Exception); !ok || e.Error.Kind != object.ZERO_DIVISION_ERROR {
+		t.Errorf("failed is not a ZeroDivisionError. got=%v", failed)
+	}
+
+	// Closures of the same environment still share it.
+	shared := object.NewEnvironment()
+	evaluator.Eval(parse(t, "let pair = fn(x) { fn(y) { fn() { x + y } } }; let f = pair(1); let g = f(2); let h = f(3);"), shared)
+	buf.Reset()
+	if err := Save(&buf, shared); err != nil {
+		t.Fatalf("save error: %s", err)
+	}
+	if strings.Count(buf.String(), `"outer"`) != 3 {
+		t.Errorf("wrong number of environments in %s", buf.String())
+	}
+	restored = object.NewEnvironment()
+	if err := Restore(&buf, restored); err != nil {
+		t.Fatalf("restore error: %s", err)
+	}
+	g, _ := restored.Get("g")
+	h, _ := restored.Get("h")
+	if g.(*object.Function).Env.Outer() != h.(*object.Function).Env.Outer() {
+		t.Errorf("g and h don't share the environment of f")
+	}
+}
+
+func TestSaveRestoreResolved(t *testing.T) {
+	r := resolver.New()
+	env := object.NewEnvironment()
+	env.SetSlotNames(r)
+	eval(t, r, env, "let n = 2; let double = fn(x) { x * n }; let names = [\"n\"];")
+
+	var buf bytes.Buffer
+	if err := Save(&buf, env); err != nil {
+		t.Fatalf("save error: %s", err)
+	}
+
+	restoredResolver := resolver.New()
+	restored := object.NewEnvironment()
+	restored.SetSlotNames(restoredResolver)
+	eval(t, restoredResolver, restored, "let n = 100; let m = 3;")
+	if err := Restore(&buf, restored); err != nil {
+		t.Fatalf("restore error: %s", err)
+	}
+
+	result := eval(t, restoredResolver, restored, "double(n) + m + len(names)")
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 8 {
+		t.Errorf("wrong result. want=8, got=%v", result)
+	}
+
+	// The slots of function calls and blocks are saved under the names that
+	// the closures use for them.
+	eval(t, r, env, `
+let adder = fn(a) { fn(b) { a + b } };
+let addTwo = adder(2);
+let counter = fn(x) {
+  let step = x * 10;
+  fn(y) { match (y) { n => fn(z) { n + z + step + x + double(1) } } }
+};
+let f = counter(1)(2);`)
+	buf.Reset()
+	if err := Save(&buf, env); err != nil {
+		t.Fatalf("save error: %s", err)
+	}
+
+	restoredResolver = resolver.New()
+	restored = object.NewEnvironment()
+	restored.SetSlotNames(restoredResolver)
+	if err := Restore(&buf, restored); err != nil {
+		t.Fatalf("restore error: %s", err)
+	}
+
+	result = eval(t, restoredResolver, restored, "addTwo(3) * 100 + f(3)")
+	if integer, ok := result.(*object.Integer); !ok || integer.Value != 518 {
+		t.Errorf("wrong result. want=518, got=%v", result)
+	}
+}
+
+func TestSaveErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(env *object.Environment)
+		expected string
+	}{
+		{
+			"builtin",
+			func(env *object.Environment) {
+				env.Set("print", object.GetBuiltinByName("puts"))
+			},
+			"variable print: value can't be saved: builtin puts",
+		},
+		{
+			"closure over builtin",
+			func(env *object.Environment) {
+				env.Set("wrap", object.GetBuiltinByName("now"))
+				evaluator.Eval(parse(t, "let f = fn(g) { fn() { g() } }(wrap);"), env)
+				env.Set("wrap", &object.Integer{Value: 1})
+			},
+			"closure variable g: value can't be saved: builtin now",
+		},
+		{
+			"iteration",
+			func(env *object.Environment) {
+				env.Set("it", &object.Iteration{})
+			},
+			"variable it: value can't be saved: ITERATION",
+		},
+		{
+			"other environment",
+			func(env *object.Environment) {
+				other := object.NewEnvironment()
+				env.Set("f", evaluator.Eval(parse(t, "fn() { 1 }"), other))
+			},
+			"variable f: value can't be saved: function of another global environment",
+		},
+		{
+			"resolved",
+			func(env *object.Environment) {
+				env.SetAt(0, &object.Integer{Value: 1})
+			},
+			"value can't be saved: global variables are resolved",
+		},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		tt.setup(env)
+
+		var buf bytes.Buffer
+		err := Save(&buf, env)
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if !errors.Is(err, ErrNotSerializable) {
+			t.Errorf("%s: error doesn't wrap ErrNotSerializable: %s", tt.name, err)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: wrote %d bytes", tt.name, buf.Len())
+		}
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected error
+	}{
+		{"", ErrBadFormat},
+		{"let x = 1;", ErrBadFormat},
+		{`{"format": "other", "version": 1}`, ErrBadFormat},
+		{`{"format": "monkey-snapshot", "version": 2}`, ErrUnsupportedVersion},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"x": {"type": "BUILTIN"}}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"x": {"type": "ARRAY", "items": [null]}}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"x": {"type": "HASH", "pairs": [null]}}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"x": {"type": "HASH", "pairs": [{"key": {"type": "ARRAY"}, "value": {"type": "NULL"}}]}}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"x": null}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"f": {"type": "FUNCTION", "source": "1 + 2"}}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"f": {"type": "FUNCTION", "source": "fn(x) {"}}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "globals": {"f": {"type": "FUNCTION", "source": "fn() { 1 }", "env": 0}}}`, ErrCorrupt},
+		{`{"format": "monkey-snapshot", "version": 1, "environments": [{"outer": 0}]}`, ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Set("keep", &object.Integer{Value: 1})
+
+		err := Restore(strings.NewReader(tt.input), env)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+		if names := env.Names(); len(names) != 1 {
+			t.Errorf("%s: environment changed. got=%v", tt.input, names)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// eval resolves input with r and evaluates it in env.
+func eval(t *testing.T, r *resolver.Resolver, env *object.Environment, input string) object.Object {
+	t.Helper()
+	program := parse(t, input)
+	r.Resolve(program)
+	result := evaluator.Eval(program, env)
+	if isError(result) {
+		t.Fatalf("eval error for %q: %s", input, result.Inspect())
+	}
+	return result
+}
+
+func isError(obj object.Object) bool {
+	_, ok := obj.(*object.Error)
+	return ok
+}
@@ -0,0 +1,279 @@
+package snapshot
+
+import (
+	"bytes"
+	"math"
+	"monkey/ast"
+	"strconv"
+	"strings"
+)
+
+// source returns Monkey source code that parses back to node.  Unlike the
+// String methods of the nodes, which are meant for reading, it puts compound
+// expressions in parentheses and keeps every keyword and brace.
+func source(node ast.Node) string {
+	var out bytes.Buffer
+	writeSource(&out, node)
+	return out.String()
+}
+
+func writeSource(out *bytes.Buffer, node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		out.WriteString("let " + node.Name.Value + " = ")
+		writeSource(out, node.Value)
+		out.WriteString(";")
+
+	case *ast.ReturnStatement:
+		out.WriteString("return ")
+		writeSource(out, node.ReturnValue)
+		out.WriteString(";")
+
+	case *ast.ThrowStatement:
+		out.WriteString("throw ")
+		writeSource(out, node.Value)
+		out.WriteString(";")
+
+	case *ast.DeferStatement:
+		out.WriteString("defer ")
+		writeSource(out, node.Expression)
+		out.WriteString(";")
+
+	case *ast.ExpressionStatement:
+		writeSource(out, node.Expression)
+		out.WriteString(";")
+
+	case *ast.BlockStatement:
+		out.WriteString("{ ")
+		writeStatements(out, node)
+		out.WriteString("}")
+
+	case *ast.Identifier:
+		out.WriteString(node.Value)
+
+	case *ast.IntegerLiteral:
+		writeInteger(out, node.Value)
+
+	case *ast.Boolean:
+		out.WriteString(strconv.FormatBool(node.Value))
+
+	case *ast.StringLiteral:
+		writeString(out, node.Value)
+
+	case *ast.ArrayLiteral:
+		out.WriteString("[")
+		writeList(out, node.Elements)
+		out.WriteString("]")
+
+	case *ast.HashLiteral:
+		out.WriteString("{")
+		for i, pair := range node.Pairs {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			writeSource(out, pair.Key)
+			out.WriteString(": ")
+			writeSource(out, pair.Value)
+		}
+		out.WriteString("}")
+
+	case *ast.PrefixExpression:
+		out.WriteString("(" + node.Operator)
+		writeSource(out, node.Right)
+		out.WriteString(")")
+
+	case *ast.InfixExpression:
+		out.WriteString("(")
+		writeSource(out, node.Left)
+		out.WriteString(" " + node.Operator + " ")
+		writeSource(out, node.Right)
+		out.WriteString(")")
+
+	case *ast.IfExpression:
+		out.WriteString("(if (")
+		writeSource(out, node.Condition)
+		out.WriteString(") ")
+		writeSource(out, node.Consequence)
+		if node.Alternative != nil {
+			out.WriteString(" else ")
+			writeSource(out, node.Alternative)
+		}
+		out.WriteString(")")
+
+	case *ast.TernaryExpression:
+		out.WriteString("(")
+		writeSource(out, node.Condition)
+		out.WriteString(" ? ")
+		writeSource(out, node.Consequence)
+		out.WriteString(" : ")
+		writeSource(out, node.Alternative)
+		out.WriteString(")")
+
+	case *ast.FunctionLiteral:
+		params := []string{}
+		for _, p := range node.Parameters {
+			params = append(params, p.Value)
+		}
+		out.WriteString("(fn(" + strings.Join(params, ", ") + ") ")
+		writeSource(out, node.Body)
+		out.WriteString(")")
+
+	case *ast.CallExpression:
+		writeSource(out, node.Function)
+		out.WriteString("(")
+		writeList(out, node.Arguments)
+		out.WriteString(")")
+
+	case *ast.DotExpression:
+		out.WriteString("(")
+		writeSource(out, node.Left)
+		out.WriteString("." + node.Name + ")")
+
+	case *ast.IndexExpression:
+		out.WriteString("(")
+		writeSource(out, node.Left)
+		out.WriteString("[")
+		writeSource(out, node.Index)
+		out.WriteString("])")
+
+	case *ast.MatchExpression:
+		out.WriteString("(match (")
+		writeSource(out, node.Subject)
+		out.WriteString(") { ")
+		for i, arm := range node.Arms {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			writePattern(out, arm.Pattern)
+			if arm.Guard != nil {
+				out.WriteString(" if ")
+				writeSource(out, arm.Guard)
+			}
+			out.WriteString(" => ")
+			writeSource(out, arm.Body)
+		}
+		out.WriteString(" })")
+
+	case *ast.SwitchExpression:
+		out.WriteString("(switch (")
+		writeSource(out, node.Subject)
+		out.WriteString(") { ")
+		for _, c := range node.Cases {
+			out.WriteString("case ")
+			writeList(out, c.Values)
+			out.WriteString(": ")
+			writeStatements(out, c.Body)
+		}
+		if node.Default != nil {
+			out.WriteString("default: ")
+			writeStatements(out, node.Default)
+		}
+		out.WriteString("})")
+
+	case *ast.TryExpression:
+		out.WriteString("(try ")
+		writeSource(out, node.Block)
+		if node.Catch != nil {
+			out.WriteString(" catch (" + node.CatchParameter.Value + ") ")
+			writeSource(out, node.Catch)
+		}
+		if node.Finally != nil {
+			out.WriteString(" finally ")
+			writeSource(out, node.Finally)
+		}
+		out.WriteString(")")
+	}
+}
+
+func writeStatements(out *bytes.Buffer, block *ast.BlockStatement) {
+	for _, s := range block.Statements {
+		writeSource(out, s)
+		out.WriteString(" ")
+	}
+}
+
+func writeList(out *bytes.Buffer, expressions []ast.Expression) {
+	for i, e := range expressions {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		writeSource(out, e)
+	}
+}
+
+// writeInteger writes value, which may be negative after optimizing.  The
+// literals of the language are never negative.
+func writeInteger(out *bytes.Buffer, value int64) {
+	switch {
+	case value == math.MinInt64:
+		out.WriteString("(-9223372036854775807 - 1)")
+	case value < 0:
+		out.WriteString("(-" + strconv.FormatInt(-value, 10) + ")")
+	default:
+		out.WriteString(strconv.FormatInt(value, 10))
+	}
+}
+
+// stringEscapes maps the characters that must be escaped in string literals
+// to their escape sequences.
+var stringEscapes = map[byte]string{
+	'\n': `\n`,
+	'\t': `\t`,
+	'\r': `\r`,
+	'"':  `\"`,
+	'\\': `\\`,
+}
+
+// writeString writes value as a string literal.
+func writeString(out *bytes.Buffer, value string) {
+	out.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if escape, ok := stringEscapes[value[i]]; ok {
+			out.WriteString(escape)
+		} else {
+			out.WriteByte(value[i])
+		}
+	}
+	out.WriteByte('"')
+}
+
+// writePattern writes the pattern of a match arm, which can't be in
+// parentheses.
+func writePattern(out *bytes.Buffer, pattern ast.Expression) {
+	switch pattern := pattern.(type) {
+	case *ast.PrefixExpression:
+		out.WriteString(pattern.Operator)
+		writeSource(out, pattern.Right)
+
+	case *ast.ArrayPattern:
+		out.WriteString("[")
+		for i, element := range pattern.Elements {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			writePattern(out, element)
+		}
+		if pattern.Rest != nil {
+			if len(pattern.Elements) > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(".." + pattern.Rest.Value)
+		}
+		out.WriteString("]")
+
+	case *ast.HashPattern:
+		out.WriteString("{")
+		for i, pair := range pattern.Pairs {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			writePattern(out, pair.Key)
+			out.WriteString(": ")
+			writePattern(out, pair.Value)
+		}
+		out.WriteString("}")
+
+	default:
+		writeSource(out, pattern)
+	}
+}
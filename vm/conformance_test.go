@@ -0,0 +1,515 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/closure"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+// conformanceTests are run by the evaluator, the closure compiler and the
+// virtual machine, which must agree on the result of every program.
+var conformanceTests = []vmTestCase{
+	// Expressions.
+	{"5 * (2 + 10) - -3", 63},
+	{"1 < 2 == true", true},
+	{"!(1 > 2)", true},
+	{"if (1 > 2) { 10 } else { 20 }", 20},
+	{"if (false) { 10 }", Null},
+	{"1 > 2 ? 10 : 1 > 0 ? 20 : 30", 20},
+	{"false ? foobar : 20", 20},
+	{`"mon" + "key"`, "monkey"},
+	{`"a" == "a" ? "b" != "b" : true`, false},
+	{"[1, 2 * 3, 4][1]", 6},
+	{"let a = [1, [2, 3]]; a[1][0] + a[5 - 5]", 3},
+	{"[1, 2][2]", Null},
+	{`let k = "b"; {"a": 1, k: 2}[k]`, 2},
+	{`{"a": 1}["b"]`, Null},
+	{`match ("b") { "a" => 1, "b" => 2, _ => 3 }`, 2},
+	{`switch ("b") { case "a": 1 case "b": 2 }`, 2},
+	{"match ([1, 2, 3]) { [a, b] => 1, [h, ..t] => h * 10 + t[1] }", 13},
+	{"match ([1, 2]) { [..all] => all }", []int{1, 2}},
+	{"match ([]) { [h, ..t] => 1, [] => 2 }", 2},
+	{"match ([1, [2, 3]]) { [_, [_, x]] => x }", 3},
+	{"match (1) { [..all] => 1, {} => 2, _ => 3 }", 3},
+	{`match ({"a": 1, 2: [3]}) { {"a": 2} => 0, {2: [x], "a": y} => x + y }`, 4},
+	{`match ({"a": if (false) { 1 }}) { {"b": x} => 1, {"a": x} => 2 }`, 2},
+	{"let x = 5; match ([1]) { [x] => x }; x", 5},
+	{`
+let sum = fn(list) { match (list) { [] => 0, [h, ..t] => h + sum(t) } };
+sum([1, 2, 3, 4])`, 10},
+	{"match ([1]) { [] => 1 }", &object.Error{Kind: object.MATCH_ERROR,
+		Message: "non-exhaustive match: no arm matches [1]"}},
+
+	// Bindings, functions and closures.
+	{"let a = 5; let b = a * 2; let a = b + 1; a", 11},
+	{"let f = fn(x) { return x * 2; 0 }; f(3) + f(4)", 14},
+	{"if (true) { return 10; 20 }; 30", 10},
+	{"let add = fn(a) { fn(b) { a + b } }; add(2)(3)", 5},
+	{`
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(15)`, 610},
+	{`
+let counter = fn(x) { fn(y) { fn(z) { x + y + z } } };
+let a = counter(1); let b = a(2); b(3) + b(4)`, 13},
+	{"let f = fn() { x }; let x = 5; f()", 5},
+	{"let x = 1; let g = fn() { x }; let x = 2; g()", 2},
+	{"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()", 2},
+	{"let f = fn(x) { let g = fn() { x }; let x = x + 1; g() }; f(1)", 2},
+	{`
+let f = fn() { let x = 1; let g = fn() { fn() { x } }; let h = g(); let x = 3; h() };
+f()`, 3},
+	{`
+let f = fn(n) { let get = fn() { n }; let n = n * 10; get };
+let a = f(1); let b = f(2); a() + b()`, 30},
+	{"let f = fn() { let x = 1; defer fn() { x }; let x = 2; 0 }; f()", 0},
+	{"let f = fn() {}; f() ? 1 : 2", 2},
+
+	// Builtins.
+	{`len("four") + len([1, 2])`, 6},
+	{"first(rest(push([1], 2)))", 2},
+	{"let f = fn() { len }; f()([1, 2])", 2},
+	{"let len = fn(x) { 0 }; len([1])", 0},
+	{"now() > 0", true},
+	{"let r = random(10); r < 10 ? !(r < 0) : false", true},
+	{"len(1)", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "argument to `len` not supported, got INTEGER"}},
+	{"iter(5)", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "argument to `iter` not supported, got INTEGER"}},
+	{"random(0)", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "argument to `random` must be a positive INTEGER, got 0"}},
+	{"try { len(1) } catch (e) { 5 }", 5},
+	{"let it = iter([1, 2]); it.next() * 10 + it.next()", 12},
+	{"let it = iter([]); it.done()", true},
+
+	// Match and switch expressions.
+	{"match (2) { 1 => 10, 2 => 20, _ => 30 }", 20},
+	{"match (5) { 1 => 10, n if n > 3 => n * 2, _ => 0 }", 10},
+	{"match (-1) { -1 => true, _ => false }", true},
+	{"let x = 3; match (x + 1) { y => y + x }", 7},
+	{"let x = 1; match (5) { x => x }; x", 1},
+	{"switch (3) { case 1, 2: 10 case 3, 4: 20 default: 30 }", 20},
+	{"switch (9) { case 1: 10 default: 30 }", 30},
+	{"switch (9) { case 1: 10 }", Null},
+	{"switch (true) { case 1: 10 case true: 20 }", 20},
+
+	// Errors.
+	{"1 + true", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "type mismatch: INTEGER + BOOLEAN"}},
+	{"true + false", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "unknown operator: BOOLEAN + BOOLEAN"}},
+	{"-true", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "unknown operator: -BOOLEAN"}},
+	{"10 / (5 - 5)", &object.Error{Kind: object.ZERO_DIVISION_ERROR,
+		Message: "division by zero"}},
+	{"foobar + 1", &object.Error{Kind: object.NAME_ERROR,
+		Message: "identifier not found: foobar"}},
+	{"match (3) { 1 => 1 }", &object.Error{Kind: object.MATCH_ERROR,
+		Message: "non-exhaustive match: no arm matches 3"}},
+	{"let f = fn(a, b) { a }; f(1)", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "wrong number of arguments: want=2, got=1"}},
+	{"let x = 5; x(1)", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "not a function: INTEGER"}},
+
+	// Throw, try, catch and finally.
+	{"throw 5; 10", &object.Error{Kind: object.THROWN_ERROR, Message: "5",
+		Value: &object.Integer{Value: 5}}},
+	{"try { throw 1 } catch (e) { throw 2 }", &object.Error{
+		Kind: object.THROWN_ERROR, Message: "2", Value: &object.Integer{Value: 2}}},
+	{"try { 1 } finally { throw 2 }", &object.Error{Kind: object.THROWN_ERROR,
+		Message: "2", Value: &object.Integer{Value: 2}}},
+	{"try { 1 / 0 } catch (e) { throw e }", &object.Error{
+		Kind: object.ZERO_DIVISION_ERROR, Message: "division by zero"}},
+	{"try { throw 5 } catch (e) { e }", &object.Exception{Error: &object.Error{
+		Kind: object.THROWN_ERROR, Message: "5", Value: &object.Integer{Value: 5}}}},
+	{"try { x } catch (e) { e }", &object.Exception{Error: &object.Error{
+		Kind: object.NAME_ERROR, Message: "identifier not found: x"}}},
+	{"try { 10 } catch (e) { 20 }", 10},
+	{"try { throw 1 } catch (e) { e.kind }", "Error"},
+	{`try { 1 / 0 } catch (e) { e.message }`, "division by zero"},
+	{"try { throw 5 } catch (e) { e.value * 2 }", 10},
+	{"try { throw 5 } catch (e) { e.nothing }", &object.Error{
+		Kind: object.NAME_ERROR, Message: "property not found: EXCEPTION.nothing"}},
+	{"let f = fn() { g() }; let g = fn() { throw 1 }; try { f() } catch (e) { e.stack[1] }",
+		"f"},
+	{"try { 1 + true } catch (e) { 20 }", 20},
+	{"try { throw 1 } catch (e) { 10 } finally { 20 }", 10},
+	{"let x = 1; try { throw 1 } catch (e) { 10 } finally { let x = 2 }; x", 2},
+	{"let x = 1; try { 1 / 0 } catch (e) { let x = 2 }; x", 1},
+	{"try { return 10; } finally { 20 }; 30", 10},
+	{"let f = fn() { try { return 1; } finally { let g = fn() { 2 }; g() } }; f()", 1},
+	{"try { return 10; } finally { return 20; }; 30", 20},
+	{"try { throw 1 } finally { return 20; }", 20},
+	{"try { try { throw 1 } finally { 2 } } catch (e) { 30 }", 30},
+	{"try { try { throw 1 } catch (e) { throw e } } catch (e) { 40 }", 40},
+	{`
+let f = fn(x) { if (x == 0) { throw 7 } else { f(x - 1) } };
+try { f(5) } catch (e) { e }`, &object.Exception{Error: &object.Error{
+		Kind: object.THROWN_ERROR, Message: "7", Value: &object.Integer{Value: 7}}}},
+	{`
+let f = fn() { try { return 1; } finally { throw 2 } };
+try { f() } catch (e) { 3 }`, 3},
+	{`
+let f = fn(x) { try { x * 2 } catch (e) { 0 } };
+f(3) + f(true)`, 6},
+
+	// Deferred expressions.
+	{"let f = fn() { defer 5; 10 }; f()", 10},
+	{"let f = fn() { defer 1 / 0; 10 }; f()", &object.Error{
+		Kind: object.ZERO_DIVISION_ERROR, Message: "division by zero"}},
+	{"let f = fn() { defer 1 / 0; return 10; 20 }; f()", &object.Error{
+		Kind: object.ZERO_DIVISION_ERROR, Message: "division by zero"}},
+	{"let f = fn() { defer 1 / 0; throw 10 }; f()", &object.Error{
+		Kind: object.ZERO_DIVISION_ERROR, Message: "division by zero"}},
+	{"let f = fn() { defer 1 / 0; 10 }; try { f() } catch (e) { 20 }", 20},
+	{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn() { defer fail(1); defer fail(2); 10 };
+f()`, &object.Error{Kind: object.THROWN_ERROR, Message: "1",
+		Value: &object.Integer{Value: 1}}},
+	{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn(x) { let y = x * 2; defer fail(y); 10 };
+f(4)`, &object.Error{Kind: object.THROWN_ERROR, Message: "8",
+		Value: &object.Integer{Value: 8}}},
+	{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn() { let g = fn() { defer fail(1); 10 }; defer fail(2); g() };
+f()`, &object.Error{Kind: object.THROWN_ERROR, Message: "2",
+		Value: &object.Integer{Value: 2}}},
+	{`
+let fail = fn(x) { if (true) { throw x } };
+let f = fn() { defer fail(3); 1 / 0 };
+try { f() } catch (e) { e }`, &object.Exception{Error: &object.Error{
+		Kind: object.THROWN_ERROR, Message: "3", Value: &object.Integer{Value: 3}}}},
+
+	// Tail calls.
+	{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000000)", 0},
+	{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)",
+		5000050000},
+	{`
+let even = fn(n) { n == 0 ? 1 : odd(n - 1) };
+let odd = fn(n) { match (n) { 0 => 0, _ => even(n - 1) } };
+even(100001)`, 0},
+	{"let count = fn(n) { switch (n) { case 0: 42 default: count(n - 1) } }; count(100000)",
+		42},
+	{"let f = fn(n) { if (n > 0) { return f(n - 1); } let x = 7; x }; f(100000)", 7},
+	{"let f = fn(n) { defer 0; if (n == 0) { 0 } else { f(n - 1) } }; f(20000)", 0},
+	{"let f = fn(n) { try { n == 0 ? 5 : f(n - 1) } finally { 0 } }; f(10)", 5},
+	{"let f = fn() { g(1) }; let g = 5; try { f() } catch (e) { 3 }", 3},
+	{"let f = fn() { len([1, 2]) }; f()", 2},
+	{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100000)",
+		&object.Error{Kind: object.RECURSION_ERROR,
+			Message: "maximum recursion depth exceeded (10000)"}},
+	{`
+let f = fn(n) { if (n == 0) { throw 1 } else { f(n - 1) } };
+try { f(2) } catch (e) { len(e.stack) }`, 3},
+	{`
+let inner = fn() { 1 / 0 };
+let middle = fn() { inner() };
+let outer = fn() { middle() };
+try { outer() } catch (e) { e.stack[0] + e.stack[1] + e.stack[2] }`,
+		"innermiddleouter"},
+	{`
+let fail = fn(x) { if (true) { throw x } };
+let g = fn() { defer fail(2); 10 };
+let f = fn() { defer 0; g() };
+try { f() } catch (e) { e.stack[0] + e.stack[1] + e.stack[2] }`, "failgf"},
+}
+
+func TestConformance(t *testing.T) {
+	for _, tt := range conformanceTests {
+		env := object.NewEnvironment()
+		evaluated := evaluator.Eval(parse(tt.input), env)
+		if err := testExpectedObject(tt.expected, evaluated); err != nil {
+			t.Errorf("evaluator: wrong result for %q: %s", tt.input, err)
+		}
+
+		program, err := closure.Compile(parse(tt.input), closure.NewGlobals())
+		if err != nil {
+			t.Errorf("closure: error for %q: %s", tt.input, err)
+		} else if err := testExpectedObject(tt.expected, program.Run()); err != nil {
+			t.Errorf("closure: wrong result for %q: %s", tt.input, err)
+		}
+
+		result, err := runVm(tt.input)
+		if err != nil {
+			t.Errorf("vm: error for %q: %s", tt.input, err)
+			continue
+		}
+		if err := testExpectedObject(tt.expected, result); err != nil {
+			t.Errorf("vm: wrong result for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func TestSharedSingletons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.Object
+	}{
+		{"1 < 2", evaluator.TRUE},
+		{"!true", evaluator.FALSE},
+		{"if (false) { 1 }", evaluator.NULL},
+		{"[1][5]", evaluator.NULL},
+	}
+
+	for _, tt := range tests {
+		result, err := runVm(tt.input)
+		if err != nil {
+			t.Errorf("vm: error for %q: %s", tt.input, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("vm: %q isn't the evaluator's singleton. got=%p, want=%p",
+				tt.input, result, tt.expected)
+		}
+	}
+}
+
+// streamTests are run like conformanceTests, with stdin as the input and a
+// sandbox that only permits the builtins with the io capability.  All engines
+// must also agree on the output of the program.
+var streamTests = []struct {
+	input          string
+	stdin          string
+	expected       interface{}
+	expectedOutput string
+}{
+	{"puts(1, true); puts()", "", Null, "1\ntrue\n"},
+	{"let f = fn(x) { puts(x) }; f(2); f(3)", "", Null, "2\n3\n"},
+	{`puts(1); eputs("two", 3)`, "", Null, "1\ntwo\n3\n"},
+	{"puts(input() * 2); input()", "21\n", Null, "42\n"},
+	{"readline() + readline()", "a\nb\n", "ab", ""},
+	{"now()", "", &object.Error{Kind: object.CAPABILITY_ERROR,
+		Message: "capability denied: now needs the time capability"}, ""},
+	{"puts(1); try { random(5) } catch (e) { 2 }", "", 2, "1\n"},
+}
+
+func TestConformanceStreams(t *testing.T) {
+	sandbox := object.NewSandbox(object.IO_CAPABILITY)
+
+	for _, tt := range streamTests {
+		var out bytes.Buffer
+		check := func(engine string, result object.Object) {
+			if err := testExpectedObject(tt.expected, result); err != nil {
+				t.Errorf("%s: wrong result for %q: %s", engine, tt.input, err)
+			}
+			if out.String() != tt.expectedOutput {
+				t.Errorf("%s: wrong output for %q. want=%q, got=%q", engine,
+					tt.input, tt.expectedOutput, out.String())
+			}
+			out.Reset()
+		}
+
+		env := object.NewEnvironment()
+		env.SetSandbox(sandbox)
+		env.SetStreams(object.NewStreams(strings.NewReader(tt.stdin), &out))
+		check("evaluator", evaluator.Eval(parse(tt.input), env))
+
+		globals := closure.NewGlobals()
+		globals.SetSandbox(sandbox)
+		globals.SetStreams(object.NewStreams(strings.NewReader(tt.stdin), &out))
+		program, err := closure.Compile(parse(tt.input), globals)
+		if err != nil {
+			t.Errorf("closure: error for %q: %s", tt.input, err)
+		} else {
+			check("closure", program.Run())
+		}
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Errorf("vm: compiler error for %q: %s", tt.input, err)
+			continue
+		}
+		machine := New(comp.Bytecode())
+		machine.SetSandbox(sandbox)
+		machine.SetStreams(object.NewStreams(strings.NewReader(tt.stdin), &out))
+		if err := machine.Run(); err != nil {
+			t.Errorf("vm: error for %q: %s", tt.input, err)
+			continue
+		}
+		check("vm", machine.Result())
+	}
+}
+
+// rejectedTests are programs the parser rejects, so that no engine runs them.
+var rejectedTests = []struct {
+	input         string
+	expectedError string
+}{
+	{"let f = fn(a, a) { a }; f(1, 2)", "duplicate parameter a"},
+}
+
+func TestConformanceRejected(t *testing.T) {
+	for _, tt := range rejectedTests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 || errors[0] != tt.expectedError {
+			t.Errorf("wrong errors for %q. want=%q, got=%q", tt.input,
+				tt.expectedError, errors)
+		}
+	}
+}
+
+// point is a host object type that implements every optional interface.
+type point struct {
+	x, y int64
+}
+
+func (p *point) Type() object.ObjectType { return "POINT" }
+
+func (p *point) Inspect() string { return fmt.Sprintf("point(%d, %d)", p.x, p.y) }
+
+func (p *point) GetProperty(name string) (object.Object, bool) {
+	switch name {
+	case "x":
+		return &object.Integer{Value: p.x}, true
+	case "y":
+		return &object.Integer{Value: p.y}, true
+	case "origin":
+		return &object.Boolean{Value: p.x == 0 && p.y == 0}, true
+	}
+	return nil, false
+}
+
+func (p *point) HasMethod(name string) bool {
+	return name == "scale"
+}
+
+func (p *point) CallMethod(name string, args []object.Object) object.Object {
+	n, ok := args[0].(*object.Integer)
+	if len(args) != 1 || !ok {
+		return &object.Error{Kind: object.TYPE_ERROR, Message: "scale needs an INTEGER"}
+	}
+	return &point{x: p.x * n.Value, y: p.y * n.Value}
+}
+
+func (p *point) Index(index object.Object) object.Object {
+	i, ok := index.(*object.Integer)
+	if !ok || i.Value < 0 || i.Value > 1 {
+		return &object.Error{Kind: object.TYPE_ERROR,
+			Message: fmt.Sprintf("index out of range: %s", index.Inspect())}
+	}
+	if i.Value == 0 {
+		return &object.Integer{Value: p.x}
+	}
+	return &object.Integer{Value: p.y}
+}
+
+func (p *point) Operate(operator string, right object.Object) (object.Object, bool) {
+	r, ok := right.(*point)
+	if !ok {
+		return nil, false
+	}
+
+	switch operator {
+	case "+":
+		return &point{x: p.x + r.x, y: p.y + r.y}, true
+	case "==":
+		return &object.Boolean{Value: p.x == r.x && p.y == r.y}, true
+	}
+	return nil, false
+}
+
+func (p *point) Iterate() object.Iterator {
+	return &pointIterator{p: p}
+}
+
+type pointIterator struct {
+	p *point
+	i int
+}
+
+func (it *pointIterator) Next() (object.Object, bool) {
+	it.i++
+	switch it.i {
+	case 1:
+		return &object.Integer{Value: it.p.x}, true
+	case 2:
+		return &object.Integer{Value: it.p.y}, true
+	}
+	return nil, false
+}
+
+// hostGlobals are the global variables of hostTests.
+var hostGlobals = map[string]object.Object{
+	"p":    &point{x: 1, y: 2},
+	"q":    &point{x: 3, y: 4},
+	"zero": &point{},
+}
+
+// hostTests are run like conformanceTests, with hostGlobals defined.
+var hostTests = []vmTestCase{
+	{"p.x * 10 + p.y", 12},
+	{"zero.origin", true},
+	{"p.origin ? 1 : 2", 2},
+	{"p.z", &object.Error{Kind: object.NAME_ERROR,
+		Message: "property not found: POINT.z"}},
+	{"p.scale(3).y", 6},
+	{"let s = p.scale; s(2).x", 2},
+	{"p.scale(true)", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "scale needs an INTEGER"}},
+	{"p[0] + q[1]", 5},
+	{"p[2]", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "index out of range: 2"}},
+	{"(p + q).x", 4},
+	{"p + q == q + p", true},
+	{"p == q", false},
+	{"p * 2", &object.Error{Kind: object.TYPE_ERROR,
+		Message: "type mismatch: POINT * INTEGER"}},
+	{"let it = iter(q); it.next() + it.next()", 7},
+	{"let f = fn(a) { a.x }; f(q)", 3},
+	{"try { p[5] } catch (e) { e.kind }", "TypeError"},
+}
+
+func TestConformanceHost(t *testing.T) {
+	for _, tt := range hostTests {
+		env := object.NewEnvironment()
+		for name, val := range hostGlobals {
+			env.Set(name, val)
+		}
+		evaluated := evaluator.Eval(parse(tt.input), env)
+		if err := testExpectedObject(tt.expected, evaluated); err != nil {
+			t.Errorf("evaluator: wrong result for %q: %s", tt.input, err)
+		}
+
+		globals := closure.NewGlobals()
+		for name, val := range hostGlobals {
+			globals.Set(name, val)
+		}
+		program, err := closure.Compile(parse(tt.input), globals)
+		if err != nil {
+			t.Errorf("closure: error for %q: %s", tt.input, err)
+		} else if err := testExpectedObject(tt.expected, program.Run()); err != nil {
+			t.Errorf("closure: wrong result for %q: %s", tt.input, err)
+		}
+
+		symbols := compiler.NewSymbolTable()
+		store := make([]object.Object, GlobalsSize)
+		for name, val := range hostGlobals {
+			store[symbols.Define(name).Index] = val
+		}
+		comp := compiler.NewWithState(symbols, []object.Object{})
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Errorf("vm: compiler error for %q: %s", tt.input, err)
+			continue
+		}
+		machine := NewWithGlobalsStore(comp.Bytecode(), store)
+		if err := machine.Run(); err != nil {
+			t.Errorf("vm: error for %q: %s", tt.input, err)
+			continue
+		}
+		if err := testExpectedObject(tt.expected, machine.Result()); err != nil {
+			t.Errorf("vm: wrong result for %q: %s", tt.input, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+)
+
+// Frame holds the execution state of a single function call.  basePointer is
+// the stack position of the first argument, followed by the other local
+// variables.  deferred holds the closures registered by OpDefer.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+	deferred    []*object.Closure
+
+	// replaced holds the functions that the frame executed before tail calls
+	// replaced them, outermost first, so that errors still list them.
+	// tail is true for a frame that was added by a tail call anyway, since
+	// its caller had deferred closures to run afterwards.  Neither counts
+	// towards the call depth.
+	replaced []replacedCall
+	tail     bool
+}
+
+// replacedCall records count consecutive calls of the function name that were
+// replaced by tail calls.
+type replacedCall struct {
+	name  string
+	count int
+}
+
+// NewFrame returns a reference to a new Frame that executes cl from the start.
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+	}
+}
+
+// Instructions returns the instructions of the function being called.
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// replace makes the frame execute cl, called by a tail call of the function
+// it executes, from the start.  Consecutive calls of the same function are
+// recorded once, so that a loop written as a tail call uses constant memory.
+func (f *Frame) replace(cl *object.Closure) {
+	name := f.cl.Fn.Name
+	if n := len(f.replaced); n > 0 && f.replaced[n-1].name == name {
+		f.replaced[n-1].count++
+	} else {
+		f.replaced = append(f.replaced, replacedCall{name: name, count: 1})
+	}
+
+	f.cl = cl
+	f.ip = -1
+}
+
+// addToStack adds the calls executed by the frame to the stack of raised,
+// innermost first.
+func (f *Frame) addToStack(raised *object.Error) {
+	raised.AddToStack(f.cl.Fn.Name)
+	for i := len(f.replaced) - 1; i >= 0; i-- {
+		for j := 0; j < f.replaced[i].count; j++ {
+			raised.AddToStack(f.replaced[i].name)
+		}
+	}
+}
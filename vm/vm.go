@@ -0,0 +1,971 @@
+/*
+Package vm implements a stack-based virtual machine that executes the bytecode
+produced by the compiler package.
+
+To run:
+
+	machine := New(compiler.Bytecode())
+	err := machine.Run()
+	result := machine.Result()
+
+Run only returns an error when the virtual machine itself fails, for example
+when it meets an opcode it doesn't implement.  Errors raised by the program are
+not caught by Run; like evaluator.Eval, the uncaught *object.Error is the
+result of the program.
+*/
+package vm
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+// Sizes of the global variable store and of the stack and the call stack to
+// begin with.  The stack and the call stack grow as needed.
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+	FramesSize  = 64
+)
+
+// Singleton values, shared with the evaluator so that both return the same
+// objects.
+var (
+	True  = evaluator.TRUE
+	False = evaluator.FALSE
+	Null  = evaluator.NULL
+)
+
+// cell holds a local variable that a closure captured.  The slot of the
+// variable holds the cell from then on, so that the function and the closure
+// share the variable.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+
+func (c *cell) Inspect() string { return "cell" }
+
+// VM executes bytecode.  sp always points to the next free slot of the stack,
+// so the top of the stack is stack[sp-1].  MaxCallDepth is the maximum number
+// of nested function calls of the program.
+type VM struct {
+	MaxCallDepth int
+
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int
+
+	frames      []*Frame
+	framesIndex int
+	tailFrames  int
+
+	handlers []handler
+
+	// env is the environment builtins are called with.
+	env *object.Environment
+
+	result object.Object
+}
+
+// handler is an error handler installed by OpTry.  framesIndex is the number
+// of frames when it was installed, so the handler belongs to the frame at
+// framesIndex-1.
+type handler struct {
+	ip          int
+	sp          int
+	framesIndex int
+}
+
+// New returns a reference to a new VM that executes bytecode.
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, 1, FramesSize)
+	frames[0] = mainFrame
+
+	return &VM{
+		MaxCallDepth: object.DefaultMaxCallDepth,
+
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.GlobalNames,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		frames:      frames,
+		framesIndex: 1,
+
+		env: object.NewEnvironment(),
+	}
+}
+
+// SetSandbox sets the sandbox that decides which builtins the program may
+// call.
+func (vm *VM) SetSandbox(s *object.Sandbox) {
+	vm.env.SetSandbox(s)
+}
+
+// SetStreams sets the input and output of the builtins the program calls.
+func (vm *VM) SetStreams(s *object.Streams) {
+	vm.env.SetStreams(s)
+}
+
+// NewWithGlobalsStore returns a reference to a new VM that executes bytecode
+// with existing global variables.  Together with compiler.NewWithState, this
+// lets successive programs share global variables.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// Run executes the program.
+func (vm *VM) Run() error {
+	result, err := vm.run(0)
+	if err != nil {
+		return err
+	}
+
+	vm.result = result
+	return nil
+}
+
+// Result returns the value of the program after Run.  It is the value of a
+// top-level return statement, the error that wasn't caught, or else the value
+// of the last expression statement.
+func (vm *VM) Result() object.Object {
+	return vm.result
+}
+
+// run executes instructions until the frame count drops to stop, that is until
+// the frame that was on top when run was called returns.  It returns the value
+// of that frame: its return value or the error that escaped it.  The main
+// frame can also end by running out of instructions.
+func (vm *VM) run(stop int) (object.Object, error) {
+	for {
+		frame := vm.currentFrame()
+		ins := frame.Instructions()
+
+		if frame.ip >= len(ins)-1 {
+			// The last popped value is still right above the stack pointer.
+			return vm.stack[vm.sp], nil
+		}
+
+		frame.ip++
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+
+		var raised *object.Error
+		var err error
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			err = vm.push(vm.constants[constIndex])
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			right := vm.pop()
+			left := vm.pop()
+
+			result := vm.executeBinaryOperation(op, left, right)
+			raised, err = vm.pushOrRaise(result)
+
+		case code.OpCaseEqual:
+			right := vm.pop()
+			left := vm.pop()
+
+			err = vm.push(nativeBoolToBooleanObject(objectsEqual(left, right)))
+
+		case code.OpTrue:
+			err = vm.push(True)
+
+		case code.OpFalse:
+			err = vm.push(False)
+
+		case code.OpNull:
+			err = vm.push(Null)
+
+		case code.OpBang:
+			err = vm.push(nativeBoolToBooleanObject(!isTruthy(vm.pop())))
+
+		case code.OpMinus:
+			operand := vm.pop()
+
+			if operand.Type() != object.INTEGER_OBJ {
+				raised = newError(object.TYPE_ERROR, "unknown operator: -%s",
+					operand.Type())
+			} else {
+				value := operand.(*object.Integer).Value
+				err = vm.push(&object.Integer{Value: -value})
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			if !isTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			value := vm.globals[globalIndex]
+			if value == nil {
+				raised = identifierNotFound(vm.globalNames, int(globalIndex))
+			} else {
+				err = vm.push(value)
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			slot := frame.basePointer + int(localIndex)
+			if c, ok := vm.stack[slot].(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			value := vm.stack[frame.basePointer+int(localIndex)]
+			if c, ok := value.(*cell); ok {
+				value = c.value
+			}
+			if value == nil {
+				raised = identifierNotFound(frame.cl.Fn.LocalNames, int(localIndex))
+			} else {
+				err = vm.push(value)
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			if int(builtinIndex) >= len(object.Builtins) {
+				return nil, fmt.Errorf("builtin %d undefined", builtinIndex)
+			}
+			err = vm.push(object.Builtins[builtinIndex])
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			value := frame.cl.Free[freeIndex]
+			if c, ok := value.(*cell); ok {
+				value = c.value
+			}
+			if value == nil {
+				raised = identifierNotFound(frame.cl.Fn.FreeNames, int(freeIndex))
+			} else {
+				err = vm.push(value)
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			slot := frame.basePointer + int(localIndex)
+			c, ok := vm.stack[slot].(*cell)
+			if !ok {
+				c = &cell{value: vm.stack[slot]}
+				vm.stack[slot] = c
+			}
+			err = vm.push(c)
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			err = vm.push(frame.cl.Free[freeIndex])
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			frame.ip += 3
+
+			err = vm.pushClosure(int(constIndex), int(numFree))
+
+		case code.OpCurrentClosure:
+			err = vm.push(frame.cl)
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			raised, err = vm.executeCall(int(numArgs), false)
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+
+			raised, err = vm.executeTailCall(int(numArgs))
+
+		case code.OpReturnValue, code.OpReturn:
+			returnValue := object.Object(Null)
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
+			}
+
+			var done bool
+			returnValue, done, err = vm.returnFromFrame(returnValue, stop)
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return returnValue, nil
+			}
+
+			raised, err = vm.pushOrRaise(returnValue)
+
+		case code.OpNoMatch:
+			subject := vm.pop()
+			raised = newError(object.MATCH_ERROR,
+				"non-exhaustive match: no arm matches %s", subject.Inspect())
+
+		case code.OpThrow:
+			raised = thrownError(vm.pop())
+
+		case code.OpTry:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			h := handler{ip: pos, sp: vm.sp, framesIndex: vm.framesIndex}
+			vm.handlers = append(vm.handlers, h)
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpDefer:
+			cl := vm.pop().(*object.Closure)
+			frame.deferred = append(frame.deferred, cl)
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp -= numElements
+
+			err = vm.push(&object.Array{Elements: elements})
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			var hash object.Object
+			hash, raised = buildHash(vm.stack[vm.sp-numElements : vm.sp])
+			vm.sp -= numElements
+			if raised == nil {
+				err = vm.push(hash)
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			raised, err = vm.pushOrRaise(executeIndexExpression(left, index))
+
+		case code.OpGetProperty:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			name := vm.constants[nameIndex].(*object.String).Value
+			raised, err = vm.pushOrRaise(executeDotExpression(vm.pop(), name))
+
+		case code.OpMatchArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+
+			array, ok := vm.pop().(*object.Array)
+			matched := ok && (len(array.Elements) == numElements ||
+				rest && len(array.Elements) > numElements)
+			err = vm.push(nativeBoolToBooleanObject(matched))
+
+		case code.OpMatchHash:
+			_, ok := vm.pop().(*object.Hash)
+			err = vm.push(nativeBoolToBooleanObject(ok))
+
+		case code.OpHasKey:
+			key := vm.pop().(object.Hashable)
+			hash := vm.pop().(*object.Hash)
+
+			_, ok := hash.Pairs[key.HashKey()]
+			err = vm.push(nativeBoolToBooleanObject(ok))
+
+		case code.OpRest:
+			start := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			array := vm.pop().(*object.Array)
+			rest := make([]object.Object, len(array.Elements)-start)
+			copy(rest, array.Elements[start:])
+			err = vm.push(&object.Array{Elements: rest})
+
+		default:
+			def, lookupErr := code.Lookup(byte(op))
+			if lookupErr != nil {
+				return nil, lookupErr
+			}
+			return nil, fmt.Errorf("opcode %s not implemented", def.Name)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if raised != nil {
+			raised, err = vm.raise(raised, stop)
+			if err != nil {
+				return nil, err
+			}
+			if raised != nil {
+				return raised, nil
+			}
+		}
+	}
+}
+
+// executeCall calls the closure or builtin below the numArgs arguments on top
+// of the stack.  The arguments become the first local variables of the new
+// frame.  If tail is true, the new frame doesn't count towards the call depth.
+func (vm *VM) executeCall(numArgs int, tail bool) (*object.Error, error) {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	if builtin, ok := callee.(*object.Builtin); ok {
+		return vm.callBuiltin(builtin, numArgs)
+	}
+
+	cl, ok := callee.(*object.Closure)
+	if !ok {
+		return newError(object.TYPE_ERROR, "not a function: %s", callee.Type()), nil
+	}
+
+	if numArgs != cl.Fn.NumParameters {
+		return newError(object.TYPE_ERROR,
+			"wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs), nil
+	}
+
+	// The main frame doesn't count as a call.
+	if !tail && vm.framesIndex-vm.tailFrames > vm.MaxCallDepth {
+		return newError(object.RECURSION_ERROR,
+			"maximum recursion depth exceeded (%d)", vm.MaxCallDepth), nil
+	}
+
+	if err := vm.pushFrame(cl, vm.sp-numArgs); err != nil {
+		return nil, err
+	}
+	if tail {
+		vm.currentFrame().tail = true
+		vm.tailFrames++
+	}
+	return nil, nil
+}
+
+// executeTailCall makes a call whose value the current frame returns, like
+// the evaluator: a closure replaces the function of the frame, its arguments
+// replacing the local variables.  The frame is kept if it has deferred
+// closures to run after the call.
+func (vm *VM) executeTailCall(numArgs int) (*object.Error, error) {
+	frame := vm.currentFrame()
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	cl, ok := callee.(*object.Closure)
+	if !ok || numArgs != cl.Fn.NumParameters || len(frame.deferred) != 0 {
+		return vm.executeCall(numArgs, true)
+	}
+
+	// Cells keep the variables that closures captured from the frame.
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.resetLocals(cl, frame.basePointer)
+	frame.replace(cl)
+	return nil, nil
+}
+
+// callBuiltin calls builtin with the numArgs arguments on top of the stack and
+// replaces them and the builtin with its result.
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) (*object.Error, error) {
+	if raised := vm.env.Sandbox().Check(builtin); raised != nil {
+		raised.AddToStack(builtin.Name)
+		return raised, nil
+	}
+
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+	vm.sp -= numArgs + 1
+
+	result := builtin.Fn(vm.env, args...)
+	if raised, ok := result.(*object.Error); ok {
+		raised.AddToStack(builtin.Name)
+		return raised, nil
+	}
+	return nil, vm.push(canonical(result))
+}
+
+// canonical replaces the booleans and nulls returned by builtins with the
+// singletons.  Builtins may also return nil for null.
+func canonical(obj object.Object) object.Object {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return Null
+	case *object.Boolean:
+		return nativeBoolToBooleanObject(obj.Value)
+	}
+	return obj
+}
+
+// pushFrame starts executing cl.  Its local variables that aren't arguments
+// are cleared so that reading them before they are set raises an error.
+func (vm *VM) pushFrame(cl *object.Closure, basePointer int) error {
+	vm.resetLocals(cl, basePointer)
+
+	frame := NewFrame(cl, basePointer)
+	if vm.framesIndex < len(vm.frames) {
+		vm.frames[vm.framesIndex] = frame
+	} else {
+		vm.frames = append(vm.frames, frame)
+	}
+	vm.framesIndex++
+
+	return nil
+}
+
+// resetLocals clears the local variables of cl at basePointer that aren't
+// arguments and sets the stack pointer above them.
+func (vm *VM) resetLocals(cl *object.Closure, basePointer int) {
+	top := basePointer + cl.Fn.NumLocals
+	vm.growStack(top)
+
+	for i := basePointer + cl.Fn.NumParameters; i < top; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = top
+}
+
+// growStack makes sure the stack has at least size slots, plus one for the
+// last popped value.
+func (vm *VM) growStack(size int) {
+	if size < len(vm.stack) {
+		return
+	}
+
+	newSize := 2 * len(vm.stack)
+	for newSize <= size {
+		newSize *= 2
+	}
+
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
+// popFrame removes the current frame and everything it put on the stack,
+// including the closure that was called.
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	frame := vm.frames[vm.framesIndex]
+	if frame.tail {
+		vm.tailFrames--
+	}
+
+	if vm.framesIndex > 0 {
+		vm.sp = frame.basePointer - 1
+	}
+
+	return frame
+}
+
+// returnFromFrame runs the deferred closures of the current frame and removes
+// it.  An error raised by a deferred closure replaces returnValue.  done is
+// true if the frame was the one that run was called for.
+func (vm *VM) returnFromFrame(returnValue object.Object, stop int) (object.Object, bool, error) {
+	frame := vm.currentFrame()
+
+	returnValue, err := vm.runDeferred(frame, returnValue)
+	if err != nil {
+		return nil, false, err
+	}
+
+	vm.popFrame()
+
+	if vm.framesIndex == stop {
+		return returnValue, true, nil
+	}
+
+	if raised, ok := returnValue.(*object.Error); ok {
+		frame.addToStack(raised)
+	}
+
+	return returnValue, false, nil
+}
+
+// runDeferred calls the deferred closures of frame in last-in-first-out order.
+// Their values are discarded, but an error raised by one of them replaces
+// result.
+func (vm *VM) runDeferred(frame *Frame, result object.Object) (object.Object, error) {
+	for i := len(frame.deferred) - 1; i >= 0; i-- {
+		cl := frame.deferred[i]
+
+		if err := vm.push(cl); err != nil {
+			return nil, err
+		}
+
+		stop := vm.framesIndex
+		if err := vm.pushFrame(cl, vm.sp); err != nil {
+			return nil, err
+		}
+
+		value, err := vm.run(stop)
+		if err != nil {
+			return nil, err
+		}
+
+		if raised, ok := value.(*object.Error); ok {
+			result = raised
+		}
+	}
+
+	frame.deferred = nil
+	return result, nil
+}
+
+// raise looks for the innermost handler installed since run was called with
+// stop.  The frames above the handler are removed, running their deferred
+// closures, before execution continues at the handler with the error pushed as
+// an exception.  If there is no such handler, all frames down to stop are
+// removed and the error that escaped them is returned.
+func (vm *VM) raise(raised *object.Error, stop int) (*object.Error, error) {
+	for {
+		n := len(vm.handlers)
+		if n > 0 && vm.handlers[n-1].framesIndex > stop {
+			h := vm.handlers[n-1]
+
+			if vm.framesIndex == h.framesIndex {
+				vm.handlers = vm.handlers[:n-1]
+				vm.sp = h.sp
+				vm.currentFrame().ip = h.ip - 1
+
+				return nil, vm.push(&object.Exception{Error: raised})
+			}
+		} else if vm.framesIndex == stop {
+			return raised, nil
+		}
+
+		var err error
+		raised, err = vm.unwindFrame(raised, stop)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// unwindFrame removes the current frame because of raised.  It returns the
+// error to keep raising, which is raised unless a deferred closure raised
+// another one.
+func (vm *VM) unwindFrame(raised *object.Error, stop int) (*object.Error, error) {
+	frame := vm.currentFrame()
+
+	result, err := vm.runDeferred(frame, raised)
+	if err != nil {
+		return nil, err
+	}
+	raised = result.(*object.Error)
+
+	vm.popFrame()
+
+	// The frame run was called for is the main program or a deferred closure,
+	// neither of which the evaluator records in the stack.
+	if vm.framesIndex != stop {
+		frame.addToStack(raised)
+	}
+
+	return raised, nil
+}
+
+// pushClosure creates a closure over the compiled function constant at
+// constIndex that captures the numFree variables on top of the stack.  They
+// are cells, except for a closure that captures the function it is defined
+// in.
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+// executeBinaryOperation mirrors the evaluator's infix expressions.
+func (vm *VM) executeBinaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	operator := binaryOperators[op]
+
+	if host, ok := left.(object.Operator); ok {
+		if result, ok := host.Operate(operator, right); ok {
+			return canonical(result)
+		}
+	}
+
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return executeIntegerOperation(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return executeStringOperation(operator, left, right)
+	case left.Type() != right.Type():
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s",
+			left.Type(), operator, right.Type())
+	case op == code.OpEqual:
+		return nativeBoolToBooleanObject(left == right)
+	case op == code.OpNotEqual:
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// executeStringOperation mirrors the evaluator's infix expressions on strings.
+func executeStringOperation(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// buildHash returns a hash of the keys and values in elements, each key
+// followed by its value.
+func buildHash(elements []object.Object) (object.Object, *object.Error) {
+	pairs := map[object.HashKey]object.HashPair{}
+
+	for i := 0; i < len(elements); i += 2 {
+		key, value := elements[i], elements[i+1]
+
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+		}
+		pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+// executeIndexExpression mirrors the evaluator's index expressions.
+func executeIndexExpression(left, index object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError(object.TYPE_ERROR, "array index must be INTEGER, got %s",
+				index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return Null
+		}
+		return left.Elements[i.Value]
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
+		}
+		if pair, ok := left.Pairs[key.HashKey()]; ok {
+			return pair.Value
+		}
+		return Null
+
+	case object.Indexer:
+		return canonical(left.Index(index))
+	}
+
+	return newError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
+}
+
+// executeDotExpression mirrors the evaluator's dot expressions.
+func executeDotExpression(obj object.Object, name string) object.Object {
+	if getter, ok := obj.(object.PropertyGetter); ok {
+		if val, ok := getter.GetProperty(name); ok {
+			return canonical(val)
+		}
+	}
+
+	if caller, ok := obj.(object.MethodCaller); ok && caller.HasMethod(name) {
+		fn := func(env *object.Environment, args ...object.Object) object.Object {
+			return caller.CallMethod(name, args)
+		}
+		return &object.Builtin{Name: name, Fn: fn}
+	}
+
+	return newError(object.NAME_ERROR, "property not found: %s.%s", obj.Type(), name)
+}
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+func executeIntegerOperation(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	switch operator {
+	case "+":
+		return &object.Integer{Value: leftVal + rightVal}
+	case "-":
+		return &object.Integer{Value: leftVal - rightVal}
+	case "*":
+		return &object.Integer{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return &object.Integer{Value: leftVal / rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	default:
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	}
+}
+
+// objectsEqual reports whether a and b have the same type and are equal.
+func objectsEqual(a, b object.Object) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *object.Integer:
+		return a.Value == b.(*object.Integer).Value
+	case *object.String:
+		return a.Value == b.(*object.String).Value
+	}
+
+	return a == b
+}
+
+// thrownError returns the error raised by throwing val.
+func thrownError(val object.Object) *object.Error {
+	if exception, ok := val.(*object.Exception); ok {
+		return exception.Error
+	}
+
+	err := newError(object.THROWN_ERROR, "%s", val.Inspect())
+	err.Value = val
+	return err
+}
+
+func identifierNotFound(names []string, index int) *object.Error {
+	return newError(object.NAME_ERROR, "identifier not found: %s", names[index])
+}
+
+func newError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+// pushOrRaise pushes obj, unless it is an error, which is returned to be
+// raised instead.
+func (vm *VM) pushOrRaise(obj object.Object) (*object.Error, error) {
+	if raised, ok := obj.(*object.Error); ok {
+		return raised, nil
+	}
+	return nil, vm.push(obj)
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) push(o object.Object) error {
+	vm.growStack(vm.sp + 1)
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
@@ -0,0 +1,443 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"2", 2},
+		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"1 * 2", 2},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-5", -5},
+		{"-50 + 100 + -50", 0},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 2", true},
+		{"true == true", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"!true", false},
+		{"!!5", true},
+		{"!(if (false) { 5; })", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (true) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 } ", 20},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 > 2) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"1 > 2 ? 10 : 20", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = 2; one + two", 3},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let x = 1; let x = x + 1; x", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{`
+let globalNum = 10;
+let minusOne = fn() { let num = 1; globalNum - num; };
+let minusTwo = fn() { let num = 2; globalNum - num; };
+minusOne() + minusTwo();`, 17},
+		{"let returnsOne = fn() { 1; }; let f = fn() { returnsOne; }; f()()", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+let newClosure = fn(a) { fn() { a; }; };
+let closure = newClosure(99);
+closure();`, 99},
+		{`
+let newAdderOuter = fn(a, b) {
+	let c = a + b;
+	fn(d) { let e = d + c; fn(f) { e + f; }; };
+};
+let newAdderInner = newAdderOuter(1, 2);
+let adder = newAdderInner(3);
+adder(8);`, 14},
+		{`
+let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+countDown(1);`, 0},
+		{`
+let wrapper = fn() {
+	let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+	countDown(1);
+};
+wrapper();`, 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 + true; 5", &object.Error{Kind: object.TYPE_ERROR,
+			Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{"-true", &object.Error{Kind: object.TYPE_ERROR,
+			Message: "unknown operator: -BOOLEAN"}},
+		{"1 / 0", &object.Error{Kind: object.ZERO_DIVISION_ERROR,
+			Message: "division by zero"}},
+		{"foobar", &object.Error{Kind: object.NAME_ERROR,
+			Message: "identifier not found: foobar"}},
+		{"let f = fn() { let x = y; let y = 1; x }; f()", &object.Error{
+			Kind: object.NAME_ERROR, Message: "identifier not found: y"}},
+		{"1()", &object.Error{Kind: object.TYPE_ERROR,
+			Message: "not a function: INTEGER"}},
+		{"fn(a) { a }()", &object.Error{Kind: object.TYPE_ERROR,
+			Message: "wrong number of arguments: want=1, got=0"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `
+let inner = fn() { 1 / 0 };
+let outer = fn() { inner() };
+outer();`
+
+	result, err := runVm(input)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", result, result)
+	}
+
+	expected := []string{"inner", "outer"}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. want=%q, got=%q", expected, errObj.Stack)
+	}
+
+	for i, frame := range expected {
+		if errObj.Stack[i] != frame {
+			t.Errorf("wrong stack frame %d. want=%q, got=%q", i, frame,
+				errObj.Stack[i])
+		}
+	}
+}
+
+func TestRecursionLimit(t *testing.T) {
+	input := "let f = fn(x) { 1 + f(x + 1) }; f(0)"
+
+	result, err := runVm(input)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.Kind != object.RECURSION_ERROR {
+		t.Fatalf("expected a recursion error. got=%T(%+v)", result, result)
+	}
+
+	expectedMessage := fmt.Sprintf("maximum recursion depth exceeded (%d)",
+		object.DefaultMaxCallDepth)
+	if errObj.Message != expectedMessage {
+		t.Errorf("wrong error message. want=%q, got=%q", expectedMessage,
+			errObj.Message)
+	}
+
+	if len(errObj.Stack) != object.MaxErrorStack || errObj.Stack[0] != "f" {
+		t.Errorf("wrong stack. got=%q", errObj.Stack)
+	}
+
+	caught := "let f = fn(x) { 1 + f(x + 1) }; try { f(0) } catch (e) { 5 }"
+	runVmTests(t, []vmTestCase{{caught, 5}})
+
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { x == 0 ? 0 : 1 + f(x - 1) }; [f(50), f(51)]")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	machine := New(comp.Bytecode())
+	machine.MaxCallDepth = 51
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	errObj, ok = machine.Result().(*object.Error)
+	if !ok || errObj.Message != "maximum recursion depth exceeded (51)" {
+		t.Errorf("expected a recursion error. got=%+v", machine.Result())
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{"len([1, 2, 3])", 3},
+		{"len(1)", &object.Error{Kind: object.TYPE_ERROR,
+			Message: "argument to `len` not supported, got INTEGER"}},
+		{`len("one", "two")`, &object.Error{Kind: object.TYPE_ERROR,
+			Message: "wrong number of arguments. got=2, want=1"}},
+		{"first([1, 2, 3])", 1},
+		{"first([])", Null},
+		{"last([1, 2, 3])", 3},
+		{"rest([1, 2, 3])", []int{2, 3}},
+		{"push([], 1)", []int{1}},
+		{"let f = fn(a) { len(a) }; f([1, 2])", 2},
+		{"let len = fn(a) { 0 }; len([1])", 0},
+		{"try { len(1) } catch (e) { 5 }", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinStreamsAndSandbox(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(`puts(input() * 2); eputs("done"); now()`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	machine := New(comp.Bytecode())
+	machine.SetStreams(object.NewStreams(strings.NewReader("21\n"), &out))
+	machine.SetSandbox(object.NewSandbox(object.IO_CAPABILITY))
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if out.String() != "42\ndone\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	errObj, ok := machine.Result().(*object.Error)
+	if !ok || errObj.Kind != object.CAPABILITY_ERROR {
+		t.Fatalf("expected a capability error. got=%+v", machine.Result())
+	}
+	if errObj.Message != "capability denied: now needs the time capability" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" - "a"`, &object.Error{Kind: object.TYPE_ERROR,
+			Message: "unknown operator: STRING - STRING"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayAndHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+		{"[1, 2, 3][1]", 2},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+		{"[1][true]", &object.Error{Kind: object.TYPE_ERROR,
+			Message: "array index must be INTEGER, got BOOLEAN"}},
+		{`{1: 1, 2: 2}[1]`, 1},
+		{`{"a": 1 + 1, true: 3}["a"]`, 2},
+		{`{1: 1}[0]`, Null},
+		{`{}[0]`, Null},
+		{`{[1]: 1}`, &object.Error{Kind: object.TYPE_ERROR,
+			Message: "unusable as hash key: ARRAY"}},
+		{"1[0]", &object.Error{Kind: object.TYPE_ERROR,
+			Message: "index operator not supported: INTEGER"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		result, err := runVm(tt.input)
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		if err := testExpectedObject(tt.expected, result); err != nil {
+			t.Errorf("wrong result for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func runVm(input string) (object.Object, error) {
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		return nil, fmt.Errorf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+
+	return vm.Result(), nil
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testExpectedObject(expected interface{}, actual object.Object) error {
+	switch expected := expected.(type) {
+	case int:
+		return testIntegerObject(int64(expected), actual)
+
+	case bool:
+		return testBooleanObject(expected, actual)
+
+	case string:
+		str, ok := actual.(*object.String)
+		if !ok {
+			return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+		}
+		if str.Value != expected {
+			return fmt.Errorf("object has wrong value. got=%q, want=%q",
+				str.Value, expected)
+		}
+
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			return fmt.Errorf("object is not Array. got=%T (%+v)", actual, actual)
+		}
+		if len(array.Elements) != len(expected) {
+			return fmt.Errorf("wrong number of elements. want=%d, got=%d",
+				len(expected), len(array.Elements))
+		}
+		for i, e := range expected {
+			if err := testIntegerObject(int64(e), array.Elements[i]); err != nil {
+				return fmt.Errorf("element %d: %s", i, err)
+			}
+		}
+
+	case *object.Null:
+		if _, ok := actual.(*object.Null); !ok {
+			return fmt.Errorf("object is not Null. got=%T (%+v)", actual, actual)
+		}
+
+	case *object.Error:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			return fmt.Errorf("object is not Error. got=%T (%+v)", actual, actual)
+		}
+		return testError(expected, errObj)
+
+	case *object.Exception:
+		exception, ok := actual.(*object.Exception)
+		if !ok {
+			return fmt.Errorf("object is not Exception. got=%T (%+v)",
+				actual, actual)
+		}
+		return testError(expected.Error, exception.Error)
+
+	default:
+		return fmt.Errorf("unsupported expected value %T", expected)
+	}
+
+	return nil
+}
+
+func testIntegerObject(expected int64, actual object.Object) error {
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("object is not Integer. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%d, want=%d",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
+func testBooleanObject(expected bool, actual object.Object) error {
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		return fmt.Errorf("object is not Boolean. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%t, want=%t",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
+func testError(expected, actual *object.Error) error {
+	if actual.Kind != expected.Kind || actual.Message != expected.Message {
+		return fmt.Errorf("wrong error. want=%s: %s, got=%s: %s",
+			expected.Kind, expected.Message, actual.Kind, actual.Message)
+	}
+
+	if expected.Value != nil {
+		return testExpectedObject(int(expected.Value.(*object.Integer).Value),
+			actual.Value)
+	}
+
+	return nil
+}